@@ -2,25 +2,43 @@ package cli
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
 	"github.com/eliasfloreteng/github-auto-deployer/internal/git"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/github"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/logging"
 	"github.com/eliasfloreteng/github-auto-deployer/internal/webhook"
 	"github.com/eliasfloreteng/github-auto-deployer/pkg/systemd"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 )
 
+var configFlag string
+
+// serviceName is the systemd unit managed by the service commands
+var serviceName = systemd.DefaultName
+
 var rootCmd = &cobra.Command{
 	Use:   "deployer",
 	Short: "GitHub Auto Deployer - Automatically deploy on push",
 	Long:  `A tool that watches git repositories and automatically pulls changes and runs commands when pushes are detected via GitHub webhooks.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if configFlag != "" {
+			config.SetConfigPath(configFlag)
+		}
+	},
 }
 
 var initCmd = &cobra.Command{
@@ -56,28 +74,49 @@ var uninstallCmd = &cobra.Command{
 	},
 }
 
+var (
+	startLogFile string
+	startOnce    bool
+)
+
 var startCmd = &cobra.Command{
 	Use:   "start",
 	Short: "Start the webhook server",
-	Long:  `Start the webhook server to listen for GitHub push events.`,
+	Long: `Start the webhook server to listen for GitHub push events.
+
+With --once the server exits after the first deployment, with a non-zero
+status if it failed.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := runStart(); err != nil {
+		if err := runStart(startLogFile, startOnce); err != nil {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	},
 }
 
+// addFolderOptions holds values for the add command given as flags
+type addFolderOptions struct {
+	path     string
+	commands []string
+	branch   string
+	cloneURL string
+}
+
+var addOpts addFolderOptions
+
 var addCmd = &cobra.Command{
 	Use:   "add [path]",
 	Short: "Add a folder to watch",
-	Long:  `Add a git repository folder to watch for changes. If no path is provided, uses current directory.`,
-	Args:  cobra.MaximumNArgs(1),
+	Long: `Add a git repository folder to watch for changes. If no path is provided, uses current directory.
+
+When --path is given, no prompts are shown: the branch is detected and the
+command is suggested automatically unless --branch or --command are set.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		var path string
 		if len(args) > 0 {
 			path = args[0]
 		}
-		if err := runAddFolder(path); err != nil {
+		if err := runAddFolder(path, addOpts); err != nil {
 			log.Fatalf("Failed to add folder: %v", err)
 		}
 	},
@@ -105,6 +144,121 @@ var removeCmd = &cobra.Command{
 	},
 }
 
+var editCmd = &cobra.Command{
+	Use:     "edit",
+	Aliases: []string{"edit-folder"},
+	Short:   "Edit a watched folder",
+	Long:    `Change the command, branch or timeout of a watched folder.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runEditFolder(); err != nil {
+			log.Fatalf("Failed to edit folder: %v", err)
+		}
+	},
+}
+
+var (
+	deployPath string
+	deployTag  string
+	deployDry  bool
+)
+
+var deployCmd = &cobra.Command{
+	Use:   "deploy",
+	Short: "Deploy a watched folder now",
+	Long:  `Pull the latest changes and run the configured command for a watched folder, without waiting for a push.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runDeploy(deployPath, deployTag, deployDry); err != nil {
+			log.Fatalf("Deployment failed: %v", err)
+		}
+	},
+}
+
+var (
+	historyPath  string
+	historyLimit int
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "List recent deployments",
+	Long:  `List the most recent deployments recorded by the server, newest first.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runHistory(historyPath, historyLimit); err != nil {
+			log.Fatalf("Failed to read deployment history: %v", err)
+		}
+	},
+}
+
+var enableCmd = &cobra.Command{
+	Use:     "enable [path]",
+	Aliases: []string{"enable-folder"},
+	Short:   "Resume deployments of a watched folder",
+	Long:    `Enable a watched folder that was disabled, so pushes deploy it again.`,
+	Args:    cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var path string
+		if len(args) > 0 {
+			path = args[0]
+		}
+		if err := runSetFolderEnabled(path, true); err != nil {
+			log.Fatalf("Failed to enable folder: %v", err)
+		}
+	},
+}
+
+var disableCmd = &cobra.Command{
+	Use:     "disable [path]",
+	Aliases: []string{"disable-folder"},
+	Short:   "Pause deployments of a watched folder",
+	Long:    `Disable a watched folder without removing it: pushes are ignored until it is enabled again.`,
+	Args:    cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var path string
+		if len(args) > 0 {
+			path = args[0]
+		}
+		if err := runSetFolderEnabled(path, false); err != nil {
+			log.Fatalf("Failed to disable folder: %v", err)
+		}
+	},
+}
+
+var startServiceCmd = &cobra.Command{
+	Use:   "start-service",
+	Short: "Start the systemd service",
+	Long:  `Start the installed systemd service. Use 'start' to run the server in the foreground instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := systemd.Start(serviceName); err != nil {
+			log.Fatalf("Failed to start service: %v", err)
+		}
+		fmt.Println("Service started.")
+	},
+}
+
+var stopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the systemd service",
+	Long:  `Stop the installed systemd service.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := systemd.Stop(serviceName); err != nil {
+			log.Fatalf("Failed to stop service: %v", err)
+		}
+		fmt.Println("Service stopped.")
+	},
+}
+
+var restartCmd = &cobra.Command{
+	Use:   "restart",
+	Short: "Restart the systemd service",
+	Long:  `Restart the installed systemd service, e.g. to apply configuration changes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := systemd.Restart(serviceName); err != nil {
+			log.Fatalf("Failed to restart service: %v", err)
+		}
+		fmt.Println("Service restarted.")
+	},
+}
+
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Check service status",
@@ -116,7 +270,25 @@ var statusCmd = &cobra.Command{
 	},
 }
 
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the configuration file",
+}
+
+var configRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore the previous configuration",
+	Long:  `Replace the configuration with the backup kept by the last change. Run it again to undo the restore.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runRestoreConfig(); err != nil {
+			log.Fatalf("Failed to restore configuration: %v", err)
+		}
+	},
+}
+
 func init() {
+	rootCmd.PersistentFlags().StringVar(&configFlag, "config", "", "Path to the configuration file, .json or .yaml (default: /etc/github-deployer/config.json or ~/.github-deployer/config.json)")
+
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(installCmd)
 	rootCmd.AddCommand(uninstallCmd)
@@ -124,7 +296,38 @@ func init() {
 	rootCmd.AddCommand(addCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(removeCmd)
+	rootCmd.AddCommand(editCmd)
+	rootCmd.AddCommand(deployCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(enableCmd)
+	rootCmd.AddCommand(disableCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(startServiceCmd)
+	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(restartCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(versionCmd)
+
+	configCmd.AddCommand(configRestoreCmd)
+
+	addCmd.Flags().StringVar(&addOpts.path, "path", "", "Repository path (skips all prompts)")
+	addCmd.Flags().StringArrayVar(&addOpts.commands, "command", nil, "Command to execute after pull (repeat to run several in order)")
+	addCmd.Flags().StringVar(&addOpts.branch, "branch", "", "Branch to watch, or a comma-separated list of branches and globs such as main,release/* (default: current branch)")
+	addCmd.Flags().StringVar(&addOpts.cloneURL, "clone-url", "", "Clone this repository into the path if it is not a git repository yet")
+
+	for _, cmd := range []*cobra.Command{installCmd, uninstallCmd, statusCmd, startServiceCmd, stopCmd, restartCmd} {
+		cmd.Flags().StringVar(&serviceName, "name", systemd.DefaultName, "Name of the systemd service, to run several instances on one host")
+	}
+
+	startCmd.Flags().StringVar(&startLogFile, "log-file", "", "Also write logs to this file, rotated at 10 MB (overrides server.log_file)")
+	startCmd.Flags().BoolVar(&startOnce, "once", false, "Exit after the first deployment, with its result as exit status")
+
+	deployCmd.Flags().StringVar(&deployPath, "path", "", "Path of the watched folder to deploy")
+	historyCmd.Flags().StringVar(&historyPath, "path", "", "Only list deployments of this folder")
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 20, "Number of deployments to list (0 for all)")
+	deployCmd.Flags().StringVar(&deployTag, "tag", "", "Tag to deploy (for folders triggered by tags or releases)")
+	deployCmd.Flags().BoolVar(&deployDry, "dry-run", false, "Show what the deployment would do without running git or any command")
 }
 
 // Execute runs the CLI
@@ -139,72 +342,125 @@ func runInit() error {
 	fmt.Println("==========================================")
 	fmt.Println()
 
-	// GitHub App Configuration
-	fmt.Println("GitHub App Configuration:")
-	fmt.Print("App ID: ")
+	// GitHub App Configuration, not needed when deploying only from other
+	// hosts such as GitLab
+	fmt.Println("GitHub App Configuration (skip if no repository is on github.com):")
+	fmt.Print("App ID (press Enter to skip the GitHub App): ")
 	appIDStr, _ := reader.ReadString('\n')
-	appID, err := strconv.ParseInt(strings.TrimSpace(appIDStr), 10, 64)
-	if err != nil {
-		return fmt.Errorf("invalid App ID: %w", err)
-	}
-
-	fmt.Print("Private Key Path (absolute path): ")
-	privateKeyPath, _ := reader.ReadString('\n')
-	privateKeyPath = strings.TrimSpace(privateKeyPath)
+	appIDStr = strings.TrimSpace(appIDStr)
 
-	// Expand ~ to home directory
-	if strings.HasPrefix(privateKeyPath, "~") {
-		home, err := os.UserHomeDir()
+	var appID, installationID int64
+	var privateKeyPath string
+	var err error
+	if appIDStr != "" {
+		appID, err = strconv.ParseInt(appIDStr, 10, 64)
 		if err != nil {
-			return fmt.Errorf("failed to get home directory: %w", err)
+			return fmt.Errorf("invalid App ID: %w", err)
+		}
+
+		fmt.Print("Private Key Path (absolute path): ")
+		privateKeyPath, _ = reader.ReadString('\n')
+		privateKeyPath = strings.TrimSpace(privateKeyPath)
+
+		// Expand ~ to home directory
+		if strings.HasPrefix(privateKeyPath, "~") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return fmt.Errorf("failed to get home directory: %w", err)
+			}
+			privateKeyPath = filepath.Join(home, privateKeyPath[1:])
+		}
+
+		// Verify private key exists
+		if _, err := os.Stat(privateKeyPath); err != nil {
+			return fmt.Errorf("private key file not found: %w", err)
 		}
-		privateKeyPath = filepath.Join(home, privateKeyPath[1:])
-	}
 
-	// Verify private key exists
-	if _, err := os.Stat(privateKeyPath); err != nil {
-		return fmt.Errorf("private key file not found: %w", err)
+		installationID, err = selectInstallation(reader, appID, privateKeyPath)
+		if err != nil {
+			return err
+		}
 	}
 
 	fmt.Print("Webhook Secret: ")
 	webhookSecret, _ := reader.ReadString('\n')
 	webhookSecret = strings.TrimSpace(webhookSecret)
+	if webhookSecret == "" {
+		return fmt.Errorf("webhook secret is required")
+	}
 
 	fmt.Println()
 
 	// SMTP Configuration
-	fmt.Println("SMTP Configuration (for failure notifications):")
-	fmt.Print("SMTP Host: ")
+	fmt.Println("SMTP Configuration (optional, for failure notifications):")
+	fmt.Print("SMTP Host (press Enter to skip email notifications): ")
 	smtpHost, _ := reader.ReadString('\n')
 	smtpHost = strings.TrimSpace(smtpHost)
 
-	fmt.Print("SMTP Port: ")
-	smtpPortStr, _ := reader.ReadString('\n')
-	smtpPort, err := strconv.Atoi(strings.TrimSpace(smtpPortStr))
-	if err != nil {
-		return fmt.Errorf("invalid SMTP port: %w", err)
+	smtpConfig := config.SMTPConfig{Host: smtpHost}
+	if smtpHost != "" {
+		fmt.Print("SMTP Port: ")
+		smtpPortStr, _ := reader.ReadString('\n')
+		smtpConfig.Port, err = strconv.Atoi(strings.TrimSpace(smtpPortStr))
+		if err != nil {
+			return fmt.Errorf("invalid SMTP port: %w", err)
+		}
+
+		fmt.Print("SMTP Username (press Enter for relays without authentication): ")
+		smtpUsername, _ := reader.ReadString('\n')
+		smtpConfig.Username = strings.TrimSpace(smtpUsername)
+
+		if smtpConfig.Username != "" {
+			fmt.Print("SMTP Password: ")
+			smtpPassword, _ := reader.ReadString('\n')
+			smtpConfig.Password = strings.TrimSpace(smtpPassword)
+		}
+
+		fmt.Print("From Email: ")
+		fromEmail, _ := reader.ReadString('\n')
+		smtpConfig.From = strings.TrimSpace(fromEmail)
+
+		fmt.Print("To Email (for notifications, comma-separated for several): ")
+		toEmail, _ := reader.ReadString('\n')
+		smtpConfig.To = strings.TrimSpace(toEmail)
 	}
 
-	fmt.Print("SMTP Username: ")
-	smtpUsername, _ := reader.ReadString('\n')
-	smtpUsername = strings.TrimSpace(smtpUsername)
+	fmt.Println()
 
-	fmt.Print("SMTP Password: ")
-	smtpPassword, _ := reader.ReadString('\n')
-	smtpPassword = strings.TrimSpace(smtpPassword)
+	// Slack Configuration
+	fmt.Println("Slack Configuration (optional):")
+	fmt.Print("Slack Incoming Webhook URL (press Enter to skip): ")
+	slackWebhookURL, _ := reader.ReadString('\n')
+	slackWebhookURL = strings.TrimSpace(slackWebhookURL)
 
-	fmt.Print("From Email: ")
-	fromEmail, _ := reader.ReadString('\n')
-	fromEmail = strings.TrimSpace(fromEmail)
+	fmt.Println()
 
-	fmt.Print("To Email (for notifications): ")
-	toEmail, _ := reader.ReadString('\n')
-	toEmail = strings.TrimSpace(toEmail)
+	// Telegram Configuration
+	fmt.Println("Telegram Configuration (optional):")
+	fmt.Print("Telegram Bot Token (press Enter to skip): ")
+	telegramBotToken, _ := reader.ReadString('\n')
+	telegramBotToken = strings.TrimSpace(telegramBotToken)
+
+	var telegramChatID string
+	if telegramBotToken != "" {
+		fmt.Print("Telegram Chat ID: ")
+		telegramChatID, _ = reader.ReadString('\n')
+		telegramChatID = strings.TrimSpace(telegramChatID)
+	}
+
+	if smtpConfig.Host == "" && slackWebhookURL == "" && telegramBotToken == "" {
+		fmt.Println("Note: no notification channel configured. Set webhook_notify.url in the")
+		fmt.Println("configuration before starting the server, which requires at least one channel.")
+	}
 
 	fmt.Println()
 
 	// Server Configuration
 	fmt.Println("Server Configuration:")
+	fmt.Print("Webhook Server Host (default all interfaces, e.g. 127.0.0.1 behind a proxy): ")
+	host, _ := reader.ReadString('\n')
+	host = strings.TrimSpace(host)
+
 	fmt.Print("Webhook Server Port (default 8080): ")
 	portStr, _ := reader.ReadString('\n')
 	portStr = strings.TrimSpace(portStr)
@@ -218,22 +474,28 @@ func runInit() error {
 
 	// Create configuration
 	cfg := &config.Config{
+		Version: config.CurrentVersion,
 		GitHub: config.GitHubConfig{
 			AppID:          appID,
 			PrivateKeyPath: privateKeyPath,
 			WebhookSecret:  webhookSecret,
+			InstallationID: installationID,
+		},
+		SMTP: smtpConfig,
+		Slack: config.SlackConfig{
+			WebhookURL: slackWebhookURL,
 		},
-		SMTP: config.SMTPConfig{
-			Host:     smtpHost,
-			Port:     smtpPort,
-			Username: smtpUsername,
-			Password: smtpPassword,
-			From:     fromEmail,
-			To:       toEmail,
+		Telegram: config.TelegramConfig{
+			BotToken: telegramBotToken,
+			ChatID:   telegramChatID,
 		},
 		Server: config.ServerConfig{
+			Host: host,
 			Port: port,
 		},
+		Git: config.GitConfig{
+			MaxRetries: config.DefaultMaxRetries,
+		},
 		Folders: []config.WatchedFolder{},
 	}
 
@@ -249,6 +511,42 @@ func runInit() error {
 	return nil
 }
 
+// selectInstallation looks up where the GitHub App is installed and lets the
+// user pick an installation, selecting it automatically if there is only one
+func selectInstallation(reader *bufio.Reader, appID int64, privateKeyPath string) (int64, error) {
+	appClient, err := github.NewAppClient(appID, privateKeyPath, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create GitHub App client: %w", err)
+	}
+
+	installations, err := appClient.ListInstallations()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(installations) == 0 {
+		return 0, fmt.Errorf("the GitHub App is not installed on any account. Install it first (see docs/GITHUB_APP_SETUP.md)")
+	}
+
+	if len(installations) == 1 {
+		fmt.Printf("Using installation on %s (ID: %d)\n", installations[0].Account.Login, installations[0].ID)
+		return installations[0].ID, nil
+	}
+
+	fmt.Println("The GitHub App is installed on multiple accounts:")
+	for i, installation := range installations {
+		fmt.Printf("%d. %s (ID: %d)\n", i+1, installation.Account.Login, installation.ID)
+	}
+	fmt.Print("Select installation: ")
+	numStr, _ := reader.ReadString('\n')
+	num, err := strconv.Atoi(strings.TrimSpace(numStr))
+	if err != nil || num < 1 || num > len(installations) {
+		return 0, fmt.Errorf("invalid selection")
+	}
+
+	return installations[num-1].ID, nil
+}
+
 func runInstall() error {
 	// Check if config exists
 	if !config.Exists() {
@@ -263,13 +561,13 @@ func runInstall() error {
 
 	fmt.Println("Installing systemd user service...")
 
-	if err := systemd.Install(execPath); err != nil {
+	if err := systemd.Install(serviceName, execPath, config.GetConfigPath(), config.EnvOverrides()); err != nil {
 		return err
 	}
 
 	fmt.Println("Service installed successfully!")
-	fmt.Println("To start the service: systemctl --user start github-deployer")
-	fmt.Println("To view logs: journalctl --user -u github-deployer -f")
+	fmt.Printf("To start the service: systemctl --user start %s\n", serviceName)
+	fmt.Printf("To view logs: journalctl --user -u %s -f\n", serviceName)
 	fmt.Println("To enable on boot: loginctl enable-linger $USER")
 
 	return nil
@@ -278,7 +576,7 @@ func runInstall() error {
 func runUninstall() error {
 	fmt.Println("Uninstalling systemd service...")
 
-	if err := systemd.Uninstall(); err != nil {
+	if err := systemd.Uninstall(serviceName); err != nil {
 		return err
 	}
 
@@ -286,42 +584,184 @@ func runUninstall() error {
 	return nil
 }
 
-func runStart() error {
+func runStart(logFile string, once bool) error {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		return err
 	}
 
+	// Tee logs to a rotating file, e.g. on hosts without journald
+	var logOutput io.Writer = os.Stderr
+	if logFile == "" {
+		logFile = cfg.Server.LogFile
+	}
+	if logFile != "" {
+		file, err := logging.NewRotatingFile(logFile, logging.DefaultMaxSize, logging.DefaultMaxBackups)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		logOutput = io.MultiWriter(os.Stderr, file)
+		log.SetOutput(logOutput)
+	}
+	if err := logging.Setup(logOutput, cfg.Server.LogFormat); err != nil {
+		return err
+	}
+
+	// Refuse to start a server that would only fail once a webhook arrives
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration, not starting:\n%w", err)
+	}
+
+	// Fail with a clear message instead of "address already in use" once
+	// the server starts
+	if err := cfg.Server.CheckPortAvailable(); err != nil {
+		return err
+	}
+
 	// Create webhook handler
 	handler := webhook.NewHandler(cfg)
 
+	// A nil channel never fires, so without --once the server runs until
+	// it is stopped
+	var results chan webhook.DeployResult
+	if once {
+		results = make(chan webhook.DeployResult, 1)
+		handler.SetResults(results)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/webhook", handler)
+	mux.Handle("/deploy", handler.DeployHandler())
+	mux.Handle("/status", handler.StatusHandler())
+	mux.Handle("/health", handler.HealthHandler())
+	mux.Handle("/reload", handler.ReloadHandler(func() (int, error) {
+		return reloadConfig(handler, cfg.Server)
+	}))
+	mux.Handle("/metrics", promhttp.Handler())
+
 	// Start server
-	addr := fmt.Sprintf(":%d", cfg.Server.Port)
-	log.Printf("Starting webhook server on %s", addr)
+	addr := cfg.Server.Address()
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	log.Printf("Starting %s", versionString())
+	if cfg.Server.TLSEnabled() {
+		log.Printf("Starting webhook server on %s (HTTPS)", addr)
+	} else {
+		log.Printf("Starting webhook server on %s", addr)
+	}
 	log.Printf("Watching %d folder(s)", len(cfg.Folders))
+	if once {
+		log.Printf("Exiting after the first deployment (--once)")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Reload the configuration on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	go func() {
+		for range reload {
+			reloadConfig(handler, cfg.Server)
+		}
+	}()
 
-	http.Handle("/webhook", handler)
+	serverErr := make(chan error, 1)
+	go func() {
+		if cfg.Server.TLSEnabled() {
+			serverErr <- server.ListenAndServeTLS(cfg.Server.TLSCertPath, cfg.Server.TLSKeyPath)
+		} else {
+			serverErr <- server.ListenAndServe()
+		}
+	}()
 
-	if err := http.ListenAndServe(addr, nil); err != nil {
+	var result *webhook.DeployResult
+	select {
+	case err := <-serverErr:
 		return fmt.Errorf("server error: %w", err)
+	case <-ctx.Done():
+	case r := <-results:
+		result = &r
 	}
 
+	// Stop accepting webhooks, then let running deployments finish
+	gracePeriod := cfg.Server.GetShutdownGracePeriod()
+	log.Printf("Shutting down, waiting up to %v for running deployments", gracePeriod)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("server shutdown failed: %w", err)
+	}
+
+	if err := handler.Wait(shutdownCtx); err != nil {
+		return fmt.Errorf("deployments still running after %v: %w", gracePeriod, err)
+	}
+
+	log.Printf("Server stopped")
+
+	if result != nil {
+		status := result.Status
+		fmt.Printf("Deployment of %s: %s (commit %s, %v)\n", result.Folder, status.LastResult, shortSHA(status.LastCommit), status.Duration.Round(time.Millisecond))
+		if status.LastResult != webhook.ResultSuccess {
+			return fmt.Errorf("deployment of %s failed: %s", result.Folder, status.Error)
+		}
+	}
 	return nil
 }
 
-func runAddFolder(providedPath string) error {
+// reloadConfig loads the configuration again and hands it to the handler,
+// keeping the current configuration if the new one is invalid. Server
+// settings in effect are passed to warn about changes needing a restart.
+// It returns the number of watched folders of the new configuration.
+func reloadConfig(handler *webhook.Handler, server config.ServerConfig) (int, error) {
+	log.Printf("Reloading configuration from %s", config.GetConfigPath())
+
+	cfg, err := config.Load()
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.Printf("Error reloading configuration, keeping the current one: %v", err)
+		return 0, err
+	}
+
+	if cfg.Server.Address() != server.Address() || cfg.Server.TLSCertPath != server.TLSCertPath || cfg.Server.TLSKeyPath != server.TLSKeyPath {
+		log.Printf("Warning: listen address and TLS changes only take effect after a restart")
+	}
+	if cfg.Server.GetMaxConcurrentDeploys() != server.GetMaxConcurrentDeploys() {
+		log.Printf("Warning: max_concurrent_deploys changes only take effect after a restart")
+	}
+
+	handler.Reload(cfg)
+	log.Printf("Configuration reloaded, watching %d folder(s)", len(cfg.Folders))
+	return len(cfg.Folders), nil
+}
+
+func runAddFolder(providedPath string, opts addFolderOptions) error {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		return err
 	}
 
+	// With --path, never read from stdin so scripts can't hang on a prompt
+	interactive := opts.path == ""
+
 	reader := bufio.NewReader(os.Stdin)
 	var repoPath string
 
-	// If path was provided as argument, use it; otherwise prompt
-	if providedPath != "" {
+	// If path was provided as flag or argument, use it; otherwise prompt
+	if opts.path != "" {
+		repoPath = opts.path
+	} else if providedPath != "" {
 		repoPath = providedPath
 	} else {
 		// Default to current directory
@@ -344,6 +784,15 @@ func runAddFolder(providedPath string) error {
 		}
 	}
 
+	// Expand ~ to home directory
+	if strings.HasPrefix(repoPath, "~") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("failed to get home directory: %w", err)
+		}
+		repoPath = filepath.Join(home, repoPath[1:])
+	}
+
 	// Convert to absolute path
 	if !filepath.IsAbs(repoPath) {
 		absPath, err := filepath.Abs(repoPath)
@@ -353,26 +802,62 @@ func runAddFolder(providedPath string) error {
 		repoPath = absPath
 	}
 
-	// Expand ~ to home directory
-	if strings.HasPrefix(repoPath, "~") {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return fmt.Errorf("failed to get home directory: %w", err)
-		}
-		repoPath = filepath.Join(home, repoPath[1:])
+	if cfg.FindFolderByPath(repoPath) != nil {
+		return fmt.Errorf("%s is already watched, use 'deployer edit' to change it", repoPath)
 	}
 
-	// Verify it's a git repository
+	// Verify it's a git repository, offering to clone one if it isn't
 	if !git.IsGitRepository(repoPath) {
-		return fmt.Errorf("not a git repository: %s", repoPath)
+		cloneURL, cloneBranch := opts.cloneURL, opts.branch
+		// A list or glob of branches clones the default branch
+		if config.IsBranchPattern(cloneBranch) {
+			cloneBranch = ""
+		}
+		if cloneURL == "" && interactive {
+			fmt.Printf("%s is not a git repository. Clone a repository into it? (y/n): ", repoPath)
+			response, _ := reader.ReadString('\n')
+			response = strings.TrimSpace(strings.ToLower(response))
+			if response == "y" || response == "yes" {
+				fmt.Print("Repository URL to clone: ")
+				cloneURL, _ = reader.ReadString('\n')
+				cloneURL = strings.TrimSpace(cloneURL)
+
+				if cloneBranch == "" {
+					fmt.Print("Branch (default: repository default branch): ")
+					cloneBranch, _ = reader.ReadString('\n')
+					cloneBranch = strings.TrimSpace(cloneBranch)
+				}
+			}
+		}
+
+		if cloneURL == "" {
+			return fmt.Errorf("not a git repository: %s", repoPath)
+		}
+
+		fmt.Printf("Cloning %s into %s...\n", cloneURL, repoPath)
+		if err := cloneRepository(cfg, cloneURL, cloneBranch, repoPath); err != nil {
+			return err
+		}
 	}
 
 	// Get current branch and remote URL
-	gitMgr := git.NewManager(repoPath)
+	gitMgr := cfg.Git.NewManager(repoPath)
 
-	branch, err := gitMgr.GetCurrentBranch()
-	if err != nil {
-		return fmt.Errorf("failed to get current branch: %w", err)
+	branch := opts.branch
+	if branch == "" {
+		branch, err = gitMgr.GetCurrentBranch()
+		if err != nil {
+			return fmt.Errorf("failed to get current branch: %w", err)
+		}
+		fmt.Printf("Detected branch: %s\n", branch)
+	} else if !config.IsBranchPattern(branch) {
+		branches, err := gitMgr.ListBranches()
+		if err != nil {
+			return err
+		}
+		if !containsString(branches, branch) {
+			return fmt.Errorf("branch %s does not exist in %s", branch, repoPath)
+		}
 	}
 
 	repoURL, err := gitMgr.GetRemoteURL()
@@ -380,34 +865,74 @@ func runAddFolder(providedPath string) error {
 		return fmt.Errorf("failed to get remote URL: %w", err)
 	}
 
-	fmt.Printf("Detected branch: %s\n", branch)
 	fmt.Printf("Detected repository: %s\n", repoURL)
 	fmt.Println()
 
+	// Two folders for the same branch both deploy on every push, which is
+	// rarely intended
+	if existing := cfg.FindFolderByRepo(repoURL, branch); existing != nil {
+		if !interactive {
+			return fmt.Errorf("branch %s of %s is already deployed to %s", branch, repoURL, existing.Path)
+		}
+		fmt.Printf("Branch %s of this repository is already deployed to %s. Add another folder anyway? (y/n): ", branch, existing.Path)
+		response, _ := reader.ReadString('\n')
+		response = strings.TrimSpace(strings.ToLower(response))
+		if response != "y" && response != "yes" {
+			fmt.Println("Cancelled.")
+			return nil
+		}
+	}
+
 	// Suggest default command based on what's in the repository
 	defaultCmd := suggestDefaultCommand(repoPath)
-	if defaultCmd != "" {
-		fmt.Printf("Command to execute after pull (default: %s): ", defaultCmd)
-	} else {
-		fmt.Print("Command to execute after pull (e.g., 'docker compose up -d --pull=auto --build'): ")
-	}
 
-	command, _ := reader.ReadString('\n')
-	command = strings.TrimSpace(command)
+	commands := opts.commands
+	if len(commands) == 0 && interactive {
+		if defaultCmd != "" {
+			fmt.Printf("Command to execute after pull (default: %s): ", defaultCmd)
+		} else {
+			fmt.Print("Command to execute after pull (e.g., 'docker compose up -d --pull=auto --build'): ")
+		}
+
+		command, _ := reader.ReadString('\n')
+		command = strings.TrimSpace(command)
 
-	// Use default if no command provided
-	if command == "" && defaultCmd != "" {
-		command = defaultCmd
-		fmt.Printf("Using default command: %s\n", command)
+		// Use default if no command provided
+		if command == "" && defaultCmd != "" {
+			command = defaultCmd
+			fmt.Printf("Using default command: %s\n", command)
+		}
+
+		if command != "" {
+			commands = append(commands, command)
+			commands = append(commands, readAdditionalCommands(reader)...)
+		}
+	} else if len(commands) == 0 && defaultCmd != "" {
+		commands = []string{defaultCmd}
+		fmt.Printf("Using default command: %s\n", defaultCmd)
+	}
+
+	timeout := config.DefaultTimeout
+	if interactive {
+		fmt.Printf("Command timeout in seconds, 0 for none (default: %d): ", config.DefaultTimeout)
+		timeoutStr, _ := reader.ReadString('\n')
+		timeoutStr = strings.TrimSpace(timeoutStr)
+		if timeoutStr != "" {
+			timeout, err = strconv.Atoi(timeoutStr)
+			if err != nil || timeout < 0 {
+				return fmt.Errorf("invalid timeout: %s", timeoutStr)
+			}
+		}
 	}
 
 	// Add folder to configuration
 	folder := config.WatchedFolder{
 		Path:    repoPath,
-		Command: command,
 		Branch:  branch,
 		RepoURL: repoURL,
+		Timeout: timeout,
 	}
+	folder.SetCommands(commands)
 
 	cfg.Folders = append(cfg.Folders, folder)
 
@@ -419,28 +944,14 @@ func runAddFolder(providedPath string) error {
 	fmt.Println("Folder added successfully!")
 	fmt.Printf("Watching: %s (branch: %s)\n", repoPath, branch)
 
-	// Check if service is running and offer to restart
-	if isServiceRunning() {
-		fmt.Println()
-		fmt.Print("Service is running. Restart to apply changes? (y/n): ")
-		response, _ := reader.ReadString('\n')
-		response = strings.TrimSpace(strings.ToLower(response))
-
-		if response == "y" || response == "yes" {
-			fmt.Println("Restarting service...")
-			if err := systemd.Stop(); err != nil {
-				fmt.Printf("Warning: Failed to stop service: %v\n", err)
-			}
-			if err := systemd.Start(); err != nil {
-				return fmt.Errorf("failed to start service: %w", err)
-			}
-			fmt.Println("Service restarted successfully!")
-		} else {
-			fmt.Println("Remember to restart the service: systemctl --user restart github-deployer")
+	if !interactive {
+		if isServiceRunning() {
+			fmt.Printf("Remember to restart the service: systemctl --user restart %s\n", serviceName)
 		}
+		return nil
 	}
 
-	return nil
+	return offerRestart(reader)
 }
 
 func runListFolders() error {
@@ -461,10 +972,17 @@ func runListFolders() error {
 	fmt.Println()
 
 	for i, folder := range cfg.Folders {
-		fmt.Printf("%d. Path: %s\n", i+1, folder.Path)
+		marker := "[enabled]"
+		if !folder.IsEnabled() {
+			marker = "[disabled]"
+		}
+		fmt.Printf("%d. %s Path: %s\n", i+1, marker, folder.Path)
 		fmt.Printf("   Branch: %s\n", folder.Branch)
 		fmt.Printf("   Repository: %s\n", folder.RepoURL)
-		fmt.Printf("   Command: %s\n", folder.Command)
+		for _, command := range folder.GetCommands() {
+			fmt.Printf("   Command: %s\n", command)
+		}
+		fmt.Printf("   Timeout: %ds\n", folder.Timeout)
 		fmt.Println()
 	}
 
@@ -514,32 +1032,275 @@ func runRemoveFolder() error {
 
 	fmt.Printf("Removed: %s\n", removedFolder.Path)
 
-	// Check if service is running and offer to restart
-	if isServiceRunning() {
-		fmt.Println()
-		fmt.Print("Service is running. Restart to apply changes? (y/n): ")
-		response, _ := reader.ReadString('\n')
-		response = strings.TrimSpace(strings.ToLower(response))
+	return offerRestart(reader)
+}
 
-		if response == "y" || response == "yes" {
-			fmt.Println("Restarting service...")
-			if err := systemd.Stop(); err != nil {
-				fmt.Printf("Warning: Failed to stop service: %v\n", err)
+func runRestoreConfig() error {
+	if err := config.Restore(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Restored configuration from %s\n", config.BackupPath())
+
+	reader := bufio.NewReader(os.Stdin)
+	return offerRestart(reader)
+}
+
+func runEditFolder() error {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.Folders) == 0 {
+		fmt.Println("No folders are being watched.")
+		return nil
+	}
+
+	// List folders
+	fmt.Println("Watched Folders:")
+	for i, folder := range cfg.Folders {
+		fmt.Printf("%d. %s (branch: %s)\n", i+1, folder.Path, folder.Branch)
+	}
+	fmt.Println()
+
+	// Get selection
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Print("Enter number to edit (or 0 to cancel): ")
+	numStr, _ := reader.ReadString('\n')
+	num, err := strconv.Atoi(strings.TrimSpace(numStr))
+	if err != nil || num < 0 || num > len(cfg.Folders) {
+		return fmt.Errorf("invalid selection")
+	}
+
+	if num == 0 {
+		fmt.Println("Cancelled.")
+		return nil
+	}
+
+	folder := &cfg.Folders[num-1]
+	if err := editFolder(reader, cfg, folder); err != nil {
+		return err
+	}
+
+	if err := config.Save(cfg); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	fmt.Println()
+	fmt.Printf("Updated: %s\n", folder.Path)
+
+	return offerRestart(reader)
+}
+
+// editFolder asks for new values of the editable settings of a folder,
+// keeping the current value of every setting left empty
+func editFolder(reader *bufio.Reader, cfg *config.Config, folder *config.WatchedFolder) error {
+	fmt.Println()
+	fmt.Println("Press Enter to keep the current value.")
+	fmt.Println()
+
+	fmt.Printf("Command (current: %s): ", strings.Join(folder.GetCommands(), "; "))
+	command, _ := reader.ReadString('\n')
+	if command = strings.TrimSpace(command); command != "" {
+		commands := append([]string{command}, readAdditionalCommands(reader)...)
+		folder.SetCommands(commands)
+	}
+
+	fmt.Printf("Branch (current: %s): ", folder.Branch)
+	branch, _ := reader.ReadString('\n')
+	if branch = strings.TrimSpace(branch); branch != "" && branch != folder.Branch {
+		if !config.IsBranchPattern(branch) {
+			branches, err := cfg.Git.NewManager(folder.Path).ListBranches()
+			if err != nil {
+				return err
 			}
-			if err := systemd.Start(); err != nil {
-				return fmt.Errorf("failed to start service: %w", err)
+			if !containsString(branches, branch) {
+				return fmt.Errorf("branch %s does not exist in %s", branch, folder.Path)
 			}
-			fmt.Println("Service restarted successfully!")
-		} else {
-			fmt.Println("Remember to restart the service: systemctl --user restart github-deployer")
 		}
+		folder.Branch = branch
+	}
+
+	fmt.Printf("Command timeout in seconds, 0 for none (current: %d): ", folder.Timeout)
+	timeoutStr, _ := reader.ReadString('\n')
+	if timeoutStr = strings.TrimSpace(timeoutStr); timeoutStr != "" {
+		timeout, err := strconv.Atoi(timeoutStr)
+		if err != nil || timeout < 0 {
+			return fmt.Errorf("invalid timeout: %s", timeoutStr)
+		}
+		folder.Timeout = timeout
+	}
+
+	return nil
+}
+
+// selectFolder returns the watched folder at path, or asks the user to pick
+// one if path is empty
+func selectFolder(cfg *config.Config, path, action string) (*config.WatchedFolder, error) {
+	if path != "" {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert to absolute path: %w", err)
+		}
+		folder := cfg.FindFolderByPath(absPath)
+		if folder == nil {
+			return nil, fmt.Errorf("not a watched folder: %s", absPath)
+		}
+		return folder, nil
+	}
+
+	// List folders
+	fmt.Println("Watched Folders:")
+	for i, f := range cfg.Folders {
+		fmt.Printf("%d. %s (branch: %s)\n", i+1, f.Path, f.Branch)
+	}
+	fmt.Println()
+
+	// Get selection
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Printf("Enter number to %s: ", action)
+	numStr, _ := reader.ReadString('\n')
+	num, err := strconv.Atoi(strings.TrimSpace(numStr))
+	if err != nil || num < 1 || num > len(cfg.Folders) {
+		return nil, fmt.Errorf("invalid selection")
+	}
+	return &cfg.Folders[num-1], nil
+}
+
+func runSetFolderEnabled(path string, enabled bool) error {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.Folders) == 0 {
+		return fmt.Errorf("no folders are being watched. Add a folder using 'deployer add'")
+	}
+
+	action := "disable"
+	if enabled {
+		action = "enable"
+	}
+
+	folder, err := selectFolder(cfg, path, action)
+	if err != nil {
+		return err
+	}
+
+	if folder.IsEnabled() == enabled {
+		fmt.Printf("%s is already %sd.\n", folder.Path, action)
+		return nil
+	}
+
+	folder.Enabled = &enabled
+	if err := config.Save(cfg); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+
+	if enabled {
+		fmt.Printf("Enabled: %s\n", folder.Path)
+	} else {
+		fmt.Printf("Disabled: %s\n", folder.Path)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	return offerRestart(reader)
+}
+
+func runDeploy(path, tag string, dryRun bool) error {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.Folders) == 0 {
+		return fmt.Errorf("no folders are being watched. Add a folder using 'deployer add'")
+	}
+
+	folder, err := selectFolder(cfg, path, "deploy")
+	if err != nil {
+		return err
+	}
+
+	handler := webhook.NewHandler(cfg)
+
+	if dryRun {
+		output, err := handler.Deploy(folder, tag, true)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Deploying %s (branch: %s) would:\n", folder.Path, folder.Branch)
+		fmt.Print(output)
+		return nil
 	}
 
+	fmt.Printf("Deploying %s (branch: %s)...\n", folder.Path, folder.Branch)
+
+	output, err := handler.Deploy(folder, tag, false)
+	if output != "" {
+		fmt.Println()
+		fmt.Println("Output:")
+		fmt.Println(strings.TrimRight(output, "\n"))
+		fmt.Println()
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Deployment completed successfully!")
 	return nil
 }
 
+func runHistory(path string, limit int) error {
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	entries, err := cfg.Server.NewHistoryStore().List(path, limit)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("No deployments recorded yet.")
+		return nil
+	}
+
+	for _, entry := range entries {
+		duration := time.Duration(entry.DurationMS) * time.Millisecond
+		fmt.Printf("%s  %-8s  %s (%s) %s  %s\n", entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Result, entry.Folder, entry.Branch, shortSHA(entry.SHA), duration)
+		if entry.Error != "" {
+			fmt.Printf("    Error: %s\n", firstLine(entry.Error))
+		}
+		if entry.LogPath != "" {
+			fmt.Printf("    Log: %s\n", entry.LogPath)
+		}
+	}
+
+	return nil
+}
+
+// shortSHA abbreviates a commit SHA to 7 characters
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// firstLine returns the first line of s
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
 func runStatus() error {
-	status, err := systemd.Status()
+	status, err := systemd.Status(serviceName)
 	if err != nil {
 		// Service might not be installed or not running
 		fmt.Println("Service status: Not running or not installed")
@@ -550,9 +1311,34 @@ func runStatus() error {
 	return nil
 }
 
+// offerRestart asks the user to restart the service if it is running, so
+// configuration changes take effect
+func offerRestart(reader *bufio.Reader) error {
+	if !isServiceRunning() {
+		return nil
+	}
+
+	fmt.Println()
+	fmt.Print("Service is running. Restart to apply changes? (y/n): ")
+	response, _ := reader.ReadString('\n')
+	response = strings.TrimSpace(strings.ToLower(response))
+
+	if response == "y" || response == "yes" {
+		fmt.Println("Restarting service...")
+		if err := systemd.Restart(serviceName); err != nil {
+			return fmt.Errorf("failed to restart service: %w", err)
+		}
+		fmt.Println("Service restarted successfully!")
+	} else {
+		fmt.Printf("Remember to restart the service: systemctl --user restart %s\n", serviceName)
+	}
+
+	return nil
+}
+
 // isServiceRunning checks if the systemd service is currently running
 func isServiceRunning() bool {
-	status, err := systemd.Status()
+	status, err := systemd.Status(serviceName)
 	if err != nil {
 		return false
 	}
@@ -594,6 +1380,51 @@ func suggestDefaultCommand(repoPath string) string {
 	return ""
 }
 
+// cloneRepository clones a repository, authenticating HTTPS clones from
+// github.com with the GitHub App installation token when an installation is
+// configured
+func cloneRepository(cfg *config.Config, repoURL, branch, dest string) error {
+	if cfg.GitHub.InstallationID == 0 || !strings.HasPrefix(repoURL, "https://") || !git.IsGitHubURL(repoURL) {
+		return cfg.Git.NewManager(dest).Clone(repoURL, branch)
+	}
+
+	appClient, err := github.NewAppClient(cfg.GitHub.AppID, cfg.GitHub.PrivateKeyPath, cfg.GitHub.InstallationID)
+	if err != nil {
+		return fmt.Errorf("failed to create GitHub App client: %w", err)
+	}
+
+	token, err := appClient.GetInstallationToken()
+	if err != nil {
+		return err
+	}
+
+	return cfg.Git.NewManager(dest).CloneWithToken(repoURL, branch, token)
+}
+
+// readAdditionalCommands prompts for further commands until a blank line
+func readAdditionalCommands(reader *bufio.Reader) []string {
+	var commands []string
+	for {
+		fmt.Print("Additional command (blank line to finish): ")
+		command, _ := reader.ReadString('\n')
+		command = strings.TrimSpace(command)
+		if command == "" {
+			return commands
+		}
+		commands = append(commands, command)
+	}
+}
+
+// containsString checks if a slice contains a string
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 // fileExists checks if a file exists
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
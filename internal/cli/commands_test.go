@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+func TestEditFolder(t *testing.T) {
+	original := config.WatchedFolder{Path: "/srv/app", Branch: "main", Command: "make deploy", Timeout: 60}
+
+	tests := []struct {
+		name    string
+		input   string
+		want    config.WatchedFolder
+		wantErr bool
+	}{
+		{"nothing changed", "\n\n\n", original, false},
+		{"end of input", "", original, false},
+		{"command only", "npm run build\n\n\n", config.WatchedFolder{Path: "/srv/app", Branch: "main", Command: "npm run build", Timeout: 60}, false},
+		{"timeout only", "\n\n0\n", config.WatchedFolder{Path: "/srv/app", Branch: "main", Command: "make deploy", Timeout: 0}, false},
+		{"unchanged branch", "\nmain\n\n", original, false},
+		{"invalid timeout", "\n\n-5\n", original, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := original
+			err := editFolder(bufio.NewReader(strings.NewReader(tt.input)), &config.Config{}, &folder)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("editFolder error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(folder, tt.want) {
+				t.Errorf("folder = %+v, want %+v", folder, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/git"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/github"
+	"github.com/eliasfloreteng/github-auto-deployer/pkg/systemd"
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Diagnose the environment",
+	Long: `Check that git is installed, the configuration and private key are
+valid, the server port is free, systemd is available and every watched
+folder is a clean git repository on its branch.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if failed := runDoctor(); failed > 0 {
+			log.Fatalf("%d check(s) failed", failed)
+		}
+	},
+}
+
+// doctorCheck is a single item of the doctor's checklist
+type doctorCheck struct {
+	name string
+	err  error  // nil if the check passed
+	info string // Shown after a passing check, e.g. a version
+}
+
+// runDoctor prints the checklist and returns the number of failed checks
+func runDoctor() int {
+	checks := diagnose()
+
+	failed := 0
+	for _, check := range checks {
+		switch {
+		case check.err != nil:
+			failed++
+			fmt.Printf("[FAIL] %s: %v\n", check.name, check.err)
+		case check.info != "":
+			fmt.Printf("[ OK ] %s: %s\n", check.name, check.info)
+		default:
+			fmt.Printf("[ OK ] %s\n", check.name)
+		}
+	}
+
+	fmt.Println()
+	if failed == 0 {
+		fmt.Println("Everything looks good.")
+	}
+	return failed
+}
+
+// diagnose runs every check. Checks needing the configuration are skipped
+// if it cannot be loaded.
+func diagnose() []doctorCheck {
+	var checks []doctorCheck
+
+	cfg, err := config.Load()
+	if err != nil {
+		// Still report whether git is usable
+		version, gitErr := git.NewManager("").Version()
+		return append(checks,
+			doctorCheck{name: "git installed", err: gitErr, info: version},
+			doctorCheck{name: "configuration loads", err: err},
+		)
+	}
+
+	version, err := cfg.Git.NewManager("").Version()
+	checks = append(checks, doctorCheck{name: "git installed", err: err, info: version})
+	checks = append(checks, doctorCheck{name: "configuration loads", info: config.GetConfigPath()})
+	checks = append(checks, doctorCheck{name: "configuration valid", err: cfg.Validate()})
+	if cfg.UsesGitHubApp() {
+		checks = append(checks, doctorCheck{name: "private key readable", err: github.CheckPrivateKey(cfg.GitHub.PrivateKeyPath), info: cfg.GitHub.PrivateKeyPath})
+	}
+	checks = append(checks, doctorCheck{name: "port " + cfg.Server.Address() + " free", err: cfg.Server.CheckPortAvailable()})
+
+	var systemdErr error
+	if !systemd.Available() {
+		systemdErr = fmt.Errorf("systemctl --user is not usable, run the server with 'deployer start' instead")
+	}
+	checks = append(checks, doctorCheck{name: "systemd available", err: systemdErr})
+
+	for i := range cfg.Folders {
+		checks = append(checks, doctorCheck{name: "folder " + cfg.Folders[i].Path, err: checkFolder(cfg, &cfg.Folders[i])})
+	}
+
+	return checks
+}
+
+// checkFolder verifies that a watched folder is a git repository without
+// local changes, on the branch it deploys
+func checkFolder(cfg *config.Config, folder *config.WatchedFolder) error {
+	if !git.IsGitRepository(folder.Path) {
+		return fmt.Errorf("not a git repository")
+	}
+
+	gitMgr := cfg.Git.NewManager(folder.Path)
+	dirty, err := gitMgr.IsDirty()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("has local changes (dirty_strategy: %s)", folder.GetDirtyStrategy())
+	}
+
+	if folder.GetTrigger() == config.TriggerBranch {
+		branch, err := gitMgr.GetCurrentBranch()
+		if err != nil {
+			return err
+		}
+		if !folder.MatchesBranch(branch) {
+			return fmt.Errorf("on branch %s, expected %s", branch, folder.Branch)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// Build metadata, set at build time with
+// -ldflags "-X github.com/eliasfloreteng/github-auto-deployer/internal/cli.Version=..."
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print version information",
+	Long:  `Print the version, git commit and build date of the deployer.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println(versionString())
+	},
+}
+
+// versionString describes the running build
+func versionString() string {
+	return fmt.Sprintf("deployer %s (commit %s, built %s)", Version, Commit, BuildDate)
+}
@@ -2,49 +2,626 @@ package config
 
 import (
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
 	"os"
+	"os/exec"
+	"os/user"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/git"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/history"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/logging"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/notifier"
+	"gopkg.in/yaml.v3"
 )
 
 // Config represents the application configuration
 type Config struct {
-	GitHub  GitHubConfig    `json:"github"`
-	SMTP    SMTPConfig      `json:"smtp"`
-	Server  ServerConfig    `json:"server"`
-	Folders []WatchedFolder `json:"folders"`
+	Version       int                 `json:"version" yaml:"version"` // Schema version, see Migrate
+	GitHub        GitHubConfig        `json:"github" yaml:"github"`
+	SMTP          SMTPConfig          `json:"smtp" yaml:"smtp"`
+	Slack         SlackConfig         `json:"slack" yaml:"slack"`
+	WebhookNotify WebhookNotifyConfig `json:"webhook_notify" yaml:"webhook_notify"`
+	Telegram      TelegramConfig      `json:"telegram" yaml:"telegram"`
+	Server        ServerConfig        `json:"server" yaml:"server"`
+	Git           GitConfig           `json:"git" yaml:"git"`
+	Folders       []WatchedFolder     `json:"folders" yaml:"folders"`
+
+	// fileValues holds the values from the file for fields overridden by
+	// environment variables, keyed by variable name, so Save never writes
+	// the overrides to disk
+	fileValues map[string]string
 }
 
-// GitHubConfig holds GitHub App credentials
+// GitHubConfig holds GitHub App credentials. The private key path and
+// webhook secret can be overridden with the DEPLOYER_GITHUB_PRIVATE_KEY_PATH
+// and DEPLOYER_WEBHOOK_SECRET environment variables, which take precedence
+// over the file.
 type GitHubConfig struct {
-	AppID          int64  `json:"app_id"`
-	PrivateKeyPath string `json:"private_key_path"`
-	WebhookSecret  string `json:"webhook_secret"`
+	AppID          int64  `json:"app_id" yaml:"app_id"`
+	PrivateKeyPath string `json:"private_key_path" yaml:"private_key_path"`
+	WebhookSecret  string `json:"webhook_secret" yaml:"webhook_secret"`
+	InstallationID int64  `json:"installation_id" yaml:"installation_id"` // Default installation for folders without their own
+	ReportStatus   bool   `json:"report_status" yaml:"report_status"`     // Set commit statuses on GitHub while deploying
 }
 
-// SMTPConfig holds email notification settings
+// SMTPConfig holds email notification settings. The username and password
+// can be overridden with the DEPLOYER_SMTP_USERNAME and
+// DEPLOYER_SMTP_PASSWORD environment variables, which take precedence over
+// the file.
 type SMTPConfig struct {
-	Host     string `json:"host"`
-	Port     int    `json:"port"`
-	Username string `json:"username"`
-	Password string `json:"password"`
-	From     string `json:"from"`
-	To       string `json:"to"`
+	Host     string `json:"host" yaml:"host"`
+	Port     int    `json:"port" yaml:"port"`
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+	From     string `json:"from" yaml:"from"`
+	To       string `json:"to" yaml:"to"` // Comma-separated recipients
+
+	TLSMode            string `json:"tls_mode,omitempty" yaml:"tls_mode,omitempty"`                         // none, starttls or ssl (empty = ssl on port 465, starttls otherwise)
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty"` // Accept any server certificate, e.g. self-signed relays
+	TemplatePath       string `json:"template_path,omitempty" yaml:"template_path,omitempty"`               // HTML email template (empty = built-in)
+
+	NotifyOnSuccess       bool `json:"notify_on_success" yaml:"notify_on_success"`                                 // Also email when a deployment succeeds
+	NotifyCooldownMinutes int  `json:"notify_cooldown_minutes,omitempty" yaml:"notify_cooldown_minutes,omitempty"` // Don't repeat a folder's failure email within this time (0 = always email)
+}
+
+// Enabled reports whether email notifications are configured
+func (s SMTPConfig) Enabled() bool {
+	return s.Host != ""
+}
+
+// GetNotifyCooldown returns how long an identical failure email of a
+// folder is suppressed
+func (s SMTPConfig) GetNotifyCooldown() time.Duration {
+	return time.Duration(s.NotifyCooldownMinutes) * time.Minute
+}
+
+// SlackConfig holds Slack notification settings
+type SlackConfig struct {
+	WebhookURL string `json:"webhook_url" yaml:"webhook_url"` // Incoming webhook URL (empty = disabled)
+}
+
+// WebhookNotifyConfig holds settings for posting notifications to a generic HTTP endpoint
+type WebhookNotifyConfig struct {
+	URL     string            `json:"url" yaml:"url"`         // Endpoint receiving JSON events (empty = disabled)
+	Headers map[string]string `json:"headers" yaml:"headers"` // Extra request headers, e.g. Authorization
+}
+
+// TelegramConfig holds settings for notifications sent by a Telegram bot
+type TelegramConfig struct {
+	BotToken string `json:"bot_token,omitempty" yaml:"bot_token,omitempty"` // Token from @BotFather (empty = disabled)
+	ChatID   string `json:"chat_id,omitempty" yaml:"chat_id,omitempty"`     // Chat, group or channel receiving the messages
 }
 
 // ServerConfig holds webhook server settings
 type ServerConfig struct {
-	Port int `json:"port"`
+	Host                 string `json:"host" yaml:"host"` // Interface to bind to (empty = all interfaces)
+	Port                 int    `json:"port" yaml:"port"`
+	ShutdownGracePeriod  int    `json:"shutdown_grace_period" yaml:"shutdown_grace_period"`                       // Seconds to wait for running deployments on shutdown (0 = default)
+	DebounceSeconds      int    `json:"debounce_seconds" yaml:"debounce_seconds"`                                 // Seconds to wait for further pushes before deploying
+	MaxBodyBytes         int64  `json:"max_body_bytes,omitempty" yaml:"max_body_bytes,omitempty"`                 // Largest accepted webhook body (0 = default)
+	MaxConcurrentDeploys int    `json:"max_concurrent_deploys,omitempty" yaml:"max_concurrent_deploys,omitempty"` // Deployments running at once, others wait (0 = default)
+	MaxOutputBytes       int    `json:"max_output_bytes,omitempty" yaml:"max_output_bytes,omitempty"`             // Command output kept for notifications, the tail is kept (0 = default)
+	DeployTimeout        int    `json:"deploy_timeout,omitempty" yaml:"deploy_timeout,omitempty"`                 // Seconds a whole deployment may take, git included (0 = default, -1 = no limit)
+
+	DeliveryTTL       int `json:"delivery_ttl,omitempty" yaml:"delivery_ttl,omitempty"`               // Seconds a delivery ID is remembered to ignore redeliveries (0 = default, -1 = never ignore)
+	DeliveryCacheSize int `json:"delivery_cache_size,omitempty" yaml:"delivery_cache_size,omitempty"` // Delivery IDs remembered at most (0 = default)
+
+	StatusToken string `json:"status_token" yaml:"status_token"` // Bearer token required by the /status endpoint (empty = open)
+
+	RestrictToGitHubIPs bool `json:"restrict_to_github_ips" yaml:"restrict_to_github_ips"` // Only accept webhooks from GitHub's hook IP ranges
+	TrustProxy          bool `json:"trust_proxy" yaml:"trust_proxy"`                       // Use X-Forwarded-For from a reverse proxy as the client address
+
+	TLSCertPath string `json:"tls_cert_path" yaml:"tls_cert_path"` // Serve HTTPS when both the certificate
+	TLSKeyPath  string `json:"tls_key_path" yaml:"tls_key_path"`   // and key paths are set
+
+	DryRun bool `json:"dry_run,omitempty" yaml:"dry_run,omitempty"` // Only log what deployments would do, without running git or commands
+
+	LogFile   string `json:"log_file,omitempty" yaml:"log_file,omitempty"`     // Also write logs to this file, rotated at 10 MB (empty = stderr only)
+	LogFormat string `json:"log_format,omitempty" yaml:"log_format,omitempty"` // text (default) or json
+
+	LogDir       string `json:"log_dir,omitempty" yaml:"log_dir,omitempty"`             // Write the output of every deployment to a file in this directory (empty = disabled)
+	LogRetention int    `json:"log_retention,omitempty" yaml:"log_retention,omitempty"` // Deployment logs kept per folder (0 = default)
+
+	HistoryFile      string `json:"history_file,omitempty" yaml:"history_file,omitempty"`           // Deployment history file (default: history.jsonl next to the configuration)
+	HistoryRetention int    `json:"history_retention,omitempty" yaml:"history_retention,omitempty"` // Deployments kept in the history (0 = default)
+}
+
+// GitConfig holds settings for running git
+type GitConfig struct {
+	BinaryPath string   `json:"binary_path,omitempty" yaml:"binary_path,omitempty"` // git executable (default: git from the PATH)
+	FetchArgs  []string `json:"fetch_args,omitempty" yaml:"fetch_args,omitempty"`   // Extra arguments for every fetch, e.g. --depth=1
+
+	MaxRetries   int `json:"max_retries,omitempty" yaml:"max_retries,omitempty"`     // Retries of a pull failing on network errors (0 = none)
+	RetryBackoff int `json:"retry_backoff,omitempty" yaml:"retry_backoff,omitempty"` // Seconds before the first retry, doubled after each one (0 = default)
+}
+
+// DefaultMaxRetries is the number of git retries suggested for new
+// configurations
+const DefaultMaxRetries = 2
+
+// DefaultRetryBackoff is used when no retry backoff is configured
+const DefaultRetryBackoff = 2 * time.Second
+
+// GetRetryBackoff returns how long to wait before the first git retry
+func (g GitConfig) GetRetryBackoff() time.Duration {
+	if g.RetryBackoff <= 0 {
+		return DefaultRetryBackoff
+	}
+	return time.Duration(g.RetryBackoff) * time.Second
+}
+
+// NewManager creates a git manager for a repository using these settings
+func (g GitConfig) NewManager(repoPath string) *git.Manager {
+	gitMgr := git.NewManager(repoPath)
+	gitMgr.SetBinary(g.BinaryPath)
+	gitMgr.SetFetchArgs(g.FetchArgs)
+	gitMgr.SetRetry(g.MaxRetries, g.GetRetryBackoff())
+	return gitMgr
+}
+
+// DefaultShutdownGracePeriod is used when no grace period is configured
+const DefaultShutdownGracePeriod = 60 * time.Second
+
+// GetShutdownGracePeriod returns how long to wait for running deployments
+// when the server shuts down
+func (s ServerConfig) GetShutdownGracePeriod() time.Duration {
+	if s.ShutdownGracePeriod <= 0 {
+		return DefaultShutdownGracePeriod
+	}
+	return time.Duration(s.ShutdownGracePeriod) * time.Second
 }
 
 // WatchedFolder represents a folder being monitored
 type WatchedFolder struct {
-	Path    string `json:"path"`
-	Command string `json:"command"`
-	Branch  string `json:"branch"`   // Current branch (detected automatically)
-	RepoURL string `json:"repo_url"` // Repository URL for matching webhooks
+	Path       string   `json:"path" yaml:"path"`
+	PreCommand string   `json:"pre_command,omitempty" yaml:"pre_command,omitempty"` // Runs before pulling; a failure aborts the deploy
+	Command    string   `json:"command" yaml:"command"`
+	Commands   []string `json:"commands,omitempty" yaml:"commands,omitempty"` // Run in order after Command, stopping at the first failure
+
+	// Runs when a post-update command fails; the commit checked out before
+	// the update is available as $DEPLOY_PREVIOUS_SHA
+	RollbackCommand string `json:"rollback_command,omitempty" yaml:"rollback_command,omitempty"`
+
+	Branch  string `json:"branch" yaml:"branch"`     // Current branch (detected automatically)
+	RepoURL string `json:"repo_url" yaml:"repo_url"` // Repository URL for matching webhooks
+	Timeout int    `json:"timeout" yaml:"timeout"`   // Command timeout in seconds (0 = no timeout)
+
+	// Seconds a timed out command may take to exit after SIGTERM before it
+	// is killed (0 = default, -1 = kill right away)
+	KillGracePeriod int `json:"kill_grace_period,omitempty" yaml:"kill_grace_period,omitempty"`
+
+	InstallationID int64 `json:"installation_id,omitempty" yaml:"installation_id,omitempty"` // GitHub App installation used to pull private repos
+
+	Trigger string `json:"trigger,omitempty" yaml:"trigger,omitempty"` // What deploys the folder: branch (default), tag or release
+
+	DirtyStrategy string `json:"dirty_strategy,omitempty" yaml:"dirty_strategy,omitempty"` // Local changes before pulling: fail (default), stash or reset
+	PullStrategy  string `json:"pull_strategy,omitempty" yaml:"pull_strategy,omitempty"`   // merge, rebase or ff-only (default: git's configuration)
+
+	UpdateSubmodules bool `json:"update_submodules,omitempty" yaml:"update_submodules,omitempty"` // Run git submodule update --init --recursive after updating
+
+	// Check out the exact pushed commit (detached) instead of pulling the
+	// branch, which may have moved on since the push
+	PinToPushedSHA bool `json:"pin_to_pushed_sha,omitempty" yaml:"pin_to_pushed_sha,omitempty"`
+
+	RequireSignedCommits bool `json:"require_signed_commits,omitempty" yaml:"require_signed_commits,omitempty"` // Refuse to deploy commits git verify-commit rejects
+
+	// User names (GitHub logins etc.) whose pushes deploy the folder; pushes
+	// by anyone else are skipped (empty = everyone)
+	AllowedPushers        []string `json:"allowed_pushers,omitempty" yaml:"allowed_pushers,omitempty"`
+	NotifyRejectedPushers bool     `json:"notify_rejected_pushers,omitempty" yaml:"notify_rejected_pushers,omitempty"` // Send a failure notification for skipped pushes
+
+	Enabled *bool `json:"enabled,omitempty" yaml:"enabled,omitempty"` // Pushes are ignored when false (default: true)
+
+	Env       map[string]string `json:"env,omitempty" yaml:"env,omitempty"`                 // Extra environment variables for the folder's commands
+	RunAsUser string            `json:"run_as_user,omitempty" yaml:"run_as_user,omitempty"` // Run the commands as this user (requires running as root)
+	Shell     string            `json:"shell,omitempty" yaml:"shell,omitempty"`             // Shell running the commands with -c, e.g. bash (default: sh)
+	WorkDir   string            `json:"work_dir,omitempty" yaml:"work_dir,omitempty"`       // Directory the commands run in, relative to path (default: path itself)
+
+	NotifyTo string   `json:"notify_to,omitempty" yaml:"notify_to,omitempty"` // Comma-separated email recipients replacing smtp.to
+	Notify   []string `json:"notify,omitempty" yaml:"notify,omitempty"`       // Channels notified: email, slack, webhook, telegram (default: all configured)
+
+	// Glob patterns (path.Match syntax, plus "dir/**" for everything below
+	// dir); if set, pushes that change no matching file are skipped
+	PathFilters []string `json:"path_filters,omitempty" yaml:"path_filters,omitempty"`
+}
+
+// Notification channels a folder can select
+const (
+	NotifyEmail    = "email"
+	NotifySlack    = "slack"
+	NotifyWebhook  = "webhook"
+	NotifyTelegram = "telegram"
+)
+
+// Notifies reports whether the folder's notifications go to a channel
+func (f WatchedFolder) Notifies(channel string) bool {
+	if len(f.Notify) == 0 {
+		return true
+	}
+	for _, c := range f.Notify {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvList returns the folder's environment variables as sorted KEY=value
+// pairs
+func (f WatchedFolder) EnvList() []string {
+	env := make([]string, 0, len(f.Env))
+	for key, value := range f.Env {
+		env = append(env, key+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// IsEnabled reports whether pushes deploy the folder
+func (f WatchedFolder) IsEnabled() bool {
+	return f.Enabled == nil || *f.Enabled
+}
+
+// HasNotifications reports whether at least one notification channel is
+// configured
+func (c *Config) HasNotifications() bool {
+	return c.SMTP.Enabled() || c.Slack.WebhookURL != "" || c.WebhookNotify.URL != "" || c.Telegram.BotToken != ""
+}
+
+// FindFolderByPath returns the watched folder with the given path, or nil
+func (c *Config) FindFolderByPath(folderPath string) *WatchedFolder {
+	folderPath = filepath.Clean(folderPath)
+	for i := range c.Folders {
+		if filepath.Clean(c.Folders[i].Path) == folderPath {
+			return &c.Folders[i]
+		}
+	}
+	return nil
+}
+
+// GetWatchersByRepo returns every watched folder of a repository, given by
+// its owner/name full name and clone URL (see WatchedFolder.MatchesRepo),
+// e.g. folders deploying different branches of the same repository.
+// Callers pick the folders matching the pushed branch. It returns an error
+// if no folder matches.
+func (c *Config) GetWatchersByRepo(fullName, cloneURL string) ([]*WatchedFolder, error) {
+	var folders []*WatchedFolder
+	for i := range c.Folders {
+		if c.Folders[i].MatchesRepo(fullName, cloneURL) {
+			folders = append(folders, &c.Folders[i])
+		}
+	}
+
+	if len(folders) == 0 {
+		return nil, fmt.Errorf("no watched folder for repository %s", fullName)
+	}
+
+	return folders, nil
+}
+
+// BranchPatterns returns the branches the folder deploys: the
+// comma-separated entries of Branch, each a branch name or a path.Match
+// glob such as release/*
+func (f WatchedFolder) BranchPatterns() []string {
+	var patterns []string
+	for _, pattern := range strings.Split(f.Branch, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// HasBranchPattern reports whether the folder deploys more than one branch,
+// i.e. Branch is a list or contains a glob
+func (f WatchedFolder) HasBranchPattern() bool {
+	return IsBranchPattern(f.Branch)
+}
+
+// IsBranchPattern reports whether a branch setting is a list or a glob
+// rather than a single branch name
+func IsBranchPattern(branch string) bool {
+	return strings.ContainsAny(branch, ",*?[")
+}
+
+// MatchesBranch reports whether a pushed branch is deployed by the folder
+func (f WatchedFolder) MatchesBranch(branch string) bool {
+	if branch == "" {
+		return false
+	}
+	for _, pattern := range f.BranchPatterns() {
+		if ok, _ := path.Match(pattern, branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RepoSlug returns the lowercase owner/name of the folder's repository, or
+// "" if it cannot be derived from the repository URL
+func (f WatchedFolder) RepoSlug() string {
+	owner, name, err := git.ParseRepoURL(f.RepoURL)
+	if err != nil {
+		return ""
+	}
+	return owner + "/" + name
+}
+
+// MatchesRepo reports whether the folder deploys the repository with the
+// given owner/name full name or clone URL. Either may be empty. Matching
+// on both keeps folders cloned over SSH working when only one of them
+// normalizes to the folder's URL. Given a clone URL, the full name only
+// matches folders on the same host, so that owner/repo on another host is
+// never deployed.
+func (f WatchedFolder) MatchesRepo(fullName, cloneURL string) bool {
+	if cloneURL != "" {
+		if git.CompareURLs(f.RepoURL, cloneURL) {
+			return true
+		}
+		if git.RepoHost(f.RepoURL) != git.RepoHost(cloneURL) {
+			return false
+		}
+	}
+	slug := f.RepoSlug()
+	return slug != "" && strings.EqualFold(slug, fullName)
+}
+
+// FindFolderByRepo returns the first watched folder deploying the given
+// branch of a repository, or nil
+func (c *Config) FindFolderByRepo(repoURL, branch string) *WatchedFolder {
+	for i := range c.Folders {
+		if (c.Folders[i].Branch == branch || c.Folders[i].MatchesBranch(branch)) && git.CompareURLs(c.Folders[i].RepoURL, repoURL) {
+			return &c.Folders[i]
+		}
+	}
+	return nil
+}
+
+// MatchesPaths reports whether any of the changed files matches the
+// folder's path filters. Folders without filters match everything.
+func (f WatchedFolder) MatchesPaths(files []string) bool {
+	if len(f.PathFilters) == 0 {
+		return true
+	}
+
+	for _, file := range files {
+		for _, pattern := range f.PathFilters {
+			if matchPathFilter(pattern, file) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchPathFilter matches a file against a single path filter
+func matchPathFilter(pattern, file string) bool {
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return strings.HasPrefix(file, dir+"/")
+	}
+
+	matched, err := path.Match(pattern, file)
+	return err == nil && matched
+}
+
+// Deployment triggers for watched folders
+const (
+	TriggerBranch  = "branch"  // Pushes to the watched branch
+	TriggerTag     = "tag"     // Pushed tags
+	TriggerRelease = "release" // Published GitHub releases
+)
+
+// GetCommands returns the post-update commands in the order they run
+func (f WatchedFolder) GetCommands() []string {
+	var commands []string
+	if f.Command != "" {
+		commands = append(commands, f.Command)
+	}
+	for _, command := range f.Commands {
+		if command != "" {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// SetCommands stores the post-update commands, using Command for a single
+// command so simple configs keep their original shape
+func (f *WatchedFolder) SetCommands(commands []string) {
+	f.Command = ""
+	f.Commands = nil
+	if len(commands) == 1 {
+		f.Command = commands[0]
+	} else if len(commands) > 1 {
+		f.Commands = commands
+	}
+}
+
+// Strategies for handling local changes before updating a folder
+const (
+	DirtyFail  = "fail"  // Leave the changes; the pull fails if they conflict
+	DirtyStash = "stash" // Stash the changes
+	DirtyReset = "reset" // Discard changes to tracked files
+)
+
+// GetDirtyStrategy returns the folder's dirty strategy, defaulting to fail
+func (f WatchedFolder) GetDirtyStrategy() string {
+	if f.DirtyStrategy == "" {
+		return DirtyFail
+	}
+	return f.DirtyStrategy
+}
+
+// AllowsPusher reports whether a push by a user known under any of names
+// may deploy the folder. User names are compared case-insensitively, and
+// pushes by unknown users are only allowed without allowed_pushers.
+func (f WatchedFolder) AllowsPusher(names []string) bool {
+	if len(f.AllowedPushers) == 0 {
+		return true
+	}
+	for _, allowed := range f.AllowedPushers {
+		for _, name := range names {
+			if strings.EqualFold(allowed, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DefaultShell runs the commands of folders without a shell setting
+const DefaultShell = "sh"
+
+// GetShell returns the shell running the folder's commands, defaulting to sh
+func (f WatchedFolder) GetShell() string {
+	if f.Shell == "" {
+		return DefaultShell
+	}
+	return f.Shell
+}
+
+// DefaultKillGracePeriod is how long a timed out command may take to exit
+// after SIGTERM unless configured otherwise
+const DefaultKillGracePeriod = 10 * time.Second
+
+// GetKillGracePeriod returns how long a timed out command may take to exit
+// after SIGTERM, 0 if it is killed right away
+func (f WatchedFolder) GetKillGracePeriod() time.Duration {
+	switch {
+	case f.KillGracePeriod < 0:
+		return 0
+	case f.KillGracePeriod == 0:
+		return DefaultKillGracePeriod
+	}
+	return time.Duration(f.KillGracePeriod) * time.Second
+}
+
+// GetWorkDir returns the directory the folder's commands run in
+func (f WatchedFolder) GetWorkDir() string {
+	return filepath.Join(f.Path, f.WorkDir)
+}
+
+// GetTrigger returns the folder's trigger, defaulting to branch pushes
+func (f WatchedFolder) GetTrigger() string {
+	if f.Trigger == "" {
+		return TriggerBranch
+	}
+	return f.Trigger
+}
+
+// DefaultMaxBodyBytes is used when no webhook body limit is configured.
+// GitHub payloads are well under this.
+const DefaultMaxBodyBytes = 5 << 20
+
+// GetMaxBodyBytes returns the largest webhook body the server accepts
+func (s ServerConfig) GetMaxBodyBytes() int64 {
+	if s.MaxBodyBytes <= 0 {
+		return DefaultMaxBodyBytes
+	}
+	return s.MaxBodyBytes
+}
+
+// DefaultMaxConcurrentDeploys is used when no deployment concurrency is
+// configured
+const DefaultMaxConcurrentDeploys = 4
+
+// GetMaxConcurrentDeploys returns how many deployments may run at once
+func (s ServerConfig) GetMaxConcurrentDeploys() int {
+	if s.MaxConcurrentDeploys <= 0 {
+		return DefaultMaxConcurrentDeploys
+	}
+	return s.MaxConcurrentDeploys
+}
+
+// DefaultMaxOutputBytes is used when no command output limit is configured
+const DefaultMaxOutputBytes = 1 << 20
+
+// GetMaxOutputBytes returns how many bytes of each command's output are kept
+func (s ServerConfig) GetMaxOutputBytes() int {
+	if s.MaxOutputBytes <= 0 {
+		return DefaultMaxOutputBytes
+	}
+	return s.MaxOutputBytes
+}
+
+// DefaultDeployTimeout is how long a whole deployment may take unless
+// configured otherwise
+const DefaultDeployTimeout = time.Hour
+
+// GetDeployTimeout returns how long a whole deployment may take, 0 if
+// deployments are not limited
+func (s ServerConfig) GetDeployTimeout() time.Duration {
+	switch {
+	case s.DeployTimeout < 0:
+		return 0
+	case s.DeployTimeout == 0:
+		return DefaultDeployTimeout
+	}
+	return time.Duration(s.DeployTimeout) * time.Second
+}
+
+// Defaults for ignoring redelivered webhooks
+const (
+	DefaultDeliveryTTL       = 10 * time.Minute
+	DefaultDeliveryCacheSize = 1000
+)
+
+// GetDeliveryTTL returns how long a delivery ID is remembered, 0 if
+// redeliveries are never ignored
+func (s ServerConfig) GetDeliveryTTL() time.Duration {
+	switch {
+	case s.DeliveryTTL < 0:
+		return 0
+	case s.DeliveryTTL == 0:
+		return DefaultDeliveryTTL
+	}
+	return time.Duration(s.DeliveryTTL) * time.Second
 }
 
+// GetDeliveryCacheSize returns how many delivery IDs are remembered at most
+func (s ServerConfig) GetDeliveryCacheSize() int {
+	if s.DeliveryCacheSize <= 0 {
+		return DefaultDeliveryCacheSize
+	}
+	return s.DeliveryCacheSize
+}
+
+// GetLogRetention returns how many deployment logs are kept per folder
+func (s ServerConfig) GetLogRetention() int {
+	if s.LogRetention <= 0 {
+		return logging.DefaultDeployLogRetention
+	}
+	return s.LogRetention
+}
+
+// GetHistoryFile returns the path of the deployment history file
+func (s ServerConfig) GetHistoryFile() string {
+	if s.HistoryFile == "" {
+		return filepath.Join(filepath.Dir(GetConfigPath()), "history.jsonl")
+	}
+	return s.HistoryFile
+}
+
+// NewHistoryStore returns the store deployments are recorded in
+func (s ServerConfig) NewHistoryStore() history.Store {
+	return history.NewFileStore(s.GetHistoryFile(), s.HistoryRetention)
+}
+
+// DefaultTimeout is the command timeout in seconds suggested for new folders
+const DefaultTimeout = 600
+
 var (
 	configPath string
 )
@@ -55,19 +632,23 @@ func GetConfigPath() string {
 		return configPath
 	}
 
-	// Try /etc first (for system-wide installation)
-	systemPath := "/etc/github-deployer/config.json"
-	if _, err := os.Stat(systemPath); err == nil {
-		configPath = systemPath
-		return configPath
-	}
-
-	// Fall back to user home directory
 	home, err := os.UserHomeDir()
 	if err != nil {
 		panic(fmt.Sprintf("cannot determine home directory: %v", err))
 	}
 
+	// Try /etc first (for system-wide installation), then the user home
+	// directory, accepting JSON or YAML files
+	for _, dir := range []string{"/etc/github-deployer", filepath.Join(home, ".github-deployer")} {
+		for _, name := range []string{"config.json", "config.yaml", "config.yml"} {
+			candidate := filepath.Join(dir, name)
+			if _, err := os.Stat(candidate); err == nil {
+				configPath = candidate
+				return configPath
+			}
+		}
+	}
+
 	configPath = filepath.Join(home, ".github-deployer", "config.json")
 	return configPath
 }
@@ -90,14 +671,310 @@ func Load() (*Config, error) {
 	}
 
 	var cfg Config
-	if err := json.Unmarshal(data, &cfg); err != nil {
+	if err := unmarshal(path, data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
+	migrated, err := Migrate(&cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.applyEnvOverrides()
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	// Persist the upgrade; the old file is kept as the backup
+	if migrated {
+		if err := Save(&cfg); err != nil {
+			slog.Warn("Failed to save migrated config", "error", err)
+		}
+	}
+
 	return &cfg, nil
 }
 
-// Save writes the configuration to disk
+// validate checks the configuration for values that cannot be used and
+// returns every problem found
+func (c *Config) validate() error {
+	var errs []error
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		errs = append(errs, fmt.Errorf("server: port must be between 1 and 65535, got %d", c.Server.Port))
+	}
+	if c.Server.Host != "" && net.ParseIP(c.Server.Host) == nil && !isValidHostname(c.Server.Host) {
+		errs = append(errs, fmt.Errorf("server: invalid host %q", c.Server.Host))
+	}
+	if (c.Server.TLSCertPath == "") != (c.Server.TLSKeyPath == "") {
+		errs = append(errs, fmt.Errorf("server: tls_cert_path and tls_key_path must be set together"))
+	}
+	switch c.Server.LogFormat {
+	case "", logging.FormatText, logging.FormatJSON:
+	default:
+		errs = append(errs, fmt.Errorf("server: unknown log_format %q (expected text or json)", c.Server.LogFormat))
+	}
+	if c.Server.DeliveryCacheSize < 0 {
+		errs = append(errs, fmt.Errorf("server: delivery_cache_size must not be negative, got %d", c.Server.DeliveryCacheSize))
+	}
+	if c.Server.HistoryRetention < 0 {
+		errs = append(errs, fmt.Errorf("server: history_retention must not be negative, got %d", c.Server.HistoryRetention))
+	}
+	if c.Server.LogRetention < 0 {
+		errs = append(errs, fmt.Errorf("server: log_retention must not be negative, got %d", c.Server.LogRetention))
+	}
+	if c.Server.DebounceSeconds < 0 {
+		errs = append(errs, fmt.Errorf("server: debounce_seconds must not be negative, got %d", c.Server.DebounceSeconds))
+	}
+	switch c.SMTP.TLSMode {
+	case notifier.TLSAuto, notifier.TLSNone, notifier.TLSStartTLS, notifier.TLSSSL:
+	default:
+		errs = append(errs, fmt.Errorf("smtp: unknown tls_mode %q (expected none, starttls or ssl)", c.SMTP.TLSMode))
+	}
+	if (c.Telegram.BotToken == "") != (c.Telegram.ChatID == "") {
+		errs = append(errs, fmt.Errorf("telegram: bot_token and chat_id must be set together"))
+	}
+	if c.Git.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("git: max_retries must not be negative, got %d", c.Git.MaxRetries))
+	}
+	seen := make(map[string]bool)
+	for _, folder := range c.Folders {
+		if seen[filepath.Clean(folder.Path)] {
+			errs = append(errs, fmt.Errorf("folder %s: watched more than once", folder.Path))
+		}
+		seen[filepath.Clean(folder.Path)] = true
+
+		if folder.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("folder %s: timeout must not be negative, got %d", folder.Path, folder.Timeout))
+		}
+		for _, pusher := range folder.AllowedPushers {
+			if strings.TrimSpace(pusher) == "" {
+				errs = append(errs, fmt.Errorf("folder %s: allowed_pushers contains an empty name", folder.Path))
+			}
+		}
+		if filepath.IsAbs(folder.WorkDir) {
+			errs = append(errs, fmt.Errorf("folder %s: work_dir must be relative to the folder path, got %s", folder.Path, folder.WorkDir))
+		}
+		for _, pattern := range folder.BranchPatterns() {
+			if _, err := path.Match(pattern, ""); err != nil {
+				errs = append(errs, fmt.Errorf("folder %s: invalid branch pattern %q: %w", folder.Path, pattern, err))
+			}
+		}
+		for _, pattern := range folder.PathFilters {
+			if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+				errs = append(errs, fmt.Errorf("folder %s: invalid path filter %q: %w", folder.Path, pattern, err))
+			}
+		}
+		switch folder.GetTrigger() {
+		case TriggerBranch, TriggerTag, TriggerRelease:
+		default:
+			errs = append(errs, fmt.Errorf("folder %s: unknown trigger %q (expected branch, tag or release)", folder.Path, folder.Trigger))
+		}
+		for key := range folder.Env {
+			if key == "" || strings.ContainsAny(key, "=\x00") {
+				errs = append(errs, fmt.Errorf("folder %s: invalid environment variable name %q", folder.Path, key))
+			}
+		}
+		for _, channel := range folder.Notify {
+			switch channel {
+			case NotifyEmail, NotifySlack, NotifyWebhook, NotifyTelegram:
+			default:
+				errs = append(errs, fmt.Errorf("folder %s: unknown notification channel %q (expected email, slack, webhook or telegram)", folder.Path, channel))
+			}
+		}
+		for _, recipient := range notifier.SplitAddresses(folder.NotifyTo) {
+			if _, err := mail.ParseAddress(recipient); err != nil {
+				errs = append(errs, fmt.Errorf("folder %s: invalid notify_to address %q: %w", folder.Path, recipient, err))
+			}
+		}
+		switch folder.PullStrategy {
+		case "", git.PullMerge, git.PullRebase, git.PullFFOnly:
+		default:
+			errs = append(errs, fmt.Errorf("folder %s: unknown pull strategy %q (expected merge, rebase or ff-only)", folder.Path, folder.PullStrategy))
+		}
+		switch folder.GetDirtyStrategy() {
+		case DirtyFail, DirtyStash, DirtyReset:
+		default:
+			errs = append(errs, fmt.Errorf("folder %s: unknown dirty strategy %q (expected fail, stash or reset)", folder.Path, folder.DirtyStrategy))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks that the configuration can actually be used to serve
+// deployments: besides the checks done by Load, it verifies that the
+// GitHub App key (when UsesGitHubApp), SMTP settings, TLS files and watched
+// folders are usable.
+// The returned error lists every problem found, one per line.
+func (c *Config) Validate() error {
+	errs := []error{c.validate()}
+
+	// Deployments from other hosts work without a GitHub App
+	if c.UsesGitHubApp() {
+		if c.GitHub.AppID <= 0 {
+			errs = append(errs, fmt.Errorf("github: app_id is not set"))
+		}
+		if err := checkPrivateKey(c.GitHub.PrivateKeyPath); err != nil {
+			errs = append(errs, fmt.Errorf("github: %w", err))
+		}
+	}
+	// Without a secret any request with a crafted signature would be
+	// accepted. Every provider is verified with it.
+	if c.GitHub.WebhookSecret == "" {
+		errs = append(errs, fmt.Errorf("github: webhook_secret is not set"))
+	}
+
+	// Deployment failures would otherwise go unnoticed
+	if !c.HasNotifications() {
+		errs = append(errs, fmt.Errorf("notifications: no channel configured, set smtp.host, slack.webhook_url, telegram.bot_token or webhook_notify.url"))
+	}
+
+	// Email is enabled by setting a host; the credentials are optional for
+	// relays accepting mail without authentication
+	if c.SMTP.Enabled() {
+		if c.SMTP.Port < 1 || c.SMTP.Port > 65535 {
+			errs = append(errs, fmt.Errorf("smtp: port must be between 1 and 65535, got %d", c.SMTP.Port))
+		}
+		if c.SMTP.From == "" {
+			errs = append(errs, fmt.Errorf("smtp: from address is not set"))
+		}
+		recipients := notifier.SplitAddresses(c.SMTP.To)
+		if len(recipients) == 0 {
+			errs = append(errs, fmt.Errorf("smtp: to address is not set"))
+		}
+		for _, recipient := range recipients {
+			if _, err := mail.ParseAddress(recipient); err != nil {
+				errs = append(errs, fmt.Errorf("smtp: invalid to address %q: %w", recipient, err))
+			}
+		}
+		if c.SMTP.Username == "" && c.SMTP.Password != "" {
+			errs = append(errs, fmt.Errorf("smtp: password is set without a username"))
+		}
+		if c.SMTP.NotifyCooldownMinutes < 0 {
+			errs = append(errs, fmt.Errorf("smtp: notify_cooldown_minutes must not be negative, got %d", c.SMTP.NotifyCooldownMinutes))
+		}
+		if _, err := notifier.LoadEmailTemplate(c.SMTP.TemplatePath); err != nil {
+			errs = append(errs, fmt.Errorf("smtp: %w", err))
+		}
+	} else if c.SMTP.From != "" || c.SMTP.To != "" || c.SMTP.Username != "" {
+		errs = append(errs, fmt.Errorf("smtp: host is not set"))
+	}
+
+	if c.Server.TLSEnabled() {
+		if err := c.Server.CheckTLSFiles(); err != nil {
+			errs = append(errs, fmt.Errorf("server: %w", err))
+		}
+	}
+
+	for _, folder := range c.Folders {
+		// The allowlist only contains GitHub's ranges, so webhooks from
+		// other hosts would all be rejected
+		if c.Server.RestrictToGitHubIPs && !git.IsGitHubURL(folder.RepoURL) {
+			errs = append(errs, fmt.Errorf("folder %s: server.restrict_to_github_ips rejects webhooks from %s, disable it to deploy repositories not on github.com", folder.Path, git.RepoHost(folder.RepoURL)))
+		}
+		if !git.IsGitRepository(folder.Path) {
+			errs = append(errs, fmt.Errorf("folder %s: not a git repository", folder.Path))
+		}
+		if folder.RunAsUser != "" {
+			if _, err := user.Lookup(folder.RunAsUser); err != nil {
+				errs = append(errs, fmt.Errorf("folder %s: run_as_user: %w", folder.Path, err))
+			}
+		}
+		if _, err := exec.LookPath(folder.GetShell()); err != nil {
+			errs = append(errs, fmt.Errorf("folder %s: shell: %w", folder.Path, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// UsesGitHubApp reports whether the GitHub App credentials are needed: to
+// report commit statuses, or because a watched repository is on github.com
+// and may be pulled with an installation token. A partially configured app
+// counts as used so its settings are still checked.
+func (c *Config) UsesGitHubApp() bool {
+	if c.GitHub.ReportStatus || c.GitHub.AppID != 0 || c.GitHub.InstallationID != 0 {
+		return true
+	}
+	for _, folder := range c.Folders {
+		if git.IsGitHubURL(folder.RepoURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPrivateKey verifies that the private key file can be read and
+// contains PEM data
+func checkPrivateKey(keyPath string) error {
+	if keyPath == "" {
+		return fmt.Errorf("private_key_path is not set")
+	}
+
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("cannot read private key: %w", err)
+	}
+	if block, _ := pem.Decode(data); block == nil {
+		return fmt.Errorf("private key %s contains no PEM data", keyPath)
+	}
+
+	return nil
+}
+
+// isValidHostname checks that a host name only contains letters, digits,
+// hyphens and dots
+func isValidHostname(host string) bool {
+	for _, r := range host {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Address returns the address the webhook server listens on
+func (s ServerConfig) Address() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// TLSEnabled reports whether the server should serve HTTPS
+func (s ServerConfig) TLSEnabled() bool {
+	return s.TLSCertPath != "" && s.TLSKeyPath != ""
+}
+
+// CheckTLSFiles verifies that the TLS certificate and key can be read
+func (s ServerConfig) CheckTLSFiles() error {
+	for _, path := range []string{s.TLSCertPath, s.TLSKeyPath} {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("cannot read TLS file: %w", err)
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// CheckPortAvailable verifies that the server can listen on its address,
+// explaining how to find the process holding the port if it is in use
+func (s ServerConfig) CheckPortAvailable() error {
+	listener, err := net.Listen("tcp", s.Address())
+	if err != nil {
+		if errors.Is(err, syscall.EADDRINUSE) {
+			return fmt.Errorf("port %d is already in use by another process, find it with 'lsof -i :%d' or 'ss -ltnp' (or change server.port)", s.Port, s.Port)
+		}
+		return fmt.Errorf("cannot listen on %s: %w", s.Address(), err)
+	}
+	return listener.Close()
+}
+
+// BackupPath returns the path of the backup of the previous configuration
+func BackupPath() string {
+	return GetConfigPath() + ".bak"
+}
+
+// Save writes the configuration to disk, keeping the previous configuration
+// as a backup (see Restore)
 func Save(cfg *Config) error {
 	path := GetConfigPath()
 
@@ -107,18 +984,122 @@ func Save(cfg *Config) error {
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	data, err := marshal(path, cfg.withoutEnvOverrides())
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
-	if err := os.WriteFile(path, data, 0600); err != nil {
+	previous, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read current config: %w", err)
+	}
+	if err == nil {
+		if err := writeFileAtomic(BackupPath(), previous, 0600); err != nil {
+			return fmt.Errorf("failed to back up config: %w", err)
+		}
+	}
+
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	return nil
+}
+
+// Restore replaces the configuration with the backup made by the last Save.
+// The replaced configuration becomes the new backup, so a restore can be
+// undone by restoring again.
+func Restore() error {
+	path := GetConfigPath()
+	backupPath := BackupPath()
+
+	backup, err := os.ReadFile(backupPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no backup found at %s", backupPath)
+		}
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	// Never restore a backup that Load would reject
+	var cfg Config
+	if err := unmarshal(path, backup, &cfg); err != nil {
+		return fmt.Errorf("failed to parse backup: %w", err)
+	}
+	if err := cfg.validate(); err != nil {
+		return fmt.Errorf("invalid backup: %w", err)
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read current config: %w", err)
+	}
+
+	if err := writeFileAtomic(path, backup, 0600); err != nil {
 		return fmt.Errorf("failed to write config: %w", err)
 	}
+	if current != nil {
+		if err := writeFileAtomic(backupPath, current, 0600); err != nil {
+			return fmt.Errorf("failed to back up config: %w", err)
+		}
+	}
 
 	return nil
 }
 
+// isYAML reports whether a config path uses the YAML format, as opposed to JSON
+func isYAML(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// unmarshal parses a configuration in the format implied by its path
+func unmarshal(path string, data []byte, cfg *Config) error {
+	if isYAML(path) {
+		return yaml.Unmarshal(data, cfg)
+	}
+	return json.Unmarshal(data, cfg)
+}
+
+// marshal encodes a configuration in the format implied by its path
+func marshal(path string, cfg *Config) ([]byte, error) {
+	if isYAML(path) {
+		return yaml.Marshal(cfg)
+	}
+	return json.MarshalIndent(cfg, "", "  ")
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it over path, so a crash leaves either the old or the new file
+// but never a truncated one
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Removing fails harmlessly once the file has been renamed
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	// Make sure the data is on disk before the rename makes it visible
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
 // Exists checks if the configuration file exists
 func Exists() bool {
 	_, err := os.Stat(GetConfigPath())
@@ -0,0 +1,517 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useConfigPath points the configuration at a file in a temporary
+// directory for the duration of the test and returns its path
+func useConfigPath(t *testing.T, name string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	SetConfigPath(path)
+	t.Cleanup(func() { SetConfigPath("") })
+	return path
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string
+	}{
+		{"valid", func(c *Config) {}, nil},
+		{"port", func(c *Config) { c.Server.Port = 0 }, []string{"port must be between 1 and 65535"}},
+		{"host", func(c *Config) { c.Server.Host = "bad host" }, []string{`invalid host "bad host"`}},
+		{"TLS key without certificate", func(c *Config) { c.Server.TLSKeyPath = "key.pem" }, []string{"must be set together"}},
+		{"debounce", func(c *Config) { c.Server.DebounceSeconds = -1 }, []string{"debounce_seconds must not be negative"}},
+		{"timeout", func(c *Config) { c.Folders[0].Timeout = -1 }, []string{"timeout must not be negative"}},
+		{"branch pattern", func(c *Config) { c.Folders[0].Branch = "main,[release" }, []string{`invalid branch pattern "[release"`}},
+		{"path filter", func(c *Config) { c.Folders[0].PathFilters = []string{"[src"} }, []string{`invalid path filter "[src"`}},
+		{"trigger", func(c *Config) { c.Folders[0].Trigger = "merge" }, []string{`unknown trigger "merge"`}},
+		{"pull strategy", func(c *Config) { c.Folders[0].PullStrategy = "squash" }, []string{`unknown pull strategy "squash"`}},
+		{"dirty strategy", func(c *Config) { c.Folders[0].DirtyStrategy = "keep" }, []string{`unknown dirty strategy "keep"`}},
+		{"duplicate folder", func(c *Config) {
+			c.Folders = append(c.Folders, WatchedFolder{Path: "/srv/app/", Branch: "staging"})
+		}, []string{"folder /srv/app/: watched more than once"}},
+		{"every problem reported", func(c *Config) {
+			c.Server.Port = 70000
+			c.Folders[0].Timeout = -1
+			c.Folders[0].Trigger = "merge"
+		}, []string{"port must be between", "timeout must not be negative", "unknown trigger"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				Server:  ServerConfig{Port: 8080, Host: "127.0.0.1"},
+				Folders: []WatchedFolder{{Path: "/srv/app", Branch: "main", PathFilters: []string{"src/**", "*.go"}}},
+			}
+			tt.modify(cfg)
+
+			err := cfg.validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("validate() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validate() = nil, want %q", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("validate() = %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRequiresWebhookSecret(t *testing.T) {
+	cfg := &Config{Server: ServerConfig{Port: 8080}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "webhook_secret is not set") {
+		t.Errorf("Validate() = %v, want a missing webhook secret error", err)
+	}
+
+	cfg.GitHub.WebhookSecret = "secret"
+	if err := cfg.Validate(); err != nil && strings.Contains(err.Error(), "webhook_secret") {
+		t.Errorf("Validate() = %v, want no webhook secret error", err)
+	}
+}
+
+func TestValidateShell(t *testing.T) {
+	cfg := &Config{Folders: []WatchedFolder{{Path: "/srv/app", Branch: "main", Shell: "no-such-shell"}}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "folder /srv/app: shell:") {
+		t.Errorf("Validate() = %v, want a shell error", err)
+	}
+
+	cfg.Folders[0].Shell = ""
+	if err := cfg.Validate(); err != nil && strings.Contains(err.Error(), "shell:") {
+		t.Errorf("Validate() = %v, want no shell error for the default shell", err)
+	}
+}
+
+func TestValidateRequiresNotificationChannel(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr bool
+	}{
+		{"none", func(c *Config) {}, true},
+		{"email", func(c *Config) {
+			c.SMTP = SMTPConfig{Host: "smtp.example.com", Port: 587, From: "a@example.com", To: "b@example.com"}
+		}, false},
+		{"slack", func(c *Config) { c.Slack.WebhookURL = "https://hooks.slack.com/services/T/B/X" }, false},
+		{"webhook", func(c *Config) { c.WebhookNotify.URL = "https://example.com/hook" }, false},
+		{"telegram", func(c *Config) { c.Telegram = TelegramConfig{BotToken: "123:token", ChatID: "-1001234"} }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Server: ServerConfig{Port: 8080}}
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+			gotErr := err != nil && strings.Contains(err.Error(), "notifications: no channel configured")
+			if gotErr != tt.wantErr {
+				t.Errorf("Validate() = %v, want a notification channel error %v", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "smtp:") {
+				t.Errorf("Validate() = %v, want no SMTP error", err)
+			}
+		})
+	}
+}
+
+func TestFindFolder(t *testing.T) {
+	cfg := &Config{Folders: []WatchedFolder{
+		{Path: "/srv/app", Branch: "main", RepoURL: "https://github.com/owner/app.git"},
+		{Path: "/srv/staging", Branch: "staging", RepoURL: "https://github.com/owner/app.git"},
+	}}
+
+	if f := cfg.FindFolderByPath("/srv/staging/"); f == nil || f.Branch != "staging" {
+		t.Errorf("FindFolderByPath(/srv/staging/) = %+v, want the staging folder", f)
+	}
+	if f := cfg.FindFolderByPath("/srv/other"); f != nil {
+		t.Errorf("FindFolderByPath(/srv/other) = %+v, want nil", f)
+	}
+
+	if f := cfg.FindFolderByRepo("git@github.com:owner/app.git", "main"); f == nil || f.Path != "/srv/app" {
+		t.Errorf("FindFolderByRepo(ssh URL, main) = %+v, want /srv/app", f)
+	}
+	if f := cfg.FindFolderByRepo("https://github.com/owner/app", "release"); f != nil {
+		t.Errorf("FindFolderByRepo(release) = %+v, want nil", f)
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	path := useConfigPath(t, "config.json")
+
+	cfg := &Config{Version: CurrentVersion, Server: ServerConfig{Port: 8080}}
+	cfg.GitHub.WebhookSecret = "secret"
+	cfg.Folders = []WatchedFolder{{Path: "/srv/app", Branch: "main", Command: "make deploy", Timeout: 60}}
+	if err := Save(cfg); err != nil {
+		t.Fatalf("Save returned error: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("config mode = %o, want 600", perm)
+	}
+
+	loaded, err := Load()
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, cfg) {
+		t.Errorf("loaded %+v, want %+v", loaded, cfg)
+	}
+}
+
+func TestSaveReplacesConfigAtomically(t *testing.T) {
+	path := useConfigPath(t, "config.json")
+	if err := os.WriteFile(path, []byte(`{"server": {"port": 1}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Save(&Config{Server: ServerConfig{Port: 8080}}); err != nil {
+		t.Fatalf("Save returned error: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("config mode = %o after replacing a 644 file, want 600", perm)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("directory holds %d files, want only the config and its backup (no temporary files)", len(entries))
+	}
+}
+
+func TestSaveKeepsBackup(t *testing.T) {
+	path := useConfigPath(t, "config.json")
+
+	if err := Save(&Config{Server: ServerConfig{Port: 8080}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(BackupPath()); !os.IsNotExist(err) {
+		t.Errorf("first save created a backup: %v", err)
+	}
+
+	first, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(&Config{Server: ServerConfig{Port: 9090}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if BackupPath() != path+".bak" {
+		t.Errorf("BackupPath() = %q, want %q", BackupPath(), path+".bak")
+	}
+	backup, err := os.ReadFile(BackupPath())
+	if err != nil {
+		t.Fatalf("no backup after the second save: %v", err)
+	}
+	if string(backup) != string(first) {
+		t.Errorf("backup = %s, want the previous config %s", backup, first)
+	}
+	info, err := os.Stat(BackupPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("backup mode = %o, want 600", perm)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	useConfigPath(t, "config.json")
+
+	if err := Restore(); err == nil || !strings.Contains(err.Error(), "no backup found") {
+		t.Errorf("Restore() without a backup = %v, want an error", err)
+	}
+
+	for _, port := range []int{8080, 9090} {
+		if err := Save(&Config{Version: CurrentVersion, Server: ServerConfig{Port: port}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Restoring swaps the config and the backup, so it can be undone
+	for _, want := range []int{8080, 9090} {
+		if err := Restore(); err != nil {
+			t.Fatalf("Restore returned error: %v", err)
+		}
+		cfg, err := Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Server.Port != want {
+			t.Errorf("port after restore = %d, want %d", cfg.Server.Port, want)
+		}
+	}
+}
+
+func TestRestoreRejectsInvalidBackup(t *testing.T) {
+	path := useConfigPath(t, "config.json")
+	if err := Save(&Config{Version: CurrentVersion, Server: ServerConfig{Port: 8080}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(BackupPath(), []byte(`{"server": {"port": 0}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Restore(); err == nil || !strings.Contains(err.Error(), "invalid backup") {
+		t.Errorf("Restore() = %v, want an invalid backup error", err)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("config at %s no longer loads: %v", path, err)
+	}
+	if cfg.Server.Port != 8080 {
+		t.Errorf("port = %d, want the config left unchanged", cfg.Server.Port)
+	}
+}
+
+func TestWriteFileAtomicKeepsTargetOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	// Renaming a file over a non-empty directory fails
+	target := filepath.Join(dir, "config.json")
+	if err := os.MkdirAll(filepath.Join(target, "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(target, []byte("{}"), 0600); err == nil {
+		t.Fatal("writeFileAtomic over a directory returned no error")
+	}
+	if _, err := os.Stat(filepath.Join(target, "keep")); err != nil {
+		t.Errorf("target was modified: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want the temporary file removed", len(entries))
+	}
+}
+
+func TestFormatChosenByExtension(t *testing.T) {
+	tests := []struct {
+		name   string
+		marker string // Only present in this encoding of the config
+	}{
+		{"config.json", `"port": 8080`},
+		{"config.yaml", "port: 8080"},
+		{"config.yml", "port: 8080"},
+		{"config.YAML", "port: 8080"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := useConfigPath(t, tt.name)
+			cfg := &Config{
+				Version: CurrentVersion,
+				Server:  ServerConfig{Port: 8080},
+				WebhookNotify: WebhookNotifyConfig{
+					URL:     "https://example.com/hook",
+					Headers: map[string]string{"Authorization": "Bearer token"},
+				},
+				Folders: []WatchedFolder{{Path: "/srv/app", Branch: "main", PathFilters: []string{"src/**"}}},
+			}
+			if err := Save(cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), tt.marker) {
+				t.Errorf("saved %s is not in the expected format:\n%s", tt.name, data)
+			}
+
+			loaded, err := Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(loaded, cfg) {
+				t.Errorf("loaded %+v, want %+v", loaded, cfg)
+			}
+		})
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	path := useConfigPath(t, "config.yaml")
+	data := `version: 1
+server:
+  port: 9000
+folders:
+  - path: /srv/app
+    branch: main
+    command: make deploy
+    timeout: 60
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 9000 || len(cfg.Folders) != 1 || cfg.Folders[0].Command != "make deploy" || cfg.Folders[0].Timeout != 60 {
+		t.Errorf("Load() = %+v, want the YAML values", cfg)
+	}
+}
+
+func TestGetWatchersByRepo(t *testing.T) {
+	cfg := &Config{Folders: []WatchedFolder{
+		{Path: "/srv/app", Branch: "main", RepoURL: "git@github.com:Owner/App.git"},
+		{Path: "/srv/staging", Branch: "staging", RepoURL: "https://github.com/owner/app"},
+		{Path: "/srv/other", Branch: "main", RepoURL: "https://github.com/owner/other.git"},
+		{Path: "/srv/local", Branch: "main"},
+	}}
+
+	folders, err := cfg.GetWatchersByRepo("Owner/APP", "https://github.com/Owner/APP.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, f := range folders {
+		paths = append(paths, f.Path)
+	}
+	if want := []string{"/srv/app", "/srv/staging"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("GetWatchersByRepo(Owner/APP) = %v, want %v", paths, want)
+	}
+
+	// The returned folders point into the configuration
+	if folders[0] != &cfg.Folders[0] {
+		t.Error("GetWatchersByRepo returned a copy of the folder")
+	}
+
+	if _, err := cfg.GetWatchersByRepo("owner/missing", "https://github.com/owner/missing.git"); err == nil {
+		t.Error("GetWatchersByRepo for an unwatched repository returned no error")
+	}
+}
+
+func TestMatchesBranch(t *testing.T) {
+	tests := []struct {
+		branch  string
+		pushed  string
+		want    bool
+		pattern bool
+	}{
+		{"main", "main", true, false},
+		{"main", "develop", false, false},
+		{"main, staging", "staging", true, true},
+		{"main,release/*", "release/1.2", true, true},
+		{"main,release/*", "release/1.2/hotfix", false, true},
+		{"feature-?", "feature-a", true, true},
+		{"main,release/*", "", false, true},
+	}
+
+	for _, tt := range tests {
+		folder := WatchedFolder{Path: "/srv/app", Branch: tt.branch}
+		if got := folder.MatchesBranch(tt.pushed); got != tt.want {
+			t.Errorf("MatchesBranch(%q) with branch %q = %v, want %v", tt.pushed, tt.branch, got, tt.want)
+		}
+		if got := folder.HasBranchPattern(); got != tt.pattern {
+			t.Errorf("HasBranchPattern() with branch %q = %v, want %v", tt.branch, got, tt.pattern)
+		}
+	}
+}
+
+func TestMatchesRepo(t *testing.T) {
+	tests := []struct {
+		name     string
+		repoURL  string
+		fullName string
+		cloneURL string
+		want     bool
+	}{
+		{"same URL", "https://github.com/owner/app.git", "owner/app", "https://github.com/owner/app.git", true},
+		{"SSH folder, HTTPS clone URL", "git@github.com:Owner/App.git", "owner/app", "https://github.com/owner/app.git", true},
+		{"ssh:// folder with port", "ssh://git@github.com:22/owner/app.git", "owner/app", "https://github.com/owner/app.git", true},
+		{"full name only", "git@github.com:owner/app.git", "Owner/App", "", true},
+		{"clone URL only", "git@github.com:owner/app.git", "", "https://github.com/owner/app.git", true},
+		{"same slug on another host", "https://gitlab.com/owner/app.git", "owner/app", "https://github.com/owner/app.git", false},
+		{"same slug on another host over SSH", "git@git.example.com:owner/app.git", "owner/app", "https://github.com/owner/app.git", false},
+		{"other repository", "https://github.com/owner/other.git", "owner/app", "https://github.com/owner/app.git", false},
+		{"no repository URL", "", "owner/app", "https://github.com/owner/app.git", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder := WatchedFolder{Path: "/srv/app", RepoURL: tt.repoURL}
+			if got := folder.MatchesRepo(tt.fullName, tt.cloneURL); got != tt.want {
+				t.Errorf("MatchesRepo(%q, %q) = %v, want %v", tt.fullName, tt.cloneURL, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateGitHubAppOnlyForGitHub(t *testing.T) {
+	tests := []struct {
+		name    string
+		repos   []string
+		status  bool
+		wantApp bool
+	}{
+		{"GitHub repository", []string{"https://github.com/owner/repo.git"}, false, true},
+		{"GitHub among others", []string{"https://gitlab.com/owner/repo.git", "git@github.com:owner/repo.git"}, false, true},
+		{"GitLab only", []string{"https://gitlab.com/owner/repo.git"}, false, false},
+		{"Bitbucket and Gitea", []string{"git@bitbucket.org:owner/repo.git", "https://gitea.example.com/owner/repo"}, false, false},
+		{"commit statuses", []string{"https://gitlab.com/owner/repo.git"}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Server: ServerConfig{Port: 8080}}
+			cfg.GitHub.WebhookSecret = "secret"
+			cfg.GitHub.ReportStatus = tt.status
+			for _, repo := range tt.repos {
+				cfg.Folders = append(cfg.Folders, WatchedFolder{Path: t.TempDir(), RepoURL: repo})
+			}
+
+			err := cfg.Validate()
+			gotApp := err != nil && strings.Contains(err.Error(), "app_id is not set") && strings.Contains(err.Error(), "private_key_path is not set")
+			if gotApp != tt.wantApp {
+				t.Errorf("Validate() = %v, want GitHub App errors %v", err, tt.wantApp)
+			}
+		})
+	}
+}
+
+func TestValidateRestrictToGitHubIPs(t *testing.T) {
+	cfg := &Config{Server: ServerConfig{Port: 8080, RestrictToGitHubIPs: true}}
+	cfg.GitHub.WebhookSecret = "secret"
+	cfg.Folders = []WatchedFolder{
+		{Path: "/srv/github", RepoURL: "https://github.com/owner/repo.git"},
+		{Path: "/srv/gitlab", RepoURL: "https://gitlab.com/owner/repo.git"},
+	}
+
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "folder /srv/gitlab: server.restrict_to_github_ips rejects webhooks from gitlab.com") {
+		t.Errorf("Validate() = %v, want an error about the GitLab folder", err)
+	}
+	if strings.Contains(err.Error(), "folder /srv/github: server.restrict_to_github_ips") {
+		t.Errorf("Validate() = %v, want no error about the GitHub folder", err)
+	}
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"os"
+	"sort"
+)
+
+// envOverrides maps environment variables to the secret fields they override
+var envOverrides = map[string]func(c *Config) *string{
+	"DEPLOYER_WEBHOOK_SECRET":          func(c *Config) *string { return &c.GitHub.WebhookSecret },
+	"DEPLOYER_GITHUB_PRIVATE_KEY_PATH": func(c *Config) *string { return &c.GitHub.PrivateKeyPath },
+	"DEPLOYER_SMTP_USERNAME":           func(c *Config) *string { return &c.SMTP.Username },
+	"DEPLOYER_SMTP_PASSWORD":           func(c *Config) *string { return &c.SMTP.Password },
+	"DEPLOYER_TELEGRAM_BOT_TOKEN":      func(c *Config) *string { return &c.Telegram.BotToken },
+}
+
+// EnvOverrides returns the environment overrides set in the current
+// environment as sorted KEY=value pairs
+func EnvOverrides() []string {
+	var env []string
+	for name := range envOverrides {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	sort.Strings(env)
+	return env
+}
+
+// applyEnvOverrides replaces fields with the values of their environment
+// variables when set, remembering the values from the file
+func (c *Config) applyEnvOverrides() {
+	for name, field := range envOverrides {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		if c.fileValues == nil {
+			c.fileValues = make(map[string]string)
+		}
+		c.fileValues[name] = *field(c)
+		*field(c) = value
+	}
+}
+
+// withoutEnvOverrides returns a copy of the configuration with the values
+// from the file in place of the environment overrides
+func (c *Config) withoutEnvOverrides() *Config {
+	if len(c.fileValues) == 0 {
+		return c
+	}
+
+	file := *c
+	for name, value := range c.fileValues {
+		*envOverrides[name](&file) = value
+	}
+	return &file
+}
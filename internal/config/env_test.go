@@ -0,0 +1,57 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEnvOverrides(t *testing.T) {
+	path := useConfigPath(t, "config.json")
+	cfg := &Config{
+		Version: CurrentVersion,
+		Server:  ServerConfig{Port: 8080},
+		GitHub:  GitHubConfig{WebhookSecret: "file-secret"},
+		SMTP:    SMTPConfig{Password: "file-password"},
+	}
+	if err := Save(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DEPLOYER_WEBHOOK_SECRET", "env-secret")
+	t.Setenv("DEPLOYER_SMTP_PASSWORD", "env-password")
+
+	loaded, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.GitHub.WebhookSecret != "env-secret" {
+		t.Errorf("WebhookSecret = %q, want the environment value", loaded.GitHub.WebhookSecret)
+	}
+	if loaded.SMTP.Password != "env-password" {
+		t.Errorf("SMTP password = %q, want the environment value", loaded.SMTP.Password)
+	}
+
+	// Saving must write the values from the file, never the overrides
+	loaded.Server.Port = 9090
+	if err := Save(loaded); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"env-secret", "env-password"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("saved config contains override %q:\n%s", secret, data)
+		}
+	}
+	for _, value := range []string{"file-secret", "file-password", "9090"} {
+		if !strings.Contains(string(data), value) {
+			t.Errorf("saved config lost %q:\n%s", value, data)
+		}
+	}
+	if loaded.GitHub.WebhookSecret != "env-secret" {
+		t.Errorf("Save changed the in-memory override to %q", loaded.GitHub.WebhookSecret)
+	}
+}
@@ -0,0 +1,40 @@
+package config
+
+import "fmt"
+
+// CurrentVersion is the schema version written by this release
+const CurrentVersion = 1
+
+// migrations[v] upgrades a configuration from version v to v+1
+var migrations = []func(c *Config){
+	migrateV0,
+}
+
+// Migrate upgrades a configuration written by an older release to the
+// current schema version and reports whether anything changed
+func Migrate(c *Config) (bool, error) {
+	if c.Version > CurrentVersion {
+		return false, fmt.Errorf("config version %d is newer than the supported version %d, upgrade the deployer", c.Version, CurrentVersion)
+	}
+	if c.Version == CurrentVersion {
+		return false, nil
+	}
+
+	for c.Version < CurrentVersion {
+		migrations[c.Version](c)
+		c.Version++
+	}
+
+	return true, nil
+}
+
+// migrateV0 upgrades unversioned configurations. Folders from before
+// per-folder timeouts ran with a fixed 10 minute timeout, which a missing
+// timeout would now turn into no timeout at all.
+func migrateV0(c *Config) {
+	for i := range c.Folders {
+		if c.Folders[i].Timeout == 0 {
+			c.Folders[i].Timeout = DefaultTimeout
+		}
+	}
+}
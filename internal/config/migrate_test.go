@@ -0,0 +1,74 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMigrateV0(t *testing.T) {
+	cfg := &Config{Folders: []WatchedFolder{
+		{Path: "/srv/a"},
+		{Path: "/srv/b", Timeout: 30},
+	}}
+
+	migrated, err := Migrate(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !migrated {
+		t.Error("Migrate reported no change for a version 0 config")
+	}
+	if cfg.Version != CurrentVersion {
+		t.Errorf("Version = %d, want %d", cfg.Version, CurrentVersion)
+	}
+	if cfg.Folders[0].Timeout != 600 {
+		t.Errorf("missing timeout migrated to %d, want 600", cfg.Folders[0].Timeout)
+	}
+	if cfg.Folders[1].Timeout != 30 {
+		t.Errorf("explicit timeout migrated to %d, want 30", cfg.Folders[1].Timeout)
+	}
+
+	migrated, err = Migrate(cfg)
+	if err != nil || migrated {
+		t.Errorf("Migrate on a current config = %v, %v, want false, nil", migrated, err)
+	}
+}
+
+func TestMigrateRejectsNewerVersion(t *testing.T) {
+	cfg := &Config{Version: CurrentVersion + 1}
+	if _, err := Migrate(cfg); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Migrate() = %v, want a newer version error", err)
+	}
+}
+
+func TestLoadSavesMigratedConfig(t *testing.T) {
+	path := useConfigPath(t, "config.json")
+	old := `{"server": {"port": 8080}, "folders": [{"path": "/srv/app", "branch": "main"}]}`
+	if err := os.WriteFile(path, []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Version != CurrentVersion || cfg.Folders[0].Timeout != 600 {
+		t.Errorf("loaded version %d timeout %d, want %d and 600", cfg.Version, cfg.Folders[0].Timeout, CurrentVersion)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"version": 1`) {
+		t.Errorf("migrated config was not saved:\n%s", data)
+	}
+	backup, err := os.ReadFile(BackupPath())
+	if err != nil {
+		t.Fatalf("old config was not kept as the backup: %v", err)
+	}
+	if string(backup) != old {
+		t.Errorf("backup = %s, want the original file", backup)
+	}
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// runAs makes cmd run as the named user and returns the user's HOME, USER
+// and LOGNAME variables. Switching to another user requires root.
+func runAs(cmd *exec.Cmd, username string) ([]string, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user %s: %w", username, err)
+	}
+	env := []string{"HOME=" + u.HomeDir, "USER=" + u.Username, "LOGNAME=" + u.Username}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %q of user %s", u.Uid, username)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %q of user %s", u.Gid, username)
+	}
+
+	// Nothing to switch when already running as the user
+	if uint64(os.Geteuid()) == uid {
+		return env, nil
+	}
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("cannot run commands as %s: the deployer runs as uid %d, switching users requires root", username, os.Geteuid())
+	}
+
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up groups of user %s: %w", username, err)
+	}
+	var groups []uint32
+	for _, id := range groupIDs {
+		if group, err := strconv.ParseUint(id, 10, 32); err == nil {
+			groups = append(groups, uint32(group))
+		}
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups},
+	}
+	return env, nil
+}
@@ -0,0 +1,78 @@
+//go:build !windows
+
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRunAsCurrentUser(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip("cannot look up the current user")
+	}
+
+	cmd := exec.Command("true")
+	env, err := runAs(cmd, current.Username)
+	if err != nil {
+		t.Fatalf("runAs returned error: %v", err)
+	}
+	if cmd.SysProcAttr != nil {
+		t.Error("runAs switched credentials for the current user")
+	}
+	for _, want := range []string{"HOME=" + current.HomeDir, "USER=" + current.Username, "LOGNAME=" + current.Username} {
+		if !slices.Contains(env, want) {
+			t.Errorf("env = %q, want %q", env, want)
+		}
+	}
+}
+
+func TestRunAsUnknownUser(t *testing.T) {
+	if _, err := runAs(exec.Command("true"), "no-such-user-deployer"); err == nil {
+		t.Error("runAs for an unknown user returned no error")
+	}
+}
+
+func TestRunAsOtherUser(t *testing.T) {
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no nobody user")
+	}
+
+	cmd := exec.Command("true")
+	_, err = runAs(cmd, "nobody")
+	if os.Geteuid() != 0 {
+		if err == nil || !strings.Contains(err.Error(), "requires root") {
+			t.Errorf("runAs without root = %v, want a requires root error", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("runAs returned error: %v", err)
+	}
+	cred := cmd.SysProcAttr.Credential
+	if cred == nil || nobody.Uid != itoa(cred.Uid) || nobody.Gid != itoa(cred.Gid) {
+		t.Errorf("credential = %+v, want uid %s gid %s", cred, nobody.Uid, nobody.Gid)
+	}
+
+	// The command really runs as the user
+	e := NewExecutor(os.TempDir())
+	e.SetUser("nobody")
+	output, err := e.Execute("id -u; echo $USER")
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if want := nobody.Uid + "\nnobody\n"; output != want {
+		t.Errorf("output = %q, want %q", output, want)
+	}
+}
+
+func itoa(n uint32) string {
+	return strconv.FormatUint(uint64(n), 10)
+}
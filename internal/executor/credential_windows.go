@@ -0,0 +1,13 @@
+//go:build windows
+
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// runAs is not supported on Windows
+func runAs(cmd *exec.Cmd, username string) ([]string, error) {
+	return nil, fmt.Errorf("running commands as user %s is not supported on Windows", username)
+}
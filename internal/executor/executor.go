@@ -1,63 +1,322 @@
 package executor
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Executor handles command execution
 type Executor struct {
 	workDir string
+	shell   string
 	timeout time.Duration
+	grace   time.Duration // Time between SIGTERM and SIGKILL on timeout
+	env     []string
+	user    string
+	logger  *slog.Logger
+	runner  Runner
+
+	maxOutput int // Bytes of output kept per stream, 0 = unlimited
+}
+
+// CommandError is returned when a command exits unsuccessfully and carries
+// the output captured from it
+type CommandError struct {
+	Command string
+	Stdout  string
+	Stderr  string
+	Err     error
+}
+
+// Error implements the error interface
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command failed: %v\nStdout: %s\nStderr: %s", e.Err, e.Stdout, e.Stderr)
+}
+
+// Unwrap returns the underlying error
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Runner runs a prepared command until it exits, writing its output to
+// cmd.Stdout and cmd.Stderr. The command is stopped once ctx is done, in
+// which case the runner must return as well.
+type Runner func(ctx context.Context, cmd *exec.Cmd) error
+
+// ExecRunner is the default Runner, starting the command as a process
+func ExecRunner(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Wait()
 }
 
 // NewExecutor creates a new command executor
 func NewExecutor(workDir string) *Executor {
 	return &Executor{
 		workDir: workDir,
+		shell:   "sh",
 		timeout: 10 * time.Minute, // Default 10 minute timeout
+		logger:  slog.Default(),
+		runner:  ExecRunner,
 	}
 }
 
-// SetTimeout sets the command execution timeout
+// SetRunner replaces how commands are run, e.g. with a fake returning canned
+// output and errors in tests (nil restores ExecRunner)
+func (e *Executor) SetRunner(runner Runner) {
+	if runner == nil {
+		runner = ExecRunner
+	}
+	e.runner = runner
+}
+
+// SetLogger sets the logger commands are traced to at debug level
+func (e *Executor) SetLogger(logger *slog.Logger) {
+	e.logger = logger
+}
+
+// SetShell sets the shell commands are run with using -c (default sh)
+func (e *Executor) SetShell(shell string) {
+	e.shell = shell
+}
+
+// SetTimeout sets the command execution timeout (0 disables the timeout)
 func (e *Executor) SetTimeout(timeout time.Duration) {
 	e.timeout = timeout
 }
 
-// Execute runs a command in the working directory
+// SetKillGracePeriod makes a timed out command receive SIGTERM first and
+// SIGKILL only if it is still running after the grace period, so it can
+// clean up (0 kills it right away). Windows has no SIGTERM, so commands are
+// always killed right away there.
+func (e *Executor) SetKillGracePeriod(grace time.Duration) {
+	e.grace = grace
+}
+
+// SetEnv sets extra environment variables (KEY=value) for commands, added
+// on top of the process environment
+func (e *Executor) SetEnv(env []string) {
+	e.env = env
+}
+
+// SetUser runs commands as another user (empty runs them as the current
+// user). The deployer must run as root to switch users.
+func (e *Executor) SetUser(username string) {
+	e.user = username
+}
+
+// SetMaxOutput limits the output kept in memory to the last maxBytes bytes
+// of each stream (0 keeps everything). Earlier output is replaced by a
+// truncation marker; it is still logged while the command runs.
+func (e *Executor) SetMaxOutput(maxBytes int) {
+	e.maxOutput = maxBytes
+}
+
+// waitDelay is how long a killed command may take to release its output,
+// e.g. while a background child process still holds it open
+const waitDelay = 5 * time.Second
+
+// Execute runs a command in the working directory and returns its combined output
 func (e *Executor) Execute(command string) (string, error) {
-	// Parse command into parts
-	parts := strings.Fields(command)
-	if len(parts) == 0 {
+	return e.ExecuteContext(context.Background(), command)
+}
+
+// ExecuteContext is Execute with a context; the command is killed once the
+// context is done or the timeout expires, whichever comes first
+func (e *Executor) ExecuteContext(ctx context.Context, command string) (string, error) {
+	if strings.TrimSpace(command) == "" {
 		return "", fmt.Errorf("empty command")
 	}
 
-	// Create command with timeout
-	cmd := exec.Command(parts[0], parts[1:]...)
+	// Apply the command timeout on top of the caller's deadline
+	runCtx := ctx
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	// Run through a shell so pipes, &&, quoting and variable expansion work
+	cmd := exec.CommandContext(runCtx, e.shell, "-c", command)
 	cmd.Dir = e.workDir
+	cmd.WaitDelay = e.grace + waitDelay
+	if e.user != "" {
+		userEnv, err := runAs(cmd, e.user)
+		if err != nil {
+			return "", &CommandError{Command: command, Err: err}
+		}
+		cmd.Env = append(os.Environ(), userEnv...)
+	}
+	if len(e.env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, e.env...)
+	}
+
+	// Stopping only the shell would leave the processes it started running
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return e.stop(cmd)
+	}
+
+	// Capture stdout and stderr separately while keeping the combined
+	// output, and log both line by line as they are written
+	stdout := &tailBuffer{max: e.maxOutput}
+	stderr := &tailBuffer{max: e.maxOutput}
+	combined := &tailBuffer{max: e.maxOutput}
+	stdoutLines := &lineLogger{logger: e.logger, stream: "stdout"}
+	stderrLines := &lineLogger{logger: e.logger, stream: "stderr"}
+	defer stdoutLines.Flush()
+	defer stderrLines.Flush()
+	cmd.Stdout = io.MultiWriter(stdout, combined, stdoutLines)
+	cmd.Stderr = io.MultiWriter(stderr, combined, stderrLines)
+
+	e.logger.Debug("Starting command", "command", command, "dir", e.workDir)
+	start := time.Now()
+
+	err := e.runner(runCtx, cmd)
+	e.logger.Debug("Command finished", "command", command, "duration_ms", time.Since(start).Milliseconds(), "error", err)
+	switch {
+	case err == nil:
+		return combined.String(), nil
+	case ctx.Err() != nil:
+		return combined.String(), fmt.Errorf("command cancelled: %w", ctx.Err())
+	case errors.Is(runCtx.Err(), context.DeadlineExceeded):
+		return combined.String(), fmt.Errorf("command timed out after %v", e.timeout)
+	}
+	return combined.String(), &CommandError{
+		Command: command,
+		Stdout:  stdout.String(),
+		Stderr:  stderr.String(),
+		Err:     err,
+	}
+}
 
-	// Set up timeout
-	done := make(chan error, 1)
-	var output []byte
-	var err error
+// processPollInterval is how often a terminated process group is checked
+// for having exited during the kill grace period
+const processPollInterval = 100 * time.Millisecond
 
+// stop stops a running command and its process group, giving it the grace
+// period to exit after SIGTERM before it is killed
+func (e *Executor) stop(cmd *exec.Cmd) error {
+	if e.grace <= 0 {
+		return killProcessGroup(cmd)
+	}
+
+	e.logger.Debug("Terminating command", "pid", cmd.Process.Pid, "grace_period", e.grace)
+	if err := terminateProcessGroup(cmd); err != nil {
+		return err
+	}
 	go func() {
-		output, err = cmd.CombinedOutput()
-		done <- err
+		deadline := time.Now().Add(e.grace)
+		for processGroupAlive(cmd) {
+			if time.Now().After(deadline) {
+				e.logger.Warn("Command still running after grace period, killing it", "pid", cmd.Process.Pid, "grace_period", e.grace)
+				killProcessGroup(cmd)
+				return
+			}
+			time.Sleep(processPollInterval)
+		}
 	}()
+	return nil
+}
 
-	select {
-	case <-time.After(e.timeout):
-		if cmd.Process != nil {
-			cmd.Process.Kill()
-		}
-		return "", fmt.Errorf("command timed out after %v", e.timeout)
-	case err := <-done:
-		if err != nil {
-			return string(output), fmt.Errorf("command failed: %w\nOutput: %s", err, string(output))
+// maxLogLine is the longest line logged at once; longer lines (e.g.
+// progress bars without newlines) are split
+const maxLogLine = 64 * 1024
+
+// lineLogger is a writer logging every complete line written to it
+type lineLogger struct {
+	logger *slog.Logger
+	stream string // stdout or stderr
+	buf    []byte
+}
+
+func (w *lineLogger) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
 		}
-		return string(output), nil
+		w.log(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) >= maxLogLine {
+		w.Flush()
+	}
+	return len(p), nil
+}
+
+// Flush logs the last line if it did not end with a newline
+func (w *lineLogger) Flush() {
+	if len(w.buf) > 0 {
+		w.log(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *lineLogger) log(line []byte) {
+	w.logger.Info("Command output", "stream", w.stream, "line", string(bytes.TrimRight(line, "\r")))
+}
+
+// tailBuffer is a buffer keeping only the last max bytes written to it (all
+// of them if max is 0). It is safe for concurrent writes, so stdout and
+// stderr can be interleaved into a single combined output.
+type tailBuffer struct {
+	max int
+
+	mu      sync.Mutex
+	buf     []byte
+	dropped int64 // Bytes discarded from the start
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buf = append(b.buf, p...)
+	// Compact only once twice the limit is buffered to avoid copying the
+	// tail on every write
+	if b.max > 0 && len(b.buf) > 2*b.max {
+		drop := len(b.buf) - b.max
+		b.dropped += int64(drop)
+		b.buf = append(b.buf[:0], b.buf[drop:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the kept output, starting with a truncation marker if
+// earlier output was discarded
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tail := b.buf
+	dropped := b.dropped
+	if b.max > 0 && len(tail) > b.max {
+		dropped += int64(len(tail) - b.max)
+		tail = tail[len(tail)-b.max:]
+	}
+	if dropped == 0 {
+		return string(tail)
+	}
+
+	// Start at a full line if the cut happened in the middle of one
+	if i := bytes.IndexByte(tail, '\n'); i >= 0 && i < len(tail)-1 {
+		dropped += int64(i + 1)
+		tail = tail[i+1:]
 	}
+	return fmt.Sprintf("... truncated %d bytes ...\n%s", dropped, tail)
 }
@@ -0,0 +1,366 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecuteCapturesOutput(t *testing.T) {
+	e := NewExecutor(t.TempDir())
+
+	output, err := e.Execute("echo out; echo err >&2")
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if !strings.Contains(output, "out\n") || !strings.Contains(output, "err\n") {
+		t.Errorf("combined output = %q, want both stdout and stderr", output)
+	}
+}
+
+func TestExecuteCommandError(t *testing.T) {
+	e := NewExecutor(t.TempDir())
+
+	command := "echo building; echo broken >&2; exit 3"
+	output, err := e.Execute(command)
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("error = %v, want a *CommandError", err)
+	}
+	if cmdErr.Command != command {
+		t.Errorf("Command = %q, want %q", cmdErr.Command, command)
+	}
+	if cmdErr.Stdout != "building\n" {
+		t.Errorf("Stdout = %q, want %q", cmdErr.Stdout, "building\n")
+	}
+	if cmdErr.Stderr != "broken\n" {
+		t.Errorf("Stderr = %q, want %q", cmdErr.Stderr, "broken\n")
+	}
+	if !strings.Contains(output, "building") {
+		t.Errorf("output = %q, want the output of the failed command", output)
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("error = %v, want it to wrap an *exec.ExitError", err)
+	}
+	if exitErr.ExitCode() != 3 {
+		t.Errorf("exit code = %d, want 3", exitErr.ExitCode())
+	}
+}
+
+func TestExecuteMissingCommand(t *testing.T) {
+	e := NewExecutor(t.TempDir())
+
+	_, err := e.Execute("no-such-command-for-the-executor")
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("error = %v, want a *CommandError", err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 127 {
+		t.Errorf("error = %v, want the shell to exit with 127", err)
+	}
+}
+
+func TestExecuteRunsThroughShell(t *testing.T) {
+	dir := t.TempDir()
+	e := NewExecutor(dir)
+
+	output, err := e.Execute(`NAME="a b"; echo "$NAME" | tr a-z A-Z > out.txt && cat out.txt`)
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if output != "A B\n" {
+		t.Errorf("output = %q, want %q", output, "A B\n")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.txt")); err != nil {
+		t.Errorf("command did not run in the working directory: %v", err)
+	}
+}
+
+func TestExecuteShell(t *testing.T) {
+	// A fake shell printing how it was called
+	shell := filepath.Join(t.TempDir(), "fake-shell")
+	if err := os.WriteFile(shell, []byte("#!/bin/sh\necho \"$0 $1 $2\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	e := NewExecutor(t.TempDir())
+	e.SetShell(shell)
+
+	output, err := e.Execute("echo hi")
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if want := shell + " -c echo hi\n"; output != want {
+		t.Errorf("output = %q, want %q", output, want)
+	}
+}
+
+func TestExecuteEmptyCommand(t *testing.T) {
+	e := NewExecutor(t.TempDir())
+
+	if _, err := e.Execute("  "); err == nil {
+		t.Error("Execute of an empty command returned no error")
+	}
+}
+
+func TestExecuteTimeout(t *testing.T) {
+	e := NewExecutor(t.TempDir())
+	e.SetTimeout(100 * time.Millisecond)
+
+	start := time.Now()
+	_, err := e.Execute("sleep 10")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Execute returned after %v, want it to kill the command at the timeout", elapsed)
+	}
+}
+
+func TestExecuteWithoutTimeout(t *testing.T) {
+	e := NewExecutor(t.TempDir())
+	e.SetTimeout(0)
+
+	if _, err := e.Execute("sleep 0.2"); err != nil {
+		t.Errorf("Execute without a timeout returned error: %v", err)
+	}
+}
+
+// logLines returns a logger and a function returning the "line" attribute
+// of every "Command output" record of a stream logged so far
+func logLines(t *testing.T) (*slog.Logger, func(stream string) []string) {
+	t.Helper()
+	var buf tailBuffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	return logger, func(stream string) []string {
+		var lines []string
+		for _, record := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var entry struct {
+				Msg    string `json:"msg"`
+				Stream string `json:"stream"`
+				Line   string `json:"line"`
+			}
+			if err := json.Unmarshal([]byte(record), &entry); err != nil {
+				t.Fatalf("invalid log record %q: %v", record, err)
+			}
+			if entry.Msg == "Command output" && entry.Stream == stream {
+				lines = append(lines, entry.Line)
+			}
+		}
+		return lines
+	}
+}
+
+func TestExecuteLogsLines(t *testing.T) {
+	logger, lines := logLines(t)
+	e := NewExecutor(t.TempDir())
+	e.SetLogger(logger)
+
+	if _, err := e.Execute(`printf 'one\ntwo\r\n'; printf 'oops\n' >&2; printf 'no newline'`); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := lines("stdout"), []string{"one", "two", "no newline"}; !slices.Equal(got, want) {
+		t.Errorf("stdout lines = %q, want %q", got, want)
+	}
+	if got, want := lines("stderr"), []string{"oops"}; !slices.Equal(got, want) {
+		t.Errorf("stderr lines = %q, want %q", got, want)
+	}
+}
+
+func TestLineLoggerSplitsLongLines(t *testing.T) {
+	logger, lines := logLines(t)
+	w := &lineLogger{logger: logger, stream: "stdout"}
+
+	// A line arriving in pieces is logged once complete
+	w.Write([]byte("hel"))
+	w.Write([]byte("lo\nwor"))
+	if got := lines("stdout"); !slices.Equal(got, []string{"hello"}) {
+		t.Errorf("lines = %q, want only the complete line", got)
+	}
+
+	// Output without newlines is not buffered forever
+	w.Write([]byte(strings.Repeat("x", maxLogLine)))
+	if got := lines("stdout"); len(got) != 2 || len(got[1]) != maxLogLine+len("wor") {
+		t.Errorf("%d lines logged, want the long line flushed", len(got))
+	}
+	w.Flush()
+	if got := lines("stdout"); len(got) != 2 {
+		t.Errorf("Flush logged %d more lines, want none", len(got)-2)
+	}
+}
+
+func TestExecuteMaxOutput(t *testing.T) {
+	e := NewExecutor(t.TempDir())
+	e.SetMaxOutput(100)
+
+	output, err := e.Execute("i=0; while [ $i -lt 200 ]; do echo line$i; i=$((i+1)); done")
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if !strings.HasPrefix(output, "... truncated ") {
+		t.Errorf("output = %q, want a truncation marker first", output)
+	}
+	if !strings.HasSuffix(output, "line199\n") {
+		t.Errorf("output = %q, want the last line kept", output)
+	}
+	_, tail, _ := strings.Cut(output, "...\n")
+	if len(tail) > 100 {
+		t.Errorf("kept %d bytes of output, want at most 100", len(tail))
+	}
+	if !strings.HasPrefix(tail, "line") {
+		t.Errorf("kept output %q does not start at a full line", tail)
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		max    int
+		writes []string
+		want   string
+	}{
+		{"unlimited", 0, []string{"a\n", "b\n"}, "a\nb\n"},
+		{"under limit", 10, []string{"a\n", "b\n"}, "a\nb\n"},
+		{"cut at line start", 4, []string{"one\n", "two\n"}, "... truncated 4 bytes ...\ntwo\n"},
+		{"cut in a line", 5, []string{"one\n", "two\n"}, "... truncated 4 bytes ...\ntwo\n"},
+		{"compacted", 2, []string{"aaaaaaaa\n", "b\n"}, "... truncated 9 bytes ...\nb\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &tailBuffer{max: tt.max}
+			for _, w := range tt.writes {
+				b.Write([]byte(w))
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeRunner returns a Runner writing canned output and returning err
+// without starting a process, and records the commands it was given
+func fakeRunner(stdout, stderr string, err error, cmds *[]*exec.Cmd) Runner {
+	return func(ctx context.Context, cmd *exec.Cmd) error {
+		*cmds = append(*cmds, cmd)
+		io.WriteString(cmd.Stdout, stdout)
+		io.WriteString(cmd.Stderr, stderr)
+		return err
+	}
+}
+
+func TestRunnerSuccess(t *testing.T) {
+	var cmds []*exec.Cmd
+	e := NewExecutor("/srv/app")
+	e.SetShell("bash")
+	e.SetRunner(fakeRunner("built\n", "", nil, &cmds))
+
+	output, err := e.Execute("make build")
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if output != "built\n" {
+		t.Errorf("output = %q, want %q", output, "built\n")
+	}
+	if len(cmds) != 1 {
+		t.Fatalf("runner called %d times, want once", len(cmds))
+	}
+	if want := []string{"bash", "-c", "make build"}; !slices.Equal(cmds[0].Args, want) {
+		t.Errorf("args = %q, want %q", cmds[0].Args, want)
+	}
+	if cmds[0].Dir != "/srv/app" {
+		t.Errorf("dir = %q, want /srv/app", cmds[0].Dir)
+	}
+}
+
+func TestRunnerFailure(t *testing.T) {
+	var cmds []*exec.Cmd
+	runErr := errors.New("exit status 2")
+	e := NewExecutor(t.TempDir())
+	e.SetRunner(fakeRunner("partial\n", "boom\n", runErr, &cmds))
+
+	_, err := e.Execute("make deploy")
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("error = %v, want a *CommandError", err)
+	}
+	if cmdErr.Command != "make deploy" || cmdErr.Stdout != "partial\n" || cmdErr.Stderr != "boom\n" {
+		t.Errorf("CommandError = %+v, want the command and its output", cmdErr)
+	}
+	if !errors.Is(err, runErr) {
+		t.Errorf("error = %v, want it to wrap the runner error", err)
+	}
+}
+
+func TestRunnerTimeout(t *testing.T) {
+	e := NewExecutor(t.TempDir())
+	e.SetTimeout(50 * time.Millisecond)
+	e.SetRunner(func(ctx context.Context, cmd *exec.Cmd) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	_, err := e.Execute("sleep 60")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 50ms") {
+		t.Errorf("error = %v, want a timeout", err)
+	}
+}
+
+func TestRunnerCancelled(t *testing.T) {
+	e := NewExecutor(t.TempDir())
+	e.SetRunner(func(ctx context.Context, cmd *exec.Cmd) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := e.ExecuteContext(ctx, "sleep 60")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want it to wrap context.Canceled", err)
+	}
+}
+
+func TestRunnerEnv(t *testing.T) {
+	var cmds []*exec.Cmd
+	e := NewExecutor(t.TempDir())
+	e.SetEnv([]string{"DEPLOY_BRANCH=main", "DEPLOY_SHA=abc123"})
+	e.SetRunner(fakeRunner("", "", nil, &cmds))
+
+	if _, err := e.Execute("true"); err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	env := cmds[0].Env
+	for _, want := range []string{"DEPLOY_BRANCH=main", "DEPLOY_SHA=abc123"} {
+		if !slices.Contains(env, want) {
+			t.Errorf("environment does not contain %s", want)
+		}
+	}
+	// The process environment is kept, e.g. for PATH
+	if path, ok := os.LookupEnv("PATH"); ok && !slices.Contains(env, "PATH="+path) {
+		t.Error("environment does not contain PATH")
+	}
+}
+
+func TestSetRunnerNilRestoresDefault(t *testing.T) {
+	e := NewExecutor(t.TempDir())
+	e.SetRunner(nil)
+
+	output, err := e.Execute("echo real")
+	if err != nil || output != "real\n" {
+		t.Errorf("Execute = %q, %v, want the output of a real process", output, err)
+	}
+}
@@ -0,0 +1,46 @@
+//go:build !windows
+
+package executor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes cmd start a process group of its own, so the
+// processes it spawns can be stopped with it
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// signalProcessGroup sends a signal to cmd's process and every process left
+// in its group, such as background servers or docker builds started by the
+// shell
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	err := syscall.Kill(-cmd.Process.Pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// terminateProcessGroup asks cmd's process group to exit with SIGTERM
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return signalProcessGroup(cmd, syscall.SIGTERM)
+}
+
+// killProcessGroup kills cmd's process group with SIGKILL
+func killProcessGroup(cmd *exec.Cmd) error {
+	return signalProcessGroup(cmd, syscall.SIGKILL)
+}
+
+// processGroupAlive reports whether a process of cmd's group is still
+// running (or not yet reaped)
+func processGroupAlive(cmd *exec.Cmd) bool {
+	return signalProcessGroup(cmd, 0) == nil
+}
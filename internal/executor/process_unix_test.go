@@ -0,0 +1,130 @@
+//go:build !windows
+
+package executor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// processAlive reports whether a process is running; zombies left for an
+// init process that does not reap them count as dead
+func processAlive(pid int) bool {
+	if err := syscall.Kill(pid, 0); errors.Is(err, syscall.ESRCH) {
+		return false
+	}
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return !os.IsNotExist(err)
+	}
+	// The state follows the parenthesized command name
+	_, rest, _ := strings.Cut(string(stat), ") ")
+	return !strings.HasPrefix(rest, "Z")
+}
+
+func TestExecuteTimeoutKillsChildren(t *testing.T) {
+	dir := t.TempDir()
+	e := NewExecutor(dir)
+	e.SetTimeout(200 * time.Millisecond)
+
+	// The shell starts sleep as a child and waits for it
+	pidFile := filepath.Join(dir, "child.pid")
+	_, err := e.Execute("sleep 30 & echo $! > " + pidFile + "; wait")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("error = %v, want a timeout", err)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("child process %d of the shell survived the timeout", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSetProcessGroupKeepsCredential(t *testing.T) {
+	cmd := exec.Command("true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: 1, Gid: 1}}
+
+	setProcessGroup(cmd)
+	if !cmd.SysProcAttr.Setpgid {
+		t.Error("Setpgid not set")
+	}
+	if cmd.SysProcAttr.Credential == nil || cmd.SysProcAttr.Credential.Uid != 1 {
+		t.Error("setProcessGroup dropped the credential set by runAs")
+	}
+}
+
+func TestExecuteTimeoutTerminatesFirst(t *testing.T) {
+	dir := t.TempDir()
+	e := NewExecutor(dir)
+	e.SetTimeout(200 * time.Millisecond)
+	e.SetKillGracePeriod(5 * time.Second)
+
+	// The shell cleans up on SIGTERM and exits well within the grace period
+	marker := filepath.Join(dir, "terminated")
+	start := time.Now()
+	_, err := e.Execute("trap 'touch " + marker + "; exit 1' TERM; sleep 30 & wait")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("error = %v, want a timeout", err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("command did not receive SIGTERM: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("Execute returned after %v, want it to return once the command exited", elapsed)
+	}
+}
+
+func TestExecuteTimeoutKillsAfterGracePeriod(t *testing.T) {
+	dir := t.TempDir()
+	e := NewExecutor(dir)
+	e.SetTimeout(100 * time.Millisecond)
+	e.SetKillGracePeriod(300 * time.Millisecond)
+
+	// Ignored signals are inherited, so neither the shell nor sleep exit on
+	// SIGTERM
+	pidFile := filepath.Join(dir, "child.pid")
+	start := time.Now()
+	_, err := e.Execute("trap '' TERM; sleep 30 & echo $! > " + pidFile + "; wait")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Execute returned after %v, before the grace period ended", elapsed)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("child process %d ignoring SIGTERM was not killed", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
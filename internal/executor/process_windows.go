@@ -0,0 +1,26 @@
+//go:build windows
+
+package executor
+
+import "os/exec"
+
+// setProcessGroup does nothing on Windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup kills cmd's process on Windows, which has no
+// SIGTERM; processes it spawned keep running
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// killProcessGroup kills only cmd's process on Windows; processes it
+// spawned keep running
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// processGroupAlive always reports false on Windows, where the process is
+// killed right away by terminateProcessGroup
+func processGroupAlive(cmd *exec.Cmd) bool {
+	return false
+}
@@ -0,0 +1,34 @@
+package executor
+
+import "strings"
+
+// Quote returns s quoted as a single POSIX shell word, so it can be
+// interpolated into a command without being interpreted by the shell.
+//
+// Commands, pre-commands and rollback commands come from the configuration
+// and are trusted. Everything taken from a webhook (branch, tag and
+// repository names, commit SHAs) is controlled by whoever can push to the
+// repository and must never be concatenated into a command unquoted; the
+// deployer passes it to commands as environment variables such as
+// DEPLOY_BRANCH instead.
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if strings.IndexFunc(s, needsQuoting) < 0 {
+		return s
+	}
+	// Inside single quotes nothing is special except the closing quote,
+	// which is written as '\''
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// needsQuoting reports whether a character is not safe unquoted in a shell
+// word
+func needsQuoting(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("-_./:@%+=,", r)
+}
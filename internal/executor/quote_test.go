@@ -0,0 +1,81 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// maliciousNames are branch names trying to inject shell commands
+var maliciousNames = []string{
+	"main; touch pwned",
+	"main && touch pwned",
+	"main | touch pwned",
+	"$(touch pwned)",
+	"`touch pwned`",
+	"main'; touch pwned; echo '",
+	"main\"; touch pwned; echo \"",
+	"main\ntouch pwned",
+	"--upload-pack=touch pwned",
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", "''"},
+		{"main", "main"},
+		{"feature/login-v1.2", "feature/login-v1.2"},
+		{"main; rm -rf /", "'main; rm -rf /'"},
+		{"it's", `'it'\''s'`},
+		{"$HOME", "'$HOME'"},
+	}
+
+	for _, tt := range tests {
+		if got := Quote(tt.in); got != tt.want {
+			t.Errorf("Quote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteMaliciousNames(t *testing.T) {
+	for _, name := range maliciousNames {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			e := NewExecutor(dir)
+
+			output, err := e.Execute("printf %s " + Quote(name))
+			if err != nil {
+				t.Fatalf("Execute returned error: %v", err)
+			}
+			if output != name {
+				t.Errorf("output = %q, want the name unchanged", output)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+				t.Error("quoted name ran a command")
+			}
+		})
+	}
+}
+
+func TestEnvIsNotInterpreted(t *testing.T) {
+	for _, name := range maliciousNames {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			e := NewExecutor(dir)
+			e.SetEnv([]string{"DEPLOY_BRANCH=" + name})
+
+			output, err := e.Execute(`printf %s "$DEPLOY_BRANCH"`)
+			if err != nil {
+				t.Fatalf("Execute returned error: %v", err)
+			}
+			if output != name {
+				t.Errorf("output = %q, want the name unchanged", output)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+				t.Error("environment variable ran a command")
+			}
+		})
+	}
+}
@@ -1,29 +1,237 @@
 package git
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// Pull strategies for reconciling local and remote history
+const (
+	PullMerge  = "merge"   // Create a merge commit if needed
+	PullRebase = "rebase"  // Rebase local commits onto the remote branch
+	PullFFOnly = "ff-only" // Only fast-forward, fail if the branch diverged
+)
+
+// ErrDiverged is returned by Pull with the ff-only strategy and by
+// FastForward when the local branch cannot be fast-forwarded to the remote
+// branch
+var ErrDiverged = errors.New("local and remote branches have diverged")
+
+// conflictMarkers are phrases git prints when an update stops on conflicts
+// that need manual resolution
+var conflictMarkers = []string{
+	"CONFLICT",
+	"Automatic merge failed",
+	"needs merge",
+	"could not apply",
+	"Resolve all conflicts manually",
+}
+
+// transientMarkers are phrases git prints when a command failed because of
+// a network problem that may go away on its own
+var transientMarkers = []string{
+	"Could not resolve host",
+	"Connection timed out",
+	"Connection refused",
+	"Connection reset",
+	"Operation timed out",
+	"Failed to connect",
+	"The remote end hung up unexpectedly",
+	"early EOF",
+	"RPC failed",
+	"TLS connection was non-properly terminated",
+	"Temporary failure in name resolution",
+}
+
+// GitError is returned when a git command fails and carries its output
+type GitError struct {
+	Op     string // git subcommand, e.g. "pull"
+	Output string
+	Err    error
+}
+
+// Error implements the error interface
+func (e *GitError) Error() string {
+	return fmt.Sprintf("git %s failed: %v\nOutput: %s", e.Op, e.Err, e.Output)
+}
+
+// Unwrap returns the underlying error
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+// IsConflictError reports whether err means the repository could not be
+// updated without manual resolution: a merge or rebase stopped on
+// conflicts, or an ff-only pull found the branches diverged
+func IsConflictError(err error) bool {
+	if errors.Is(err, ErrDiverged) {
+		return true
+	}
+
+	var gitErr *GitError
+	if !errors.As(err, &gitErr) {
+		return false
+	}
+
+	for _, marker := range conflictMarkers {
+		if strings.Contains(gitErr.Output, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isTransientError reports whether err is a git failure caused by a network
+// problem, which is worth retrying, as opposed to e.g. a merge conflict
+func isTransientError(err error) bool {
+	var gitErr *GitError
+	if !errors.As(err, &gitErr) || IsConflictError(err) {
+		return false
+	}
+
+	for _, marker := range transientMarkers {
+		if strings.Contains(gitErr.Output, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DefaultBinary is the git executable used unless another one is set
+const DefaultBinary = "git"
+
 // Manager handles git operations
 type Manager struct {
-	repoPath string
+	repoPath     string
+	pullStrategy string
+	binary       string
+	fetchArgs    []string
+	maxRetries   int
+	retryBackoff time.Duration
+	logger       *slog.Logger
+	ctx          context.Context
 }
 
 // NewManager creates a new git manager for a repository
 func NewManager(repoPath string) *Manager {
 	return &Manager{
 		repoPath: repoPath,
+		binary:   DefaultBinary,
+		logger:   slog.Default(),
+		ctx:      context.Background(),
 	}
 }
 
+// SetContext sets the context git commands run under. Once it is done,
+// running commands are killed and retries stop.
+func (m *Manager) SetContext(ctx context.Context) {
+	m.ctx = ctx
+}
+
+// SetLogger sets the logger git commands are traced to at debug level
+func (m *Manager) SetLogger(logger *slog.Logger) {
+	m.logger = logger
+}
+
+// SetBinary sets the git executable to run (e.g. an absolute path when git
+// is not on the PATH)
+func (m *Manager) SetBinary(binary string) {
+	if binary == "" {
+		binary = DefaultBinary
+	}
+	m.binary = binary
+}
+
+// SetFetchArgs sets extra arguments passed to every git fetch (e.g. --depth=1)
+func (m *Manager) SetFetchArgs(args []string) {
+	m.fetchArgs = args
+}
+
+// SetRetry makes Pull retry up to maxRetries times on network failures,
+// waiting backoff before the first retry and doubling it after each one
+func (m *Manager) SetRetry(maxRetries int, backoff time.Duration) {
+	m.maxRetries = maxRetries
+	m.retryBackoff = backoff
+}
+
+// retry runs fn, retrying it on network failures as configured by SetRetry
+func (m *Manager) retry(op string, fn func() error) error {
+	backoff := m.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > m.maxRetries || !isTransientError(err) {
+			return err
+		}
+
+		m.logger.Warn("Git network failure, retrying", "op", op, "repo", m.repoPath, "attempt", attempt, "retries", m.maxRetries, "backoff", backoff, "error", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-m.ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// waitDelay is how long a killed git command may take to release its
+// output, e.g. while an ssh child process still holds it open
+const waitDelay = 5 * time.Second
+
+// newCommand returns a git command killed once the manager's context is done
+func (m *Manager) newCommand(args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(m.ctx, m.binary, args...)
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
+
+// command returns a git command running in the repository
+func (m *Manager) command(args ...string) *exec.Cmd {
+	m.logger.Debug("Running git", "args", args, "repo", m.repoPath)
+	cmd := m.newCommand(args...)
+	cmd.Dir = m.repoPath
+	return cmd
+}
+
+// fetchCommand returns a git fetch command with the extra fetch arguments
+// inserted before the remote
+func (m *Manager) fetchCommand(args ...string) *exec.Cmd {
+	fetchArgs := append([]string{"fetch"}, m.fetchArgs...)
+	return m.command(append(fetchArgs, args...)...)
+}
+
+// SetPullStrategy sets how Pull reconciles history (empty uses git's
+// configured default)
+func (m *Manager) SetPullStrategy(strategy string) {
+	m.pullStrategy = strategy
+}
+
+// Version returns the version reported by git, e.g. "git version 2.43.0"
+func (m *Manager) Version() (string, error) {
+	output, err := m.newCommand("--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s: %w", m.binary, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
 // GetCurrentBranch returns the currently checked out branch
 func (m *Manager) GetCurrentBranch() (string, error) {
-	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
-	cmd.Dir = m.repoPath
+	cmd := m.command("rev-parse", "--abbrev-ref", "HEAD")
 
 	output, err := cmd.Output()
 	if err != nil {
@@ -34,10 +242,173 @@ func (m *Manager) GetCurrentBranch() (string, error) {
 	return branch, nil
 }
 
+// GetHeadSHA returns the SHA of the currently checked out commit
+func (m *Manager) GetHeadSHA() (string, error) {
+	cmd := m.command("rev-parse", "HEAD")
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get HEAD commit: %w", err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// CommitInfo describes a commit
+type CommitInfo struct {
+	SHA       string    `json:"sha"`
+	Author    string    `json:"author"`
+	Subject   string    `json:"subject"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// String returns a one-line summary of the commit
+func (c *CommitInfo) String() string {
+	sha := c.SHA
+	if len(sha) > 7 {
+		sha = sha[:7]
+	}
+	return fmt.Sprintf("%s %s (%s, %s)", sha, c.Subject, c.Author, c.Timestamp.Format(time.RFC3339))
+}
+
+// GetHeadCommit returns the SHA, author, subject and commit time of the
+// currently checked out commit
+func (m *Manager) GetHeadCommit() (*CommitInfo, error) {
+	// Fields are separated by NUL bytes, which cannot appear in them
+	cmd := m.command("log", "-1", "--format=%H%x00%an <%ae>%x00%ct%x00%s")
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get HEAD commit: %w", err)
+	}
+
+	fields := strings.SplitN(strings.TrimRight(string(output), "\n"), "\x00", 4)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("unexpected git log output: %q", string(output))
+	}
+
+	unix, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commit time %q: %w", fields[2], err)
+	}
+
+	return &CommitInfo{
+		SHA:       fields[0],
+		Author:    fields[1],
+		Timestamp: time.Unix(unix, 0).UTC(),
+		Subject:   fields[3],
+	}, nil
+}
+
+// IsDirty reports whether the working tree has uncommitted changes to
+// tracked files
+func (m *Manager) IsDirty() (bool, error) {
+	cmd := m.command("status", "--porcelain", "--untracked-files=no")
+
+	output, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to get working tree status: %w", err)
+	}
+
+	return strings.TrimSpace(string(output)) != "", nil
+}
+
+// StashChanges stashes local modifications to tracked files. Untracked
+// files (e.g. generated configuration) are left in place.
+func (m *Manager) StashChanges() error {
+	cmd := m.command("stash", "push", "--message", "github-auto-deployer: stashed before deploy")
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return &GitError{Op: "stash", Output: string(output), Err: err}
+	}
+
+	return nil
+}
+
+// HardReset discards local modifications to tracked files by resetting to
+// the last fetched state of the branch on origin, or to HEAD if branch is
+// empty. Untracked files are kept.
+func (m *Manager) HardReset(branch string) error {
+	target := "HEAD"
+	if branch != "" {
+		target = "refs/remotes/origin/" + branch
+	}
+
+	cmd := m.command("reset", "--hard", target)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return &GitError{Op: "reset", Output: string(output), Err: err}
+	}
+
+	return nil
+}
+
+// ResetToCommit resets the checked out branch (or detached HEAD) and the
+// working tree to a commit, discarding changes to tracked files
+func (m *Manager) ResetToCommit(sha string) error {
+	if !isCommitSHA(sha) {
+		return fmt.Errorf("invalid commit SHA %q", sha)
+	}
+
+	cmd := m.command("reset", "--hard", sha)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return &GitError{Op: "reset", Output: string(output), Err: err}
+	}
+
+	return nil
+}
+
+// VerifyCommitSignature checks with git verify-commit that a commit carries
+// a valid GPG or SSH signature. Which keys are accepted depends on the
+// keyring and git configuration (gpg.minTrustLevel,
+// gpg.ssh.allowedSignersFile) of the user running git.
+func (m *Manager) VerifyCommitSignature(sha string) error {
+	if !isCommitSHA(sha) {
+		return fmt.Errorf("invalid commit SHA %q", sha)
+	}
+
+	cmd := m.command("verify-commit", sha)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		// git prints nothing for commits without a signature
+		if len(output) == 0 {
+			output = []byte("commit is not signed")
+		}
+		return &GitError{Op: "verify-commit", Output: string(output), Err: err}
+	}
+
+	return nil
+}
+
+// ListBranches returns the names of the local branches and the branches on
+// origin, without duplicates
+func (m *Manager) ListBranches() ([]string, error) {
+	cmd := m.command("for-each-ref", "--format=%(refname)", "refs/heads", "refs/remotes/origin")
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var branches []string
+	for _, ref := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		name := strings.TrimPrefix(ref, "refs/heads/")
+		name = strings.TrimPrefix(name, "refs/remotes/origin/")
+		if name == "" || name == "HEAD" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		branches = append(branches, name)
+	}
+
+	return branches, nil
+}
+
 // GetRemoteURL returns the remote URL of the repository
 func (m *Manager) GetRemoteURL() (string, error) {
-	cmd := exec.Command("git", "config", "--get", "remote.origin.url")
-	cmd.Dir = m.repoPath
+	cmd := m.command("config", "--get", "remote.origin.url")
 
 	output, err := cmd.Output()
 	if err != nil {
@@ -45,25 +416,341 @@ func (m *Manager) GetRemoteURL() (string, error) {
 	}
 
 	url := strings.TrimSpace(string(output))
-	return normalizeGitURL(url), nil
+	return "https://" + normalizeGitURL(url), nil
 }
 
 // Pull performs a git pull operation
 func (m *Manager) Pull() error {
+	return m.pull("", nil)
+}
+
+// PullWithToken performs a git pull authenticated with a GitHub access token.
+// The token is passed to git through environment variables as an HTTP
+// authorization header, so it never appears in the command line, the remote
+// URL or the returned errors. Only HTTPS remotes on github.com receive it.
+func (m *Manager) PullWithToken(token string) error {
+	return m.pull("", tokenEnv(token))
+}
+
+// FetchAndPull fetches origin and pulls the given branch into the checked
+// out branch, so it works without upstream tracking configured. An empty
+// branch pulls the upstream branch like Pull.
+func (m *Manager) FetchAndPull(branch string) error {
+	return m.pull(branch, nil)
+}
+
+// FetchAndPullWithToken is FetchAndPull authenticated with a GitHub access
+// token, see PullWithToken
+func (m *Manager) FetchAndPullWithToken(branch, token string) error {
+	return m.pull(branch, tokenEnv(token))
+}
+
+// pull fetches and pulls a branch (or the upstream branch if empty) from
+// origin with extra environment variables
+func (m *Manager) pull(branch string, env []string) error {
+	return m.retry("pull", func() error {
+		return m.fetchAndPull(branch, env)
+	})
+}
+
+// fetchAndPull fetches from origin and pulls a branch once
+func (m *Manager) fetchAndPull(branch string, env []string) error {
 	// First, fetch to get latest changes
-	fetchCmd := exec.Command("git", "fetch", "origin")
-	fetchCmd.Dir = m.repoPath
+	fetchCmd := m.fetchCommand("origin")
+	fetchCmd.Env = append(os.Environ(), env...)
 
 	if output, err := fetchCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("git fetch failed: %w\nOutput: %s", err, string(output))
+		return &GitError{Op: "fetch", Output: string(output), Err: err}
 	}
 
 	// Then pull
-	pullCmd := exec.Command("git", "pull", "origin")
-	pullCmd.Dir = m.repoPath
+	args := []string{"pull"}
+	switch m.pullStrategy {
+	case "":
+	case PullMerge:
+		args = append(args, "--no-rebase")
+	case PullRebase:
+		args = append(args, "--rebase")
+	case PullFFOnly:
+		args = append(args, "--ff-only")
+	default:
+		return fmt.Errorf("unknown pull strategy: %s", m.pullStrategy)
+	}
+	args = append(args, "origin")
+	if branch != "" {
+		// The full ref keeps a branch name from being parsed as an option
+		args = append(args, "refs/heads/"+branch)
+	}
+
+	pullCmd := m.command(args...)
+	pullCmd.Env = append(os.Environ(), env...)
 
 	if output, err := pullCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("git pull failed: %w\nOutput: %s", err, string(output))
+		if m.pullStrategy == PullFFOnly && strings.Contains(strings.ToLower(string(output)), "not possible to fast-forward") {
+			return &GitError{Op: "pull", Output: string(output), Err: ErrDiverged}
+		}
+		return &GitError{Op: "pull", Output: string(output), Err: err}
+	}
+
+	return nil
+}
+
+// CheckoutTag fetches tags from origin and checks out a tag (detached HEAD)
+func (m *Manager) CheckoutTag(tag string) error {
+	return m.checkoutTag(tag, nil)
+}
+
+// CheckoutTagWithToken is CheckoutTag authenticated with a GitHub access
+// token, see PullWithToken
+func (m *Manager) CheckoutTagWithToken(tag, token string) error {
+	return m.checkoutTag(tag, tokenEnv(token))
+}
+
+// checkoutTag fetches tags and checks out a tag with extra environment variables
+func (m *Manager) checkoutTag(tag string, env []string) error {
+	if tag == "" {
+		return fmt.Errorf("empty tag name")
+	}
+
+	fetchCmd := m.fetchCommand("--force", "--tags", "origin")
+	fetchCmd.Env = append(os.Environ(), env...)
+
+	if output, err := fetchCmd.CombinedOutput(); err != nil {
+		return &GitError{Op: "fetch", Output: string(output), Err: err}
+	}
+
+	// The full ref keeps a tag name from being parsed as an option
+	checkoutCmd := m.command("checkout", "--detach", "refs/tags/"+tag)
+
+	if output, err := checkoutCmd.CombinedOutput(); err != nil {
+		return &GitError{Op: "checkout", Output: string(output), Err: err}
+	}
+
+	return nil
+}
+
+// CheckoutSHA fetches origin and checks out a commit (detached HEAD), e.g.
+// the exact commit of a push even if the branch has moved on since
+func (m *Manager) CheckoutSHA(sha string) error {
+	return m.checkoutSHA(sha, nil)
+}
+
+// CheckoutSHAWithToken is CheckoutSHA authenticated with a GitHub access
+// token, see PullWithToken
+func (m *Manager) CheckoutSHAWithToken(sha, token string) error {
+	return m.checkoutSHA(sha, tokenEnv(token))
+}
+
+// checkoutSHA fetches origin and checks out a commit with extra environment
+// variables
+func (m *Manager) checkoutSHA(sha string, env []string) error {
+	// Only hex digits, so the SHA cannot be parsed as an option
+	if !isCommitSHA(sha) {
+		return fmt.Errorf("invalid commit SHA %q", sha)
+	}
+
+	fetchCmd := m.fetchCommand("origin")
+	fetchCmd.Env = append(os.Environ(), env...)
+
+	if output, err := fetchCmd.CombinedOutput(); err != nil {
+		return &GitError{Op: "fetch", Output: string(output), Err: err}
+	}
+
+	checkoutCmd := m.command("checkout", "--detach", sha+"^{commit}")
+
+	if output, err := checkoutCmd.CombinedOutput(); err != nil {
+		return &GitError{Op: "checkout", Output: string(output), Err: err}
+	}
+
+	return nil
+}
+
+// FetchCommit fetches a branch or tag from origin, given as a full ref such
+// as refs/heads/main or refs/tags/v1.0, and returns the SHA of the commit it
+// points to. Neither HEAD nor the working tree change, so the commit can be
+// verified before it is checked out.
+func (m *Manager) FetchCommit(ref string) (string, error) {
+	return m.fetchCommit(ref, nil)
+}
+
+// FetchCommitWithToken is FetchCommit authenticated with a GitHub access
+// token, see PullWithToken
+func (m *Manager) FetchCommitWithToken(ref, token string) (string, error) {
+	return m.fetchCommit(ref, tokenEnv(token))
+}
+
+// fetchCommit fetches a ref from origin with extra environment variables
+// and resolves the fetched commit
+func (m *Manager) fetchCommit(ref string, env []string) (string, error) {
+	// A full ref cannot be parsed as an option
+	if !strings.HasPrefix(ref, "refs/") {
+		return "", fmt.Errorf("invalid ref %q", ref)
+	}
+
+	err := m.retry("fetch", func() error {
+		fetchCmd := m.fetchCommand("origin", ref)
+		fetchCmd.Env = append(os.Environ(), env...)
+
+		if output, err := fetchCmd.CombinedOutput(); err != nil {
+			return &GitError{Op: "fetch", Output: string(output), Err: err}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Tags may point to an annotated tag object; peel it to the commit
+	output, err := m.command("rev-parse", "--verify", "FETCH_HEAD^{commit}").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve fetched %s: %w", ref, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// FastForward moves the checked out branch forward to a commit, e.g. one
+// fetched with FetchCommit. It fails with ErrDiverged if the branch has
+// commits the commit does not contain.
+func (m *Manager) FastForward(sha string) error {
+	if !isCommitSHA(sha) {
+		return fmt.Errorf("invalid commit SHA %q", sha)
+	}
+
+	cmd := m.command("merge", "--ff-only", sha)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if strings.Contains(strings.ToLower(string(output)), "not possible to fast-forward") {
+			return &GitError{Op: "merge", Output: string(output), Err: ErrDiverged}
+		}
+		return &GitError{Op: "merge", Output: string(output), Err: err}
+	}
+
+	return nil
+}
+
+// isCommitSHA reports whether s looks like a full or abbreviated commit SHA.
+// The all-zero SHA, which providers send for deleted refs, is rejected.
+func isCommitSHA(s string) bool {
+	if len(s) < 4 || len(s) > 64 || strings.Trim(s, "0") == "" {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckoutBranch switches to a branch, creating it from origin if it only
+// exists there. It does nothing if the branch is already checked out.
+func (m *Manager) CheckoutBranch(branch string) error {
+	return m.checkoutBranch(branch, nil)
+}
+
+// CheckoutBranchWithToken is CheckoutBranch authenticated with a GitHub
+// access token, see PullWithToken
+func (m *Manager) CheckoutBranchWithToken(branch, token string) error {
+	return m.checkoutBranch(branch, tokenEnv(token))
+}
+
+// checkoutBranch fetches origin and switches to a branch with extra
+// environment variables
+func (m *Manager) checkoutBranch(branch string, env []string) error {
+	if branch == "" {
+		return fmt.Errorf("empty branch name")
+	}
+	if current, err := m.GetCurrentBranch(); err == nil && current == branch {
+		return nil
+	}
+
+	fetchCmd := m.fetchCommand("origin")
+	fetchCmd.Env = append(os.Environ(), env...)
+
+	if output, err := fetchCmd.CombinedOutput(); err != nil {
+		return &GitError{Op: "fetch", Output: string(output), Err: err}
+	}
+
+	// The trailing -- keeps the branch from being read as a path
+	checkoutCmd := m.command("checkout", branch, "--")
+
+	if output, err := checkoutCmd.CombinedOutput(); err != nil {
+		return &GitError{Op: "checkout", Output: string(output), Err: err}
+	}
+
+	return nil
+}
+
+// UpdateSubmodules initializes and updates all submodules recursively to the
+// commits recorded in the checked out commit
+func (m *Manager) UpdateSubmodules() error {
+	return m.updateSubmodules(nil)
+}
+
+// UpdateSubmodulesWithToken is UpdateSubmodules authenticated with a GitHub
+// access token, see PullWithToken
+func (m *Manager) UpdateSubmodulesWithToken(token string) error {
+	return m.updateSubmodules(tokenEnv(token))
+}
+
+// updateSubmodules updates submodules with extra environment variables
+func (m *Manager) updateSubmodules(env []string) error {
+	cmd := m.command("submodule", "update", "--init", "--recursive")
+	cmd.Env = append(os.Environ(), env...)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return &GitError{Op: "submodule update", Output: string(output), Err: err}
+	}
+
+	return nil
+}
+
+// tokenURL is the URL prefix the GitHub access token is sent to. Remotes
+// and submodules on other hosts never receive it.
+const tokenURL = "https://github.com/"
+
+// tokenEnv returns the environment that makes git send the token as
+// basic auth credentials for the x-access-token user to GitHub
+func tokenEnv(token string) []string {
+	credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http." + tokenURL + ".extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + credentials,
+		// Never fall back to an interactive credential prompt
+		"GIT_TERMINAL_PROMPT=0",
+	}
+}
+
+// Clone clones a repository into the manager's repository path, checking
+// out branch (or the remote's default branch if empty)
+func (m *Manager) Clone(repoURL, branch string) error {
+	return m.clone(repoURL, branch, nil)
+}
+
+// CloneWithToken is Clone authenticated with a GitHub access token, see
+// PullWithToken
+func (m *Manager) CloneWithToken(repoURL, branch, token string) error {
+	return m.clone(repoURL, branch, tokenEnv(token))
+}
+
+// clone runs git clone with extra environment variables
+func (m *Manager) clone(repoURL, branch string, env []string) error {
+	args := []string{"clone"}
+	if branch != "" {
+		args = append(args, "--branch", branch)
+	}
+	// "--" keeps the URL and destination from being parsed as options
+	args = append(args, "--", repoURL, m.repoPath)
+
+	// The repository path does not exist yet, so run outside of it
+	m.logger.Debug("Running git", "args", args)
+	cmd := m.newCommand(args...)
+	cmd.Env = append(os.Environ(), env...)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return &GitError{Op: "clone", Output: string(output), Err: err}
 	}
 
 	return nil
@@ -79,24 +766,75 @@ func IsGitRepository(path string) bool {
 	return info.IsDir()
 }
 
-// normalizeGitURL converts various git URL formats to a consistent format
-// for comparison (removes .git suffix, converts SSH to HTTPS format)
-func normalizeGitURL(url string) string {
-	// Remove .git suffix
-	url = strings.TrimSuffix(url, ".git")
+// normalizeGitURL converts the git URL formats (https://, ssh://, git://
+// and scp-like git@host:owner/repo) to a lowercase host/owner/repo for
+// comparison. User info, ports, trailing slashes and the .git suffix are
+// removed.
+func normalizeGitURL(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+
+	var host, path string
+	if strings.Contains(rawURL, "://") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return strings.ToLower(strings.TrimSuffix(strings.TrimRight(rawURL, "/"), ".git"))
+		}
+		host, path = u.Hostname(), u.Path
+	} else if i := strings.Index(rawURL, ":"); i >= 0 && !strings.Contains(rawURL[:i], "/") {
+		// scp-like [user@]host:path, where some write host:port/path
+		host, path = rawURL[:i], rawURL[i+1:]
+		if at := strings.LastIndex(host, "@"); at >= 0 {
+			host = host[at+1:]
+		}
+		if port, rest, ok := strings.Cut(path, "/"); ok && isPort(port) {
+			path = rest
+		}
+	} else {
+		// Scheme-less host/owner/repo
+		host, path, _ = strings.Cut(rawURL, "/")
+	}
 
-	// Convert SSH format (git@github.com:user/repo) to HTTPS-like format
-	if strings.HasPrefix(url, "git@") {
-		url = strings.Replace(url, ":", "/", 1)
-		url = strings.Replace(url, "git@", "https://", 1)
+	path = strings.Trim(path, "/")
+	path = strings.TrimSuffix(path, ".git")
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return strings.ToLower(host)
 	}
+	return strings.ToLower(host + "/" + path)
+}
+
+// isPort reports whether s is a TCP port number
+func isPort(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// ParseRepoURL returns the owner and name of the repository a git URL
+// (HTTPS or SSH) points to, e.g. "octocat" and "hello-world" for
+// git@github.com:octocat/Hello-World.git. Both are lowercase.
+func ParseRepoURL(url string) (owner, name string, err error) {
+	normalized := normalizeGitURL(url)
 
-	// Ensure https:// prefix
-	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
-		url = "https://" + url
+	// host/owner/name, where the owner may contain nested groups
+	parts := strings.Split(strings.Trim(normalized, "/"), "/")
+	if len(parts) < 3 {
+		return "", "", fmt.Errorf("cannot determine owner and name from repository URL %q", url)
 	}
 
-	return strings.ToLower(url)
+	return strings.Join(parts[1:len(parts)-1], "/"), parts[len(parts)-1], nil
+}
+
+// RepoHost returns the lowercase host a git URL (HTTPS or SSH) points to,
+// without user info or port
+func RepoHost(url string) string {
+	host, _, _ := strings.Cut(normalizeGitURL(url), "/")
+	return host
+}
+
+// IsGitHubURL reports whether a git URL points to github.com, the only host
+// GitHub access tokens are sent to
+func IsGitHubURL(url string) bool {
+	return RepoHost(url) == "github.com"
 }
 
 // CompareURLs checks if two git URLs refer to the same repository
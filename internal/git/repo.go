@@ -0,0 +1,30 @@
+package git
+
+// Repo is the set of repository operations a deployment needs. Manager
+// implements it; tests can substitute a fake that does not touch disk.
+type Repo interface {
+	GetCurrentBranch() (string, error)
+	GetHeadSHA() (string, error)
+	GetHeadCommit() (*CommitInfo, error)
+	IsDirty() (bool, error)
+	StashChanges() error
+	HardReset(branch string) error
+	ResetToCommit(sha string) error
+	VerifyCommitSignature(sha string) error
+	FetchAndPull(branch string) error
+	FetchAndPullWithToken(branch, token string) error
+	CheckoutBranch(branch string) error
+	CheckoutBranchWithToken(branch, token string) error
+	CheckoutTag(tag string) error
+	CheckoutTagWithToken(tag, token string) error
+	CheckoutSHA(sha string) error
+	CheckoutSHAWithToken(sha, token string) error
+	FetchCommit(ref string) (string, error)
+	FetchCommitWithToken(ref, token string) (string, error)
+	FastForward(sha string) error
+	UpdateSubmodules() error
+	UpdateSubmodulesWithToken(token string) error
+}
+
+// Compile-time check that Manager implements Repo
+var _ Repo = (*Manager)(nil)
@@ -0,0 +1,242 @@
+package github
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultBaseURL is the GitHub REST API endpoint
+const DefaultBaseURL = "https://api.github.com"
+
+// AppClient authenticates as a GitHub App and mints installation tokens
+type AppClient struct {
+	appID          int64
+	installationID int64
+	privateKey     *rsa.PrivateKey
+	baseURL        string
+	client         *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewAppClient creates a new GitHub App client from the app's private key file
+func NewAppClient(appID int64, privateKeyPath string, installationID int64) (*AppClient, error) {
+	keyData, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	privateKey, err := parsePrivateKey(keyData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppClient{
+		appID:          appID,
+		installationID: installationID,
+		privateKey:     privateKey,
+		baseURL:        DefaultBaseURL,
+		client:         &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// SetBaseURL overrides the API endpoint (e.g. for GitHub Enterprise)
+func (c *AppClient) SetBaseURL(baseURL string) {
+	c.baseURL = baseURL
+}
+
+// GetInstallationToken returns a short-lived access token for the
+// installation, reusing the previous token until shortly before it expires
+func (c *AppClient) GetInstallationToken() (string, error) {
+	if c.installationID == 0 {
+		return "", fmt.Errorf("no installation ID configured")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Until(c.tokenExpiry) > time.Minute {
+		return c.token, nil
+	}
+
+	var result struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	path := fmt.Sprintf("/app/installations/%d/access_tokens", c.installationID)
+	if err := c.doAppRequest(http.MethodPost, path, nil, &result); err != nil {
+		return "", fmt.Errorf("failed to create installation token: %w", err)
+	}
+
+	c.token = result.Token
+	c.tokenExpiry = result.ExpiresAt
+
+	return result.Token, nil
+}
+
+// Installation describes an installation of the GitHub App on an account
+type Installation struct {
+	ID      int64 `json:"id"`
+	Account struct {
+		Login string `json:"login"`
+	} `json:"account"`
+}
+
+// ListInstallations returns every account the app is installed on
+func (c *AppClient) ListInstallations() ([]Installation, error) {
+	var installations []Installation
+	if err := c.doAppRequest(http.MethodGet, "/app/installations", nil, &installations); err != nil {
+		return nil, fmt.Errorf("failed to list installations: %w", err)
+	}
+
+	return installations, nil
+}
+
+// doAppRequest performs a request authenticated with the app's JWT
+func (c *AppClient) doAppRequest(method, path string, in, out interface{}) error {
+	jwt, err := c.generateJWT()
+	if err != nil {
+		return err
+	}
+
+	return c.doRequest(method, path, "Bearer "+jwt, in, out)
+}
+
+// doInstallationRequest performs a request authenticated with an
+// installation access token
+func (c *AppClient) doInstallationRequest(method, path string, in, out interface{}) error {
+	token, err := c.GetInstallationToken()
+	if err != nil {
+		return err
+	}
+
+	return c.doRequest(method, path, "token "+token, in, out)
+}
+
+// doRequest sends in as the JSON request body (if non-nil) and decodes the
+// JSON response into out (if non-nil)
+func (c *AppClient) doRequest(method, path, authorization string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("GitHub API returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// generateJWT creates a JWT signed with the app's private key, as required
+// by the GitHub App authentication endpoints
+func (c *AppClient) generateJWT() (string, error) {
+	now := time.Now()
+
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	claims := map[string]interface{}{
+		// Backdate to allow for clock drift
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": fmt.Sprintf("%d", c.appID),
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoding := base64.RawURLEncoding
+	signingInput := encoding.EncodeToString(headerJSON) + "." + encoding.EncodeToString(claimsJSON)
+
+	hash := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.privateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT: %w", err)
+	}
+
+	return signingInput + "." + encoding.EncodeToString(signature), nil
+}
+
+// CheckPrivateKey verifies that a private key file can be read and holds
+// an RSA key usable by NewAppClient
+func CheckPrivateKey(privateKeyPath string) error {
+	keyData, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	_, err = parsePrivateKey(keyData)
+	return err
+}
+
+// parsePrivateKey decodes a PEM encoded RSA private key in PKCS#1 or PKCS#8 form
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode private key: no PEM data found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+
+	return rsaKey, nil
+}
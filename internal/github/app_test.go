@@ -0,0 +1,258 @@
+package github
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKey is shared by the tests, generating RSA keys is slow
+var testKey = func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// writeKey writes a PEM block to a file and returns its path
+func writeKey(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestApp returns an app client for app 42 and installation 7 talking to
+// a test server
+func newTestApp(t *testing.T, handler http.HandlerFunc) *AppClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	path := writeKey(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(testKey))
+	c, err := NewAppClient(42, path, 7)
+	if err != nil {
+		t.Fatalf("NewAppClient returned error: %v", err)
+	}
+	c.SetBaseURL(server.URL)
+	return c
+}
+
+// verifyJWT checks the signature of an app JWT and returns its claims
+func verifyJWT(t *testing.T, authorization string) map[string]interface{} {
+	t.Helper()
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		t.Fatalf("Authorization = %q, want a bearer token", authorization)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("JWT has %d parts, want 3", len(parts))
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&testKey.PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+		t.Fatalf("JWT signature does not verify: %v", err)
+	}
+
+	var header map[string]string
+	decodeSegment(t, parts[0], &header)
+	if header["alg"] != "RS256" || header["typ"] != "JWT" {
+		t.Errorf("JWT header = %v", header)
+	}
+	var claims map[string]interface{}
+	decodeSegment(t, parts[1], &claims)
+	return claims
+}
+
+func decodeSegment(t *testing.T, segment string, out interface{}) {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPKCS8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"PKCS#1", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(testKey)}), ""},
+		{"PKCS#8", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), ""},
+		{"not PEM", []byte("not a key"), "no PEM data"},
+		{"garbage", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")}), "failed to parse"},
+		{"EC key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecPKCS8}), "not an RSA key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parsePrivateKey(tt.data)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsePrivateKey returned error: %v", err)
+			}
+			if !key.Equal(testKey) {
+				t.Error("parsed key differs from the written key")
+			}
+		})
+	}
+}
+
+func TestGenerateJWTClaims(t *testing.T) {
+	c := newTestApp(t, nil)
+
+	jwt, err := c.generateJWT()
+	if err != nil {
+		t.Fatalf("generateJWT returned error: %v", err)
+	}
+	claims := verifyJWT(t, "Bearer "+jwt)
+
+	if claims["iss"] != "42" {
+		t.Errorf("iss = %v, want the app ID", claims["iss"])
+	}
+	now := float64(time.Now().Unix())
+	iat, _ := claims["iat"].(float64)
+	exp, _ := claims["exp"].(float64)
+	if iat > now || iat < now-120 {
+		t.Errorf("iat = %v, want shortly before now (%v)", iat, now)
+	}
+	if exp <= now || exp-iat > 10*60 {
+		t.Errorf("exp = %v, want in the future and at most 10 minutes after iat", exp)
+	}
+}
+
+func TestGetInstallationToken(t *testing.T) {
+	c := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/app/installations/7/access_tokens" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		verifyJWT(t, r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"token": "ghs_test", "expires_at": "2099-01-01T00:00:00Z"}`))
+	})
+
+	token, err := c.GetInstallationToken()
+	if err != nil {
+		t.Fatalf("GetInstallationToken returned error: %v", err)
+	}
+	if token != "ghs_test" {
+		t.Errorf("token = %q, want %q", token, "ghs_test")
+	}
+}
+
+func TestGetInstallationTokenErrors(t *testing.T) {
+	c := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message": "Bad credentials"}`, http.StatusUnauthorized)
+	})
+
+	if _, err := c.GetInstallationToken(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("error = %v, want the API status", err)
+	}
+
+	c.installationID = 0
+	if _, err := c.GetInstallationToken(); err == nil || !strings.Contains(err.Error(), "no installation ID") {
+		t.Errorf("error = %v, want a missing installation error", err)
+	}
+}
+
+func TestListInstallations(t *testing.T) {
+	c := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/app/installations" {
+			t.Errorf("request = %s %s", r.Method, r.URL.Path)
+		}
+		verifyJWT(t, r.Header.Get("Authorization"))
+		w.Write([]byte(`[{"id": 7, "account": {"login": "acme"}}, {"id": 8, "account": {"login": "other"}}]`))
+	})
+
+	installations, err := c.ListInstallations()
+	if err != nil {
+		t.Fatalf("ListInstallations returned error: %v", err)
+	}
+	if len(installations) != 2 || installations[0].ID != 7 || installations[0].Account.Login != "acme" {
+		t.Errorf("installations = %+v", installations)
+	}
+}
+
+func TestGetInstallationTokenReusedUntilExpiry(t *testing.T) {
+	tests := []struct {
+		name     string
+		expires  time.Duration
+		requests int
+	}{
+		{"valid", time.Hour, 1},
+		{"about to expire", 30 * time.Second, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			c := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"token":      "ghs_" + strings.Repeat("x", requests),
+					"expires_at": time.Now().Add(tt.expires),
+				})
+			})
+
+			first, err := c.GetInstallationToken()
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := c.GetInstallationToken()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requests != tt.requests {
+				t.Errorf("%d tokens requested, want %d", requests, tt.requests)
+			}
+			if (first == second) != (tt.requests == 1) {
+				t.Errorf("tokens = %q, %q", first, second)
+			}
+		})
+	}
+}
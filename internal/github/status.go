@@ -0,0 +1,56 @@
+package github
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Commit status states accepted by the GitHub Statuses API
+const (
+	StatusPending = "pending"
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// statusContext identifies the deployer's statuses on a commit
+const statusContext = "github-auto-deployer"
+
+// StatusReporter marks commits with the state of their deployment
+type StatusReporter struct {
+	client *AppClient
+}
+
+// NewStatusReporter creates a status reporter using the app's installation token
+func NewStatusReporter(client *AppClient) *StatusReporter {
+	return &StatusReporter{client: client}
+}
+
+// SetStatus sets the deployment state of a commit. fullName is the
+// repository in owner/name form.
+func (r *StatusReporter) SetStatus(fullName, sha, state, description string) error {
+	if !strings.Contains(fullName, "/") {
+		return fmt.Errorf("invalid repository name: %s", fullName)
+	}
+	if sha == "" {
+		return fmt.Errorf("no commit SHA to report status for")
+	}
+
+	// GitHub rejects descriptions longer than 140 characters
+	if len(description) > 140 {
+		description = description[:137] + "..."
+	}
+
+	status := map[string]string{
+		"state":       state,
+		"description": description,
+		"context":     statusContext,
+	}
+
+	path := fmt.Sprintf("/repos/%s/statuses/%s", fullName, sha)
+	if err := r.client.doInstallationRequest(http.MethodPost, path, status, nil); err != nil {
+		return fmt.Errorf("failed to set commit status: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package github
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newTestReporter returns a status reporter whose test server hands out an
+// installation token and records the statuses set
+func newTestReporter(t *testing.T) (*StatusReporter, *[]map[string]string, *[]string) {
+	t.Helper()
+	var statuses []map[string]string
+	var paths []string
+	c := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/access_tokens") {
+			w.Write([]byte(`{"token": "ghs_test", "expires_at": "2099-01-01T00:00:00Z"}`))
+			return
+		}
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		if got := r.Header.Get("Authorization"); got != "token ghs_test" {
+			t.Errorf("Authorization = %q, want the installation token", got)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q", got)
+		}
+		var status map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+			t.Errorf("decoding status: %v", err)
+		}
+		statuses = append(statuses, status)
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	})
+	return NewStatusReporter(c), &statuses, &paths
+}
+
+func TestSetStatus(t *testing.T) {
+	r, statuses, paths := newTestReporter(t)
+
+	if err := r.SetStatus("acme/app", "abc123", StatusPending, "Deployment in progress"); err != nil {
+		t.Fatalf("SetStatus returned error: %v", err)
+	}
+
+	if len(*paths) != 1 || (*paths)[0] != "/repos/acme/app/statuses/abc123" {
+		t.Fatalf("paths = %q", *paths)
+	}
+	want := map[string]string{
+		"state":       "pending",
+		"description": "Deployment in progress",
+		"context":     "github-auto-deployer",
+	}
+	got := (*statuses)[0]
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %q, want %q", key, got[key], value)
+		}
+	}
+}
+
+func TestSetStatusTruncatesDescription(t *testing.T) {
+	r, statuses, _ := newTestReporter(t)
+
+	if err := r.SetStatus("acme/app", "abc123", StatusFailure, strings.Repeat("a", 200)); err != nil {
+		t.Fatalf("SetStatus returned error: %v", err)
+	}
+	description := (*statuses)[0]["description"]
+	if len(description) != 140 || !strings.HasSuffix(description, "...") {
+		t.Errorf("description = %q (%d characters), want 140 ending in ...", description, len(description))
+	}
+}
+
+func TestSetStatusRejectsInvalidInput(t *testing.T) {
+	tests := []struct {
+		name     string
+		fullName string
+		sha      string
+		wantErr  string
+	}{
+		{"no owner", "app", "abc123", "invalid repository name"},
+		{"no SHA", "acme/app", "", "no commit SHA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, statuses, _ := newTestReporter(t)
+
+			err := r.SetStatus(tt.fullName, tt.sha, StatusSuccess, "Deployment succeeded")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+			if len(*statuses) != 0 {
+				t.Errorf("%d statuses sent, want none", len(*statuses))
+			}
+		})
+	}
+}
+
+func TestSetStatusAPIError(t *testing.T) {
+	c := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/access_tokens") {
+			w.Write([]byte(`{"token": "ghs_test", "expires_at": "2099-01-01T00:00:00Z"}`))
+			return
+		}
+		http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+	})
+
+	err := NewStatusReporter(c).SetStatus("acme/app", "abc123", StatusSuccess, "Deployment succeeded")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("error = %v, want the API status", err)
+	}
+}
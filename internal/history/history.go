@@ -0,0 +1,190 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultRetention is how many deployments are kept when no retention is
+// configured
+const DefaultRetention = 1000
+
+// Entry is a recorded deployment
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Folder     string    `json:"folder"`
+	Repo       string    `json:"repo"`
+	Branch     string    `json:"branch"`
+	SHA        string    `json:"sha"`
+	Result     string    `json:"result"` // success, failure or conflict
+	DurationMS int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	LogPath    string    `json:"log_path,omitempty"` // Deployment log file, empty if disabled
+	DeliveryID string    `json:"delivery_id,omitempty"`
+}
+
+// Store records deployments for auditing
+type Store interface {
+	// Record appends a deployment
+	Record(entry Entry) error
+
+	// List returns the most recent deployments, newest first, at most
+	// limit (0 for all). A non-empty folder only returns its deployments.
+	List(folder string, limit int) ([]Entry, error)
+}
+
+// FileStore is a Store appending deployments as JSON lines to a file.
+// Once the file holds a tenth more entries than the retention, the oldest
+// entries are removed, so the file is not rewritten on every deployment.
+type FileStore struct {
+	path      string
+	retention int
+
+	mu    sync.Mutex
+	count int // Entries in the file, -1 until it has been read
+}
+
+// Compile-time check that FileStore implements Store
+var _ Store = (*FileStore)(nil)
+
+// NewFileStore creates a store writing to the file at path, keeping the
+// most recent retention deployments (0 = default)
+func NewFileStore(path string, retention int) *FileStore {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &FileStore{path: path, retention: retention, count: -1}
+}
+
+// Record appends a deployment to the file, pruning old entries when the
+// retention is exceeded
+func (s *FileStore) Record(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode deployment: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+	if s.count < 0 {
+		lines, err := s.readLines()
+		if err != nil {
+			return err
+		}
+		s.count = len(lines)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+
+	s.count++
+	if s.count <= s.retention+s.retention/10 {
+		return nil
+	}
+	return s.prune()
+}
+
+// List returns the most recent deployments from the file, newest first
+func (s *FileStore) List(folder string, limit int) ([]Entry, error) {
+	s.mu.Lock()
+	lines, err := s.readLines()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for i := len(lines) - 1; i >= 0; i-- {
+		var entry Entry
+		if err := json.Unmarshal(lines[i], &entry); err != nil {
+			// Skip a line cut short by a crash instead of failing
+			continue
+		}
+		if folder != "" && filepath.Clean(entry.Folder) != filepath.Clean(folder) {
+			continue
+		}
+		entries = append(entries, entry)
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+	}
+
+	return entries, nil
+}
+
+// prune rewrites the file with the most recent retention entries
+func (s *FileStore) prune() error {
+	lines, err := s.readLines()
+	if err != nil {
+		return err
+	}
+	s.count = len(lines)
+	if len(lines) <= s.retention {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, line := range lines[len(lines)-s.retention:] {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	// Replace the file atomically so a crash never loses the history
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0640); err != nil {
+		return fmt.Errorf("failed to prune history file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to prune history file: %w", err)
+	}
+	s.count = s.retention
+
+	return nil
+}
+
+// readLines returns the non-empty lines of the file, none if it does not
+// exist yet
+func (s *FileStore) readLines() ([][]byte, error) {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer file.Close()
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, append([]byte(nil), line...))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,137 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// record records deployments of folder with the given SHAs, one second
+// apart
+func record(t *testing.T, s *FileStore, folder string, shas ...string) {
+	t.Helper()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i, sha := range shas {
+		entry := Entry{Time: start.Add(time.Duration(i) * time.Second), Folder: folder, SHA: sha, Result: "success"}
+		if err := s.Record(entry); err != nil {
+			t.Fatalf("Record returned error: %v", err)
+		}
+	}
+}
+
+// shas returns the SHAs of entries
+func shas(entries []Entry) string {
+	var shas []string
+	for _, entry := range entries {
+		shas = append(shas, entry.SHA)
+	}
+	return strings.Join(shas, ",")
+}
+
+func TestListNewestFirst(t *testing.T) {
+	s := NewFileStore(filepath.Join(t.TempDir(), "history", "history.jsonl"), 0)
+
+	if entries, err := s.List("", 0); err != nil || len(entries) != 0 {
+		t.Fatalf("List of a missing file = %v, %v, want nothing", entries, err)
+	}
+
+	record(t, s, "/srv/app", "a", "b", "c")
+	entries, err := s.List("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := shas(entries); got != "c,b,a" {
+		t.Errorf("List = %s, want c,b,a", got)
+	}
+	if entries[0].Folder != "/srv/app" || !entries[0].Time.Equal(time.Date(2025, 1, 1, 12, 0, 2, 0, time.UTC)) {
+		t.Errorf("newest entry = %+v", entries[0])
+	}
+
+	entries, err = s.List("", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := shas(entries); got != "c,b" {
+		t.Errorf("List with limit 2 = %s, want c,b", got)
+	}
+}
+
+func TestListFolder(t *testing.T) {
+	s := NewFileStore(filepath.Join(t.TempDir(), "history.jsonl"), 0)
+	record(t, s, "/srv/app", "a1", "a2")
+	record(t, s, "/srv/api", "b1")
+	record(t, s, "/srv/app/", "a3")
+
+	entries, err := s.List("/srv/app", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := shas(entries); got != "a3,a2,a1" {
+		t.Errorf("List(/srv/app) = %s, want a3,a2,a1", got)
+	}
+	entries, err = s.List("/srv/api", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := shas(entries); got != "b1" {
+		t.Errorf("List(/srv/api) = %s, want b1", got)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s := NewFileStore(path, 20)
+
+	for i := 0; i < 50; i++ {
+		record(t, s, "/srv/app", fmt.Sprint(i))
+	}
+
+	entries, err := s.List("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The file is pruned back to the retention once it exceeds it by a tenth
+	if len(entries) < 20 || len(entries) > 22 {
+		t.Fatalf("%d entries kept, want between 20 and 22", len(entries))
+	}
+	if entries[0].SHA != "49" || entries[len(entries)-1].SHA != fmt.Sprint(50-len(entries)) {
+		t.Errorf("kept %s, want the newest entries", shas(entries))
+	}
+
+	// A new store counts the entries already in the file
+	s = NewFileStore(path, 10)
+	record(t, s, "/srv/app", "50")
+	if entries, _ := s.List("", 0); len(entries) != 10 || entries[0].SHA != "50" {
+		t.Errorf("after reopening with a lower retention, kept %s, want 50 down to 41", shas(entries))
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestListSkipsTruncatedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s := NewFileStore(path, 0)
+	record(t, s, "/srv/app", "a")
+
+	// A crash while writing leaves a partial line
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteString(`{"time":"2025-01-01T12:00:05Z","folder":"/srv/a`); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	entries, err := s.List("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := shas(entries); got != "a" {
+		t.Errorf("List = %s, want only the complete entry", got)
+	}
+}
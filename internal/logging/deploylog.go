@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDeployLogRetention is how many deployment logs of a folder are kept
+// when no retention is configured
+const DefaultDeployLogRetention = 20
+
+// deployLogTime is the timestamp layout of deployment log names
+const deployLogTime = "20060102T150405.000Z"
+
+// WriteDeployLog writes the log of a deployment of folder to a new
+// timestamped file in dir, removes the oldest logs of the folder beyond
+// keep and returns the path of the new file
+func WriteDeployLog(dir, folder string, start time.Time, content []byte, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create deployment log directory: %w", err)
+	}
+
+	path := filepath.Join(dir, deployLogPrefix(folder)+start.UTC().Format(deployLogTime)+".log")
+	if err := os.WriteFile(path, content, 0640); err != nil {
+		return "", fmt.Errorf("failed to write deployment log: %w", err)
+	}
+
+	if err := PruneDeployLogs(dir, folder, keep); err != nil {
+		return path, err
+	}
+
+	return path, nil
+}
+
+// PruneDeployLogs removes the oldest deployment logs of folder in dir so
+// that at most keep remain
+func PruneDeployLogs(dir, folder string, keep int) error {
+	if keep <= 0 {
+		keep = DefaultDeployLogRetention
+	}
+
+	prefix := deployLogPrefix(folder)
+	matches, err := filepath.Glob(filepath.Join(dir, prefix+"*.log"))
+	if err != nil {
+		return fmt.Errorf("failed to list deployment logs: %w", err)
+	}
+
+	// Skip logs of other folders whose prefix starts with this one
+	var logs []string
+	for _, path := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), prefix), ".log")
+		if _, err := time.Parse(deployLogTime, stamp); err == nil {
+			logs = append(logs, path)
+		}
+	}
+	if len(logs) <= keep {
+		return nil
+	}
+
+	// The timestamps in the names sort chronologically
+	sort.Strings(logs)
+	for _, path := range logs[:len(logs)-keep] {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old deployment log: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// deployLogPrefix turns a folder path into a file name prefix, so that logs
+// of folders with the same base name do not collide
+func deployLogPrefix(folder string) string {
+	name := strings.Trim(filepath.ToSlash(filepath.Clean(folder)), "/")
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+	return name + "_"
+}
@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteDeployLog(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	start := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	path, err := WriteDeployLog(dir, "/srv/app", start, []byte("output\n"), 5)
+	if err != nil {
+		t.Fatalf("WriteDeployLog returned error: %v", err)
+	}
+	if want := filepath.Join(dir, "srv_app_20240501T123000.000Z.log"); path != want {
+		t.Errorf("path = %s, want %s", path, want)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "output\n" {
+		t.Errorf("content = %q", content)
+	}
+}
+
+func TestWriteDeployLogPrunesOldLogs(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Logs of a folder whose prefix starts with this one are not pruned
+	other, err := WriteDeployLog(dir, "/srv/app_v2", start, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for i := 0; i < 5; i++ {
+		path, err := WriteDeployLog(dir, "/srv/app", start.Add(time.Duration(i)*time.Minute), nil, 3)
+		if err != nil {
+			t.Fatalf("WriteDeployLog returned error: %v", err)
+		}
+		paths = append(paths, path)
+	}
+
+	for i, path := range paths {
+		_, err := os.Stat(path)
+		if kept := i >= 2; kept != (err == nil) {
+			t.Errorf("log %d kept = %v, want %v", i, err == nil, kept)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("log of another folder was removed: %v", err)
+	}
+}
+
+func TestDeployLogPrefix(t *testing.T) {
+	tests := map[string]string{
+		"/srv/app":        "srv_app_",
+		"/srv/app/":       "srv_app_",
+		"/home/me/my app": "home_me_my_app_",
+		"/srv/site.com":   "srv_site.com_",
+	}
+	for folder, want := range tests {
+		if got := deployLogPrefix(folder); got != want {
+			t.Errorf("deployLogPrefix(%q) = %q, want %q", folder, got, want)
+		}
+	}
+}
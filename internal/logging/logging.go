@@ -0,0 +1,29 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Log formats
+const (
+	FormatText = "text" // Standard log lines with key=value attributes
+	FormatJSON = "json" // One JSON object per record, for log aggregators
+)
+
+// Setup configures the default logger to write to w in the given format
+// (empty means text). The standard log package is routed through it too, so
+// every log line shares the format.
+func Setup(w io.Writer, format string) error {
+	switch format {
+	case "", FormatText:
+		// The default logger already writes text through the log package
+		return nil
+	case FormatJSON:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, nil)))
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q (expected text or json)", format)
+	}
+}
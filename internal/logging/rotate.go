@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Defaults for RotatingFile
+const (
+	DefaultMaxSize    = 10 << 20 // 10 MB
+	DefaultMaxBackups = 3
+)
+
+// RotatingFile is an io.Writer appending to a file that is rotated once it
+// grows past a size limit. Rotated files are kept as path.1 (newest) up to
+// path.N (oldest).
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFile opens (or creates) the log file at path
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if maxBackups < 0 {
+		maxBackups = 0
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	r := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Write appends p to the file, rotating first if p would exceed the size limit
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// A failed rotation must not stop logging: the entry goes to the current
+	// file and rotation is retried on the next write
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file: %v\n", err)
+		}
+	}
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Close()
+}
+
+// open opens the log file for appending
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// rotate shifts the backups by one, moves the current file to path.1 and
+// starts a new file. The file is reopened even if moving it fails, so
+// logging continues in the oversized file.
+func (r *RotatingFile) rotate() error {
+	r.file.Close()
+	r.file = nil
+
+	var err error
+	if r.maxBackups == 0 {
+		os.Remove(r.path)
+	} else {
+		os.Remove(r.backupPath(r.maxBackups))
+		for i := r.maxBackups - 1; i >= 1; i-- {
+			os.Rename(r.backupPath(i), r.backupPath(i+1))
+		}
+		if renameErr := os.Rename(r.path, r.backupPath(1)); renameErr != nil {
+			err = fmt.Errorf("failed to rotate log file: %w", renameErr)
+		}
+	}
+
+	if openErr := r.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+// backupPath returns the path of the nth rotated file
+func (r *RotatingFile) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", r.path, n)
+}
@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readLog returns the contents of a log file, or "" if it does not exist
+func readLog(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotatingFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "deployer.log")
+	r, err := NewRotatingFile(path, 20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Every entry fills a file, so each write after the first rotates
+	for i := 1; i <= 4; i++ {
+		if _, err := fmt.Fprintf(r, "entry %d 1234567890\n", i); err != nil {
+			t.Fatalf("write %d returned error: %v", i, err)
+		}
+	}
+
+	want := map[string]string{
+		path:        "entry 4 1234567890\n",
+		path + ".1": "entry 3 1234567890\n",
+		path + ".2": "entry 2 1234567890\n",
+	}
+	for file, content := range want {
+		if got := readLog(t, file); got != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("oldest entry kept beyond maxBackups: %v", err)
+	}
+}
+
+func TestRotatingFileAppendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deployer.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewRotatingFile(path, 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(r, "new")
+	r.Close()
+
+	if got := readLog(t, path); got != "old\nnew\n" {
+		t.Errorf("log = %q, want the entry appended", got)
+	}
+}
+
+func TestRotatingFileWithoutBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deployer.log")
+	r, err := NewRotatingFile(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	fmt.Fprintln(r, "first entry")
+	fmt.Fprintln(r, "second entry")
+
+	if got := readLog(t, path); got != "second entry\n" {
+		t.Errorf("log = %q, want only the latest entry", got)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("backup created with maxBackups 0: %v", err)
+	}
+}
+
+func TestRotatingFileKeepsLoggingWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deployer.log")
+	r, err := NewRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// A non-empty directory in place of the backup makes the rename fail
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocked"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range []string{"first entry", "second entry", "third entry"} {
+		if _, err := fmt.Fprintln(r, entry); err != nil {
+			t.Fatalf("write of %q returned error: %v", entry, err)
+		}
+	}
+
+	got := readLog(t, path)
+	if !strings.Contains(got, "second entry") || !strings.Contains(got, "third entry") {
+		t.Errorf("log = %q, want the entries written after the failed rotation", got)
+	}
+}
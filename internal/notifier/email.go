@@ -1,7 +1,15 @@
 package notifier
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"html/template"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
 
 	"gopkg.in/gomail.v2"
 )
@@ -13,10 +21,26 @@ type EmailNotifier struct {
 	username string
 	password string
 	from     string
-	to       string
+	to       []string
+
+	tlsMode            string
+	insecureSkipVerify bool
+
+	template *template.Template // HTML body, sent along the plain text
+
+	notifyOnSuccess bool
 }
 
-// NewEmailNotifier creates a new email notifier
+// TLS modes of the SMTP connection
+const (
+	TLSAuto     = ""         // Implicit TLS on port 465, STARTTLS otherwise
+	TLSNone     = "none"     // No implicit TLS
+	TLSStartTLS = "starttls" // Plain connection upgraded with STARTTLS
+	TLSSSL      = "ssl"      // Implicit TLS from the start, whatever the port
+)
+
+// NewEmailNotifier creates a new email notifier. to is a comma-separated
+// list of recipients.
 func NewEmailNotifier(host string, port int, username, password, from, to string) *EmailNotifier {
 	return &EmailNotifier{
 		host:     host,
@@ -24,42 +48,264 @@ func NewEmailNotifier(host string, port int, username, password, from, to string
 		username: username,
 		password: password,
 		from:     from,
-		to:       to,
+		to:       SplitAddresses(to),
+		template: template.Must(LoadEmailTemplate("")),
+	}
+}
+
+// SetTemplate replaces the built-in HTML email template with a template
+// file
+func (n *EmailNotifier) SetTemplate(path string) error {
+	tmpl, err := LoadEmailTemplate(path)
+	if err != nil {
+		return err
 	}
+	n.template = tmpl
+	return nil
+}
+
+// WithRecipients returns a copy of the notifier sending to other
+// recipients, given as a comma-separated list
+func (n *EmailNotifier) WithRecipients(to string) *EmailNotifier {
+	copied := *n
+	copied.to = SplitAddresses(to)
+	return &copied
+}
+
+// SplitAddresses splits a comma-separated list of email addresses,
+// dropping empty entries
+func SplitAddresses(list string) []string {
+	var addresses []string
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// SetTLS sets the TLS mode of the SMTP connection and whether the server
+// certificate is verified
+func (n *EmailNotifier) SetTLS(mode string, insecureSkipVerify bool) {
+	n.tlsMode = mode
+	n.insecureSkipVerify = insecureSkipVerify
+}
+
+// SetNotifyOnSuccess enables or disables success notifications
+func (n *EmailNotifier) SetNotifyOnSuccess(enabled bool) {
+	n.notifyOnSuccess = enabled
 }
 
 // SendFailureNotification sends an email notification about a deployment failure
-func (n *EmailNotifier) SendFailureNotification(repoPath, branch, errorMsg string) error {
+func (n *EmailNotifier) SendFailureNotification(d Deployment, errorMsg string) error {
 	m := gomail.NewMessage()
 	m.SetHeader("From", n.from)
-	m.SetHeader("To", n.to)
-	m.SetHeader("Subject", fmt.Sprintf("Deployment Failed: %s", repoPath))
+	m.SetHeader("To", n.to...)
+	m.SetHeader("Subject", fmt.Sprintf("Deployment Failed: %s", d.RepoPath))
 
 	body := fmt.Sprintf(`
 Deployment Failure Notification
 
-Repository: %s
-Branch: %s
-Time: %s
+%s
+Error:
+%s
+%s
+Please check the repository and resolve any conflicts manually.
+`, deploymentDetails(d), errorMsg, outputExcerpt(d))
+
+	m.SetBody("text/plain", body)
+	data := newEmailData("Deployment Failed", slackColorFailure, d)
+	data.Error = errorMsg
+	data.Message = "Please check the repository and resolve any conflicts manually."
+	n.addHTML(m, data)
+
+	return n.send(m)
+}
+
+// SendConflictNotification sends an email notification about a merge conflict
+func (n *EmailNotifier) SendConflictNotification(d Deployment, errorMsg string) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", n.from)
+	m.SetHeader("To", n.to...)
+	m.SetHeader("Subject", fmt.Sprintf("Deployment Conflict: %s", d.RepoPath))
+
+	body := fmt.Sprintf(`
+Deployment Conflict Notification
+
+%s
+Git reported a conflict while pulling the latest changes:
+%s
+%s
+Please resolve the conflict in the repository manually.
+`, deploymentDetails(d), errorMsg, outputExcerpt(d))
+
+	m.SetBody("text/plain", body)
+	data := newEmailData("Deployment Conflict", slackColorConflict, d)
+	data.Error = errorMsg
+	data.Message = "Git reported a conflict while pulling the latest changes. Please resolve the conflict in the repository manually."
+	n.addHTML(m, data)
+
+	return n.send(m)
+}
+
+// SendCommandFailureNotification sends an email notification about a failed post-update command
+func (n *EmailNotifier) SendCommandFailureNotification(d Deployment, command, errorMsg string) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", n.from)
+	m.SetHeader("To", n.to...)
+	m.SetHeader("Subject", fmt.Sprintf("Deployment Command Failed: %s", d.RepoPath))
+
+	body := fmt.Sprintf(`
+Deployment Command Failure Notification
+
+%s
+Command:
+%s
 
 Error:
 %s
+%s
+The latest changes were pulled but the command did not complete successfully.
+`, deploymentDetails(d), command, errorMsg, outputExcerpt(d))
 
-Please check the repository and resolve any conflicts manually.
-`, repoPath, branch, getCurrentTime(), errorMsg)
+	m.SetBody("text/plain", body)
+	data := newEmailData("Deployment Command Failed", slackColorFailure, d)
+	data.Command = command
+	data.Error = errorMsg
+	data.Message = "The latest changes were pulled but the command did not complete successfully."
+	n.addHTML(m, data)
+
+	return n.send(m)
+}
+
+// SendSuccessNotification sends an email notification about a completed deployment
+// unless success notifications are disabled
+func (n *EmailNotifier) SendSuccessNotification(d Deployment, command, output string) error {
+	if !n.notifyOnSuccess {
+		return nil
+	}
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", n.from)
+	m.SetHeader("To", n.to...)
+	m.SetHeader("Subject", fmt.Sprintf("Deployment Succeeded: %s", d.RepoPath))
+
+	body := fmt.Sprintf(`
+Deployment Success Notification
+
+%s
+Command:
+%s
+
+Output:
+%s
+`, deploymentDetails(d), command, strings.TrimSpace(output))
 
 	m.SetBody("text/plain", body)
+	data := newEmailData("Deployment Succeeded", slackColorSuccess, d)
+	data.Command = command
+	data.Output = strings.TrimSpace(output)
+	n.addHTML(m, data)
 
-	d := gomail.NewDialer(n.host, n.port, n.username, n.password)
+	return n.send(m)
+}
 
-	if err := d.DialAndSend(m); err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
+// deploymentDetails returns the lines describing a deployment at the top
+// of every email
+func deploymentDetails(d Deployment) string {
+	details := fmt.Sprintf("Repository: %s\nBranch: %s\nCommit: %s\nTime: %s\n", d.RepoPath, d.Branch, commitSummary(d.Commit), getCurrentTime())
+	if d.DeliveryID != "" {
+		details += fmt.Sprintf("Delivery: %s\n", d.DeliveryID)
+	}
+	if d.LogPath != "" {
+		details += fmt.Sprintf("Log: %s\n", d.LogPath)
 	}
+	return details
+}
 
-	return nil
+// dialer returns a dialer for the SMTP server using the TLS settings. gomail
+// always upgrades a plain connection with STARTTLS when the server offers
+// it, so only implicit TLS needs to be chosen here. Without a username no
+// authentication is attempted, for relays accepting mail without it.
+func (n *EmailNotifier) dialer() *gomail.Dialer {
+	d := &gomail.Dialer{Host: n.host, Port: n.port, SSL: n.port == 465}
+	if n.username != "" {
+		d.Username = n.username
+		d.Password = n.password
+	}
+
+	switch n.tlsMode {
+	case TLSSSL:
+		d.SSL = true
+	case TLSNone, TLSStartTLS:
+		d.SSL = false
+	}
+
+	if n.insecureSkipVerify {
+		d.TLSConfig = &tls.Config{ServerName: n.host, InsecureSkipVerify: true}
+	}
+
+	return d
+}
+
+// emailAttempts is how often sending an email is tried before giving up
+const emailAttempts = 3
+
+// emailRetryBackoff is the wait before the first retry, doubled after each
+const emailRetryBackoff = 2 * time.Second
+
+// outputExcerpt returns the section showing the end of the deployment
+// output, or a blank line if there is none
+func outputExcerpt(d Deployment) string {
+	if d.Output == "" {
+		return "\n"
+	}
+	return fmt.Sprintf("\nOutput (last %d lines):\n%s\n\n", OutputExcerptLines, d.Output)
+}
+
+// send delivers a message through the configured SMTP server, retrying on
+// transient failures such as greylisting or a temporary DNS error
+func (n *EmailNotifier) send(m *gomail.Message) error {
+	d := n.dialer()
+
+	var err error
+	backoff := emailRetryBackoff
+	for attempt := 1; attempt <= emailAttempts; attempt++ {
+		if err = d.DialAndSend(m); err == nil {
+			return nil
+		}
+		if !isTransientSMTPError(err) || attempt == emailAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	return fmt.Errorf("failed to send email: %w", err)
+}
+
+// smtpReplyCode matches the SMTP reply code in errors returned by gomail,
+// which does not always wrap the underlying *textproto.Error
+var smtpReplyCode = regexp.MustCompile(`(?:^|: )([2-5])\d\d `)
+
+// isTransientSMTPError reports whether sending may succeed when retried:
+// network errors and 4xx replies are transient, while 5xx replies (e.g. an
+// authentication failure) are permanent
+func isTransientSMTPError(err error) bool {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+
+	if match := smtpReplyCode.FindStringSubmatch(err.Error()); match != nil {
+		return match[1] == "4"
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
 }
 
 // getCurrentTime returns the current time as a string
 func getCurrentTime() string {
-	return fmt.Sprintf("%v", gomail.NewMessage().GetHeader("Date"))
+	return time.Now().Format("2006-01-02 15:04:05 MST")
 }
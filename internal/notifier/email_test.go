@@ -0,0 +1,92 @@
+package notifier
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeploymentDetailsTime(t *testing.T) {
+	details := deploymentDetails(Deployment{RepoPath: "/srv/app", Branch: "main"})
+
+	var value string
+	for _, line := range strings.Split(details, "\n") {
+		if v, ok := strings.CutPrefix(line, "Time: "); ok {
+			value = v
+		}
+	}
+	if _, err := time.Parse("2006-01-02 15:04:05 MST", value); err != nil {
+		t.Errorf("Time = %q, want the current time: %v", value, err)
+	}
+	if data := newEmailData("Deployment Failed", slackColorFailure, Deployment{}); data.Time == "" || data.Time == "[]" {
+		t.Errorf("email template Time = %q, want the current time", data.Time)
+	}
+}
+
+func TestDefaultEmailTemplate(t *testing.T) {
+	tmpl, err := LoadEmailTemplate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := newEmailData("Deployment Command Failed", slackColorFailure, Deployment{RepoPath: "/srv/app", Branch: "main", DeliveryID: "72d3162e"})
+	data.Command = "make deploy"
+	data.Error = "exit status 2: <script>alert(1)</script>"
+
+	var html bytes.Buffer
+	if err := tmpl.Execute(&html, data); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<title>Deployment Command Failed: /srv/app</title>",
+		"background:" + slackColorFailure,
+		"<td>main</td>",
+		"<td>72d3162e</td>",
+		"<code>make deploy</code>",
+		"&lt;script&gt;alert(1)&lt;/script&gt;",
+	} {
+		if !strings.Contains(html.String(), want) {
+			t.Errorf("email does not contain %q", want)
+		}
+	}
+	if strings.Contains(html.String(), "Output</h3>") {
+		t.Error("email has an output section without output")
+	}
+}
+
+func TestLoadEmailTemplate(t *testing.T) {
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "custom.html")
+	if err := os.WriteFile(custom, []byte("<p>{{.Title}} on {{.Branch}}</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.html")
+	if err := os.WriteFile(broken, []byte("<p>{{.Title</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewEmailNotifier("smtp.example.com", 587, "", "", "a@example.com", "b@example.com")
+	if err := n.SetTemplate(custom); err != nil {
+		t.Fatalf("SetTemplate returned error: %v", err)
+	}
+	var html bytes.Buffer
+	if err := n.template.Execute(&html, emailData{Title: "Deployment Succeeded", Branch: "main"}); err != nil {
+		t.Fatal(err)
+	}
+	if html.String() != "<p>Deployment Succeeded on main</p>" {
+		t.Errorf("custom template rendered %q", html.String())
+	}
+
+	for _, path := range []string{broken, filepath.Join(dir, "missing.html")} {
+		if err := n.SetTemplate(path); err == nil {
+			t.Errorf("SetTemplate(%s) returned no error", filepath.Base(path))
+		}
+	}
+	html.Reset()
+	if err := n.template.Execute(&html, emailData{Title: "Deployment Succeeded", Branch: "main"}); err != nil || html.String() != "<p>Deployment Succeeded on main</p>" {
+		t.Errorf("failed SetTemplate replaced the template: rendered %q, %v", html.String(), err)
+	}
+}
@@ -0,0 +1,58 @@
+package notifier
+
+import (
+	"strings"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/git"
+)
+
+// Deployment describes the deployment a notification is about
+type Deployment struct {
+	RepoPath   string
+	Branch     string
+	Commit     *git.CommitInfo // Deployed commit, nil if the repository was not updated
+	DeliveryID string          // Webhook delivery that triggered the deployment, empty if manual
+	Output     string          // Last lines of the git or command output of a failed deployment
+	LogPath    string          // File holding the full output, empty if deployment logs are disabled
+}
+
+// OutputExcerptLines is how many lines of output a Deployment carries
+const OutputExcerptLines = 50
+
+// LastLines returns the last n lines of output
+func LastLines(output string, n int) string {
+	output = strings.TrimRight(output, "\n")
+	if output == "" {
+		return ""
+	}
+
+	lines := strings.Split(output, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Notifier is implemented by every notification channel
+type Notifier interface {
+	SendFailureNotification(d Deployment, errorMsg string) error
+	SendConflictNotification(d Deployment, errorMsg string) error
+	SendCommandFailureNotification(d Deployment, command, errorMsg string) error
+	SendSuccessNotification(d Deployment, command, output string) error
+}
+
+// Compile-time checks that the notifiers implement the interface
+var (
+	_ Notifier = (*EmailNotifier)(nil)
+	_ Notifier = (*SlackNotifier)(nil)
+	_ Notifier = (*WebhookNotifier)(nil)
+	_ Notifier = (*TelegramNotifier)(nil)
+)
+
+// commitSummary returns the one-line summary of a commit, or "unknown" if nil
+func commitSummary(commit *git.CommitInfo) string {
+	if commit == nil {
+		return "unknown"
+	}
+	return commit.String()
+}
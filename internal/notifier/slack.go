@@ -0,0 +1,124 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Attachment colors used for Slack messages
+const (
+	slackColorFailure  = "#d00000"
+	slackColorConflict = "#ff9900"
+	slackColorSuccess  = "#2eb67d"
+)
+
+// SlackNotifier handles Slack notifications via an incoming webhook
+type SlackNotifier struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// NewSlackNotifier creates a new Slack notifier for an incoming webhook URL
+func NewSlackNotifier(webhookURL string) *SlackNotifier {
+	return &SlackNotifier{
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// slackMessage is the JSON payload accepted by Slack incoming webhooks
+type slackMessage struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Title  string       `json:"title"`
+	Text   string       `json:"text"`
+	Fields []slackField `json:"fields"`
+}
+
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// SendFailureNotification posts a message about a deployment failure
+func (n *SlackNotifier) SendFailureNotification(d Deployment, errorMsg string) error {
+	return n.post(slackColorFailure, "Deployment Failed", d, "", errorMsg)
+}
+
+// SendConflictNotification posts a message about a merge conflict
+func (n *SlackNotifier) SendConflictNotification(d Deployment, errorMsg string) error {
+	return n.post(slackColorConflict, "Deployment Conflict", d, "", errorMsg)
+}
+
+// SendCommandFailureNotification posts a message about a failed post-update command
+func (n *SlackNotifier) SendCommandFailureNotification(d Deployment, command, errorMsg string) error {
+	return n.post(slackColorFailure, "Deployment Command Failed", d, command, errorMsg)
+}
+
+// SendSuccessNotification posts a message about a completed deployment
+func (n *SlackNotifier) SendSuccessNotification(d Deployment, command, output string) error {
+	return n.post(slackColorSuccess, "Deployment Succeeded", d, command, strings.TrimSpace(output))
+}
+
+// post sends a single color-coded attachment to the webhook
+func (n *SlackNotifier) post(color, title string, d Deployment, command, text string) error {
+	fields := []slackField{
+		{Title: "Repository", Value: d.RepoPath, Short: true},
+		{Title: "Branch", Value: d.Branch, Short: true},
+	}
+	if d.Commit != nil {
+		fields = append(fields, slackField{Title: "Commit", Value: d.Commit.String()})
+	}
+	if d.DeliveryID != "" {
+		fields = append(fields, slackField{Title: "Delivery", Value: d.DeliveryID, Short: true})
+	}
+	if d.LogPath != "" {
+		fields = append(fields, slackField{Title: "Log", Value: d.LogPath})
+	}
+	if command != "" {
+		fields = append(fields, slackField{Title: "Command", Value: command})
+	}
+	if d.Output != "" {
+		fields = append(fields, slackField{Title: "Output", Value: fmt.Sprintf("```%s```", d.Output)})
+	}
+
+	attachment := slackAttachment{
+		Color:  color,
+		Title:  title,
+		Fields: fields,
+	}
+	if text != "" {
+		attachment.Text = fmt.Sprintf("```%s```", text)
+	}
+
+	msg := slackMessage{
+		Text:        fmt.Sprintf("%s: %s", title, d.RepoPath),
+		Attachments: []slackAttachment{attachment},
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal slack message: %w", err)
+	}
+
+	resp, err := n.client.Post(n.webhookURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to send slack message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/git"
+)
+
+// newSlackServer returns a Slack notifier posting to a test server and the
+// channel receiving the decoded messages
+func newSlackServer(t *testing.T, status int) (*SlackNotifier, <-chan slackMessage) {
+	t.Helper()
+	messages := make(chan slackMessage, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var msg slackMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("decoding message: %v", err)
+		}
+		messages <- msg
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return NewSlackNotifier(server.URL), messages
+}
+
+func TestSlackFailureNotification(t *testing.T) {
+	n, messages := newSlackServer(t, http.StatusOK)
+
+	commit := &git.CommitInfo{SHA: "0123456789abcdef", Author: "Jane", Subject: "Fix build", Timestamp: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	if err := n.SendCommandFailureNotification(Deployment{RepoPath: "/srv/app", Branch: "main", Commit: commit, DeliveryID: "72d3162e"}, "make deploy", "exit status 2"); err != nil {
+		t.Fatalf("SendCommandFailureNotification returned error: %v", err)
+	}
+	msg := <-messages
+
+	if msg.Text != "Deployment Command Failed: /srv/app" {
+		t.Errorf("text = %q", msg.Text)
+	}
+	if len(msg.Attachments) != 1 {
+		t.Fatalf("%d attachments, want 1", len(msg.Attachments))
+	}
+	a := msg.Attachments[0]
+	if a.Color != slackColorFailure {
+		t.Errorf("color = %q, want red %q", a.Color, slackColorFailure)
+	}
+	if a.Title != "Deployment Command Failed" {
+		t.Errorf("title = %q", a.Title)
+	}
+	if !strings.Contains(a.Text, "exit status 2") {
+		t.Errorf("attachment text = %q, want the error", a.Text)
+	}
+	want := []slackField{
+		{Title: "Repository", Value: "/srv/app", Short: true},
+		{Title: "Branch", Value: "main", Short: true},
+		{Title: "Commit", Value: "0123456 Fix build (Jane, 2025-01-01T12:00:00Z)"},
+		{Title: "Delivery", Value: "72d3162e", Short: true},
+		{Title: "Command", Value: "make deploy"},
+	}
+	if len(a.Fields) != len(want) {
+		t.Fatalf("fields = %+v, want %+v", a.Fields, want)
+	}
+	for i := range want {
+		if a.Fields[i] != want[i] {
+			t.Errorf("field %d = %+v, want %+v", i, a.Fields[i], want[i])
+		}
+	}
+}
+
+func TestSlackNotificationColors(t *testing.T) {
+	tests := []struct {
+		name  string
+		send  func(n *SlackNotifier) error
+		color string
+		title string
+	}{
+		{"failure", func(n *SlackNotifier) error {
+			return n.SendFailureNotification(Deployment{RepoPath: "/srv/app", Branch: "main"}, "pull failed")
+		}, slackColorFailure, "Deployment Failed"},
+		{"conflict", func(n *SlackNotifier) error {
+			return n.SendConflictNotification(Deployment{RepoPath: "/srv/app", Branch: "main"}, "conflict")
+		}, slackColorConflict, "Deployment Conflict"},
+		{"success", func(n *SlackNotifier) error {
+			return n.SendSuccessNotification(Deployment{RepoPath: "/srv/app", Branch: "main"}, "make", "done\n")
+		}, slackColorSuccess, "Deployment Succeeded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, messages := newSlackServer(t, http.StatusOK)
+			if err := tt.send(n); err != nil {
+				t.Fatalf("send returned error: %v", err)
+			}
+			a := (<-messages).Attachments[0]
+			if a.Color != tt.color || a.Title != tt.title {
+				t.Errorf("color and title = %q, %q, want %q, %q", a.Color, a.Title, tt.color, tt.title)
+			}
+		})
+	}
+}
+
+func TestSlackErrorStatus(t *testing.T) {
+	n, _ := newSlackServer(t, http.StatusNotFound)
+
+	err := n.SendFailureNotification(Deployment{RepoPath: "/srv/app", Branch: "main"}, "pull failed")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("error = %v, want the status code", err)
+	}
+}
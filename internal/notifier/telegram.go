@@ -0,0 +1,158 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// telegramAPIURL is the base URL of the Telegram Bot API
+const telegramAPIURL = "https://api.telegram.org"
+
+// telegramMaxText is the part of Telegram's 4096 character message limit
+// left for the error or output, the rest is kept for the details
+const telegramMaxText = 3000
+
+// TelegramNotifier handles notifications sent by a Telegram bot
+type TelegramNotifier struct {
+	apiURL   string
+	botToken string
+	chatID   string
+	client   *http.Client
+}
+
+// NewTelegramNotifier creates a new Telegram notifier sending messages as
+// the bot to a chat
+func NewTelegramNotifier(botToken, chatID string) *TelegramNotifier {
+	return &TelegramNotifier{
+		apiURL:   telegramAPIURL,
+		botToken: botToken,
+		chatID:   chatID,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// telegramMessage is the JSON payload of the sendMessage method
+type telegramMessage struct {
+	ChatID                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	ParseMode             string `json:"parse_mode"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview"`
+}
+
+// SendFailureNotification sends a message about a deployment failure
+func (n *TelegramNotifier) SendFailureNotification(d Deployment, errorMsg string) error {
+	return n.send("Deployment Failed", d, "", errorMsg)
+}
+
+// SendConflictNotification sends a message about a merge conflict
+func (n *TelegramNotifier) SendConflictNotification(d Deployment, errorMsg string) error {
+	return n.send("Deployment Conflict", d, "", errorMsg)
+}
+
+// SendCommandFailureNotification sends a message about a failed post-update command
+func (n *TelegramNotifier) SendCommandFailureNotification(d Deployment, command, errorMsg string) error {
+	return n.send("Deployment Command Failed", d, command, errorMsg)
+}
+
+// SendSuccessNotification sends a message about a completed deployment
+func (n *TelegramNotifier) SendSuccessNotification(d Deployment, command, output string) error {
+	return n.send("Deployment Succeeded", d, command, strings.TrimSpace(output))
+}
+
+// send formats the message with MarkdownV2 and sends it to the chat
+func (n *TelegramNotifier) send(title string, d Deployment, command, text string) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "*%s*\n", escapeMarkdown(title))
+	fmt.Fprintf(&msg, "*Repository:* %s\n", escapeMarkdown(d.RepoPath))
+	fmt.Fprintf(&msg, "*Branch:* %s\n", escapeMarkdown(d.Branch))
+	if d.Commit != nil {
+		fmt.Fprintf(&msg, "*Commit:* %s\n", escapeMarkdown(d.Commit.String()))
+	}
+	if d.DeliveryID != "" {
+		fmt.Fprintf(&msg, "*Delivery:* %s\n", escapeMarkdown(d.DeliveryID))
+	}
+	if d.LogPath != "" {
+		fmt.Fprintf(&msg, "*Log:* `%s`\n", escapeMarkdownCode(d.LogPath))
+	}
+	if command != "" {
+		fmt.Fprintf(&msg, "*Command:*\n```\n%s\n```\n", escapeMarkdownCode(command))
+	}
+	if text != "" {
+		fmt.Fprintf(&msg, "```\n%s\n```\n", escapeMarkdownCode(truncateStart(text, telegramMaxText)))
+	}
+
+	payload, err := json.Marshal(telegramMessage{
+		ChatID:                n.chatID,
+		Text:                  msg.String(),
+		ParseMode:             "MarkdownV2",
+		DisableWebPagePreview: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal telegram message: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/bot%s/sendMessage", n.apiURL, n.botToken)
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		// The URL contains the bot token, keep it out of logs
+		return fmt.Errorf("failed to send telegram message: %w", redactURLError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("telegram API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// markdownEscaper escapes the characters that are special in Telegram's
+// MarkdownV2 outside of code blocks
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`,
+	"|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// escapeMarkdown escapes text for MarkdownV2
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+// escapeMarkdownCode escapes text for a MarkdownV2 code block, where only
+// backslashes and backticks are special
+func escapeMarkdownCode(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(text)
+}
+
+// truncateStart shortens text to at most max bytes by dropping its start,
+// which keeps the end of an output where errors usually are
+func truncateStart(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	text = text[len(text)-max:]
+	// Don't start in the middle of a UTF-8 sequence
+	for len(text) > 0 && !utf8.RuneStart(text[0]) {
+		text = text[1:]
+	}
+	return "..." + text
+}
+
+// redactURLError strips the request URL from an HTTP client error
+func redactURLError(err error) error {
+	var urlErr *neturl.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// newTelegramServer returns a notifier sending to a test server, which
+// answers with status and passes on the requested path and message
+func newTelegramServer(t *testing.T, status int) (*TelegramNotifier, <-chan string, <-chan telegramMessage) {
+	t.Helper()
+	paths := make(chan string, 1)
+	messages := make(chan telegramMessage, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg telegramMessage
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("invalid JSON payload: %v", err)
+		}
+		paths <- r.URL.Path
+		messages <- msg
+		w.WriteHeader(status)
+		w.Write([]byte(`{"ok":false,"description":"Bad Request: chat not found"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	n := NewTelegramNotifier("123:secret-token", "-1001234")
+	n.apiURL = server.URL
+	return n, paths, messages
+}
+
+func TestTelegramMessage(t *testing.T) {
+	n, paths, messages := newTelegramServer(t, http.StatusOK)
+
+	d := Deployment{RepoPath: "/srv/my_app", Branch: "release-1.0", DeliveryID: "72d3162e"}
+	if err := n.SendCommandFailureNotification(d, "make deploy", "exit status 2"); err != nil {
+		t.Fatalf("SendCommandFailureNotification returned error: %v", err)
+	}
+
+	if path := <-paths; path != "/bot123:secret-token/sendMessage" {
+		t.Errorf("path = %q", path)
+	}
+	msg := <-messages
+	if msg.ChatID != "-1001234" || msg.ParseMode != "MarkdownV2" || !msg.DisableWebPagePreview {
+		t.Errorf("message = %+v", msg)
+	}
+	want := "*Deployment Command Failed*\n" +
+		"*Repository:* /srv/my\\_app\n" +
+		"*Branch:* release\\-1\\.0\n" +
+		"*Delivery:* 72d3162e\n" +
+		"*Command:*\n```\nmake deploy\n```\n" +
+		"```\nexit status 2\n```\n"
+	if msg.Text != want {
+		t.Errorf("text = %q, want %q", msg.Text, want)
+	}
+}
+
+func TestTelegramAPIError(t *testing.T) {
+	n, _, _ := newTelegramServer(t, http.StatusBadRequest)
+
+	err := n.SendFailureNotification(Deployment{RepoPath: "/srv/app", Branch: "main"}, "pull failed")
+	if err == nil || !strings.Contains(err.Error(), "status 400") || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("error = %v, want the status and description", err)
+	}
+}
+
+func TestTelegramErrorHidesToken(t *testing.T) {
+	n := NewTelegramNotifier("123:secret-token", "-1001234")
+	n.apiURL = "http://127.0.0.1:1" // Nothing listens here
+
+	err := n.SendFailureNotification(Deployment{RepoPath: "/srv/app", Branch: "main"}, "pull failed")
+	if err == nil {
+		t.Fatal("SendFailureNotification returned no error")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("error contains the bot token: %v", err)
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"plain text", "plain text"},
+		{"my_app*", `my\_app\*`},
+		{"[link](url)", `\[link\]\(url\)`},
+		{"~`>#+-=|{}.!", "\\~\\`\\>\\#\\+\\-\\=\\|\\{\\}\\.\\!"},
+		{`C:\path`, `C:\\path`},
+		{"a1b2c3d Fix build (Jane, 2025-01-01)", `a1b2c3d Fix build \(Jane, 2025\-01\-01\)`},
+	}
+
+	for _, tt := range tests {
+		if got := escapeMarkdown(tt.text); got != tt.want {
+			t.Errorf("escapeMarkdown(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	// Inside code blocks only backslashes and backticks are escaped
+	if got := escapeMarkdownCode("echo `date` > out_1.txt\\n"); got != "echo \\`date\\` > out_1.txt\\\\n" {
+		t.Errorf("escapeMarkdownCode = %q", got)
+	}
+}
+
+func TestTruncateStart(t *testing.T) {
+	if got := truncateStart("short", telegramMaxText); got != "short" {
+		t.Errorf("truncateStart(short) = %q", got)
+	}
+
+	long := "first line\n" + strings.Repeat("x", telegramMaxText) + "\nerror: build failed"
+	got := truncateStart(long, telegramMaxText)
+	if !strings.HasPrefix(got, "...") || !strings.HasSuffix(got, "error: build failed") {
+		t.Errorf("truncateStart kept %q...%q, want the end", got[:10], got[len(got)-20:])
+	}
+	if len(got) != telegramMaxText+len("...") {
+		t.Errorf("len = %d, want %d", len(got), telegramMaxText+len("..."))
+	}
+
+	// Never cut a multi-byte character in half
+	got = truncateStart(strings.Repeat("é", telegramMaxText), telegramMaxText)
+	if !utf8.ValidString(got) {
+		t.Error("truncateStart returned invalid UTF-8")
+	}
+}
+
+func TestTelegramTruncatesOutput(t *testing.T) {
+	n, _, messages := newTelegramServer(t, http.StatusOK)
+
+	output := strings.Repeat("line of output\n", 1000) + "done"
+	if err := n.SendSuccessNotification(Deployment{RepoPath: "/srv/app", Branch: "main"}, "make", output); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-messages
+	if utf8.RuneCountInString(msg.Text) > 4096 {
+		t.Errorf("message has %d characters, over Telegram's limit", utf8.RuneCountInString(msg.Text))
+	}
+	if !strings.Contains(msg.Text, "done\n```") {
+		t.Error("message lost the end of the output")
+	}
+}
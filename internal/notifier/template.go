@@ -0,0 +1,79 @@
+package notifier
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"os"
+
+	"gopkg.in/gomail.v2"
+)
+
+// defaultEmailTemplate is the HTML email template used unless another one
+// is configured
+//
+//go:embed templates/email.html
+var defaultEmailTemplate string
+
+// emailData is passed to the HTML email template
+type emailData struct {
+	Title      string // e.g. "Deployment Failed"
+	Color      string // Header color matching the outcome
+	Repository string
+	Branch     string
+	Commit     string
+	Time       string
+	Delivery   string // Empty for manual deployments
+	LogPath    string // Empty if deployment logs are disabled
+	Command    string // Empty unless a command is involved
+	Message    string // Advice shown below the details
+	Error      string
+	Output     string
+}
+
+// LoadEmailTemplate parses an HTML email template file. An empty path
+// returns the built-in template.
+func LoadEmailTemplate(path string) (*template.Template, error) {
+	if path == "" {
+		return template.New("email").Parse(defaultEmailTemplate)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read email template: %w", err)
+	}
+
+	tmpl, err := template.New("email").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// newEmailData returns the template data describing a deployment
+func newEmailData(title, color string, d Deployment) emailData {
+	return emailData{
+		Title:      title,
+		Color:      color,
+		Repository: d.RepoPath,
+		Branch:     d.Branch,
+		Commit:     commitSummary(d.Commit),
+		Time:       getCurrentTime(),
+		Delivery:   d.DeliveryID,
+		LogPath:    d.LogPath,
+		Output:     d.Output,
+	}
+}
+
+// addHTML adds the HTML rendering of a notification as an alternative to
+// the plain-text body. If the template fails, only the plain text is sent.
+func (n *EmailNotifier) addHTML(m *gomail.Message, data emailData) {
+	var html bytes.Buffer
+	if err := n.template.Execute(&html, data); err != nil {
+		slog.Warn("Error rendering HTML email, sending plain text only", "error", err)
+		return
+	}
+	m.AddAlternative("text/html", html.String())
+}
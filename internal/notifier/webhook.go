@@ -0,0 +1,120 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/git"
+)
+
+// WebhookNotifier posts notifications as JSON to a generic HTTP endpoint
+type WebhookNotifier struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewWebhookNotifier creates a new webhook notifier. The headers are added to
+// every request, e.g. for authentication.
+func NewWebhookNotifier(url string, headers map[string]string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:     url,
+		headers: headers,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// webhookPayload is the JSON body sent to the endpoint
+type webhookPayload struct {
+	Event      string          `json:"event"`
+	RepoPath   string          `json:"repo_path"`
+	Branch     string          `json:"branch"`
+	Command    string          `json:"command"`
+	Error      string          `json:"error"`
+	Output     string          `json:"output,omitempty"`
+	Commit     *git.CommitInfo `json:"commit,omitempty"`
+	DeliveryID string          `json:"delivery_id,omitempty"`
+	LogPath    string          `json:"log_path,omitempty"`
+	Timestamp  string          `json:"timestamp"`
+}
+
+// SendFailureNotification posts a deployment failure event
+func (n *WebhookNotifier) SendFailureNotification(d Deployment, errorMsg string) error {
+	return n.post("failure", d, webhookPayload{Error: errorMsg})
+}
+
+// SendConflictNotification posts a merge conflict event
+func (n *WebhookNotifier) SendConflictNotification(d Deployment, errorMsg string) error {
+	return n.post("conflict", d, webhookPayload{Error: errorMsg})
+}
+
+// SendCommandFailureNotification posts a command failure event
+func (n *WebhookNotifier) SendCommandFailureNotification(d Deployment, command, errorMsg string) error {
+	return n.post("command_failure", d, webhookPayload{Command: command, Error: errorMsg})
+}
+
+// SendSuccessNotification posts a deployment success event
+func (n *WebhookNotifier) SendSuccessNotification(d Deployment, command, output string) error {
+	return n.post("success", d, webhookPayload{Command: command, Output: output})
+}
+
+// post fills in the event and deployment details and sends the payload,
+// retrying once if the endpoint returns a 5xx status
+func (n *WebhookNotifier) post(event string, d Deployment, payload webhookPayload) error {
+	payload.Event = event
+	payload.RepoPath = d.RepoPath
+	payload.Branch = d.Branch
+	payload.Commit = d.Commit
+	payload.DeliveryID = d.DeliveryID
+	payload.LogPath = d.LogPath
+	if payload.Output == "" {
+		payload.Output = d.Output
+	}
+	payload.Timestamp = time.Now().UTC().Format(time.RFC3339)
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		var retry bool
+		retry, lastErr = n.send(body)
+		if lastErr == nil || !retry {
+			return lastErr
+		}
+	}
+
+	return lastErr
+}
+
+// send performs a single request and reports whether it may be retried
+func (n *WebhookNotifier) send(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range n.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return true, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,128 @@
+package notifier
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/git"
+)
+
+// webhookServer is a test endpoint recording the requests it receives
+type webhookServer struct {
+	requests atomic.Int32
+	last     atomic.Pointer[http.Request]
+	body     atomic.Pointer[[]byte]
+}
+
+// newWebhookServer returns a webhook notifier posting to a test endpoint that
+// answers with the given statuses in turn, repeating the last one
+func newWebhookServer(t *testing.T, headers map[string]string, statuses ...int) (Notifier, *webhookServer) {
+	t.Helper()
+	s := &webhookServer{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(s.requests.Add(1))
+		body, _ := io.ReadAll(r.Body)
+		s.last.Store(r)
+		s.body.Store(&body)
+		w.WriteHeader(statuses[min(n, len(statuses))-1])
+	}))
+	t.Cleanup(server.Close)
+	return NewWebhookNotifier(server.URL, headers), s
+}
+
+func TestWebhookPayload(t *testing.T) {
+	n, s := newWebhookServer(t, map[string]string{"Authorization": "Bearer secret", "X-Env": "prod"}, http.StatusNoContent)
+
+	if err := n.SendCommandFailureNotification(Deployment{RepoPath: "/srv/app", Branch: "main", DeliveryID: "72d3162e"}, "make deploy", "exit status 2"); err != nil {
+		t.Fatalf("SendCommandFailureNotification returned error: %v", err)
+	}
+
+	r := s.last.Load()
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("request = %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+	}
+	if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("X-Env") != "prod" {
+		t.Errorf("custom headers missing: %v", r.Header)
+	}
+
+	var payload map[string]string
+	if err := json.Unmarshal(*s.body.Load(), &payload); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"event":       "command_failure",
+		"repo_path":   "/srv/app",
+		"branch":      "main",
+		"command":     "make deploy",
+		"error":       "exit status 2",
+		"delivery_id": "72d3162e",
+	}
+	for key, value := range want {
+		if payload[key] != value {
+			t.Errorf("%s = %q, want %q", key, payload[key], value)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339, payload["timestamp"]); err != nil {
+		t.Errorf("timestamp = %q, want RFC 3339: %v", payload["timestamp"], err)
+	}
+	if len(payload) != len(want)+1 {
+		t.Errorf("payload = %v, want only %v and timestamp", payload, want)
+	}
+}
+
+func TestWebhookPayloadCommit(t *testing.T) {
+	n, s := newWebhookServer(t, nil, http.StatusOK)
+	commit := &git.CommitInfo{SHA: "0123456789abcdef", Author: "Jane", Subject: "Fix build", Timestamp: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+
+	if err := n.SendSuccessNotification(Deployment{RepoPath: "/srv/app", Branch: "main", Commit: commit}, "make deploy", "done"); err != nil {
+		t.Fatalf("SendSuccessNotification returned error: %v", err)
+	}
+
+	var payload struct {
+		Event  string          `json:"event"`
+		Output string          `json:"output"`
+		Commit *git.CommitInfo `json:"commit"`
+	}
+	if err := json.Unmarshal(*s.body.Load(), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "success" || payload.Output != "done" {
+		t.Errorf("payload = %+v", payload)
+	}
+	if payload.Commit == nil || *payload.Commit != *commit {
+		t.Errorf("commit = %+v, want %+v", payload.Commit, commit)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int32
+		wantErr  bool
+	}{
+		{"success", []int{http.StatusOK}, 1, false},
+		{"retried 5xx", []int{http.StatusBadGateway, http.StatusOK}, 2, false},
+		{"retried once", []int{http.StatusServiceUnavailable}, 2, true},
+		{"4xx not retried", []int{http.StatusUnauthorized}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, s := newWebhookServer(t, nil, tt.statuses...)
+
+			err := n.SendFailureNotification(Deployment{RepoPath: "/srv/app", Branch: "main"}, "pull failed")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := s.requests.Load(); got != tt.requests {
+				t.Errorf("%d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
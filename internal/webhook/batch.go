@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/notifier"
+)
+
+// deployBatch collects the failures of the folders deployed in parallel for
+// a single push, so they are notified together once every folder is done
+// instead of in one notification per folder
+type deployBatch struct {
+	mu       sync.Mutex
+	pending  int // Folders not deployed yet
+	failures []batchFailure
+}
+
+// batchFailure is the failed deployment of one folder of a batch
+type batchFailure struct {
+	folder     config.WatchedFolder
+	deployment notifier.Deployment
+	err        error
+}
+
+// fail records the failed deployment of a folder
+func (b *deployBatch) fail(folder *config.WatchedFolder, d notifier.Deployment, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = append(b.failures, batchFailure{folder: *folder, deployment: d, err: err})
+}
+
+// done marks a folder as finished, deployed or not, and returns the
+// failures of the batch once the last folder is finished
+func (b *deployBatch) done() (failures []batchFailure, last bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending--
+	return b.failures, b.pending == 0
+}
+
+// finishBatch marks a folder of a batch as finished and, after the last
+// one, sends the failure notifications of the batch. Folders notifying the
+// same channels and recipients get a single notification listing every
+// failure.
+func (h *Handler) finishBatch(logger *slog.Logger, batch *deployBatch) {
+	failures, last := batch.done()
+	if !last || len(failures) == 0 {
+		return
+	}
+
+	// Folders finish in any order; list them by path
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].folder.Path < failures[j].folder.Path
+	})
+
+	var order []string
+	groups := make(map[string][]batchFailure)
+	for _, f := range failures {
+		key := strings.Join(f.folder.Notify, ",") + "|" + f.folder.NotifyTo
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], f)
+	}
+
+	for _, key := range order {
+		group := groups[key]
+		if len(group) == 1 {
+			h.notifyFailure(logger, &group[0].folder, group[0].deployment, group[0].err)
+			continue
+		}
+		d, err := combineFailures(group)
+		h.notifyFailure(logger, &group[0].folder, d, err)
+	}
+}
+
+// combineFailures merges the failures of several folders into a single
+// deployment and error for one notification
+func combineFailures(failures []batchFailure) (notifier.Deployment, error) {
+	var paths, logPaths, messages []string
+	var output strings.Builder
+	for _, f := range failures {
+		paths = append(paths, f.folder.Path)
+		if f.deployment.LogPath != "" {
+			logPaths = append(logPaths, f.deployment.LogPath)
+		}
+		messages = append(messages, fmt.Sprintf("%s: %v", f.folder.Path, f.err))
+		if f.deployment.Output != "" {
+			fmt.Fprintf(&output, "==> %s <==\n%s\n", f.folder.Path, f.deployment.Output)
+		}
+	}
+
+	d := notifier.Deployment{
+		RepoPath:   strings.Join(paths, ", "),
+		Branch:     failures[0].deployment.Branch,
+		DeliveryID: failures[0].deployment.DeliveryID,
+		Output:     strings.TrimRight(output.String(), "\n"),
+		LogPath:    strings.Join(logPaths, ", "),
+	}
+	// Nothing is wrapped: the combined failure is neither a single conflict
+	// nor a single failed command
+	err := fmt.Errorf("%d of the folders deployed for this push failed:\n\n%s", len(failures), strings.Join(messages, "\n\n"))
+	return d, err
+}
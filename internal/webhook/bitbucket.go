@@ -0,0 +1,101 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+// bitbucketProvider handles Bitbucket Cloud repo:push webhooks, signed with
+// an HMAC-SHA256 of the body in X-Hub-Signature
+type bitbucketProvider struct{}
+
+// bitbucketPushEvent is the part of Bitbucket's repo:push payload the
+// deployer uses. A push lists a change per updated branch or tag.
+type bitbucketPushEvent struct {
+	Push struct {
+		Changes []struct {
+			New *struct {
+				Type   string `json:"type"` // branch or tag
+				Name   string `json:"name"`
+				Target struct {
+					Hash string `json:"hash"`
+				} `json:"target"`
+			} `json:"new"` // nil if the branch or tag was deleted
+		} `json:"changes"`
+	} `json:"push"`
+	Repository struct {
+		FullName string `json:"full_name"` // e.g. workspace/repo
+		Links    struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+	} `json:"repository"`
+	Actor struct {
+		Nickname string `json:"nickname"`
+	} `json:"actor"` // User who pushed
+}
+
+func (bitbucketProvider) Name() string { return ProviderBitbucket }
+
+func (bitbucketProvider) Detect(header http.Header) bool {
+	return header.Get("X-Event-Key") != ""
+}
+
+func (bitbucketProvider) Event(header http.Header) string {
+	return header.Get("X-Event-Key")
+}
+
+func (bitbucketProvider) DeliveryID(header http.Header) string {
+	return header.Get("X-Request-UUID")
+}
+
+func (bitbucketProvider) Verify(secret []byte, header http.Header, body []byte) bool {
+	signature := header.Get("X-Hub-Signature")
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	return checkMAC(sha256.New, secret, body, strings.TrimPrefix(signature, "sha256="))
+}
+
+func (bitbucketProvider) Parse(header http.Header, body []byte) ([]Push, error) {
+	if header.Get("X-Event-Key") != "repo:push" {
+		return nil, nil
+	}
+
+	var push bitbucketPushEvent
+	if err := json.Unmarshal(body, &push); err != nil {
+		return nil, fmt.Errorf("error parsing Bitbucket push event: %w", err)
+	}
+
+	repo := Repository{
+		FullName: push.Repository.FullName,
+		CloneURL: push.Repository.Links.HTML.Href,
+	}
+
+	var pushes []Push
+	for _, change := range push.Push.Changes {
+		if change.New == nil {
+			continue
+		}
+
+		// Bitbucket does not list changed files, so path filters do not
+		// apply to its pushes
+		event := &PushEvent{After: change.New.Target.Hash, Repository: repo, Pusher: Pusher{Name: push.Actor.Nickname}}
+		switch change.New.Type {
+		case "branch":
+			event.Ref = "refs/heads/" + change.New.Name
+			pushes = append(pushes, Push{Event: event, Trigger: config.TriggerBranch})
+		case "tag":
+			event.Ref = "refs/tags/" + change.New.Name
+			pushes = append(pushes, Push{Event: event, Trigger: config.TriggerTag})
+		}
+	}
+
+	return pushes, nil
+}
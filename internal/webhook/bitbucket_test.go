@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+const bitbucketPush = `{
+  "push": {
+    "changes": [
+      {"new": {"type": "branch", "name": "main", "target": {"hash": "1e5c8a7d"}}},
+      {"new": {"type": "tag", "name": "v1.0.0", "target": {"hash": "9f0e2b3c"}}},
+      {"new": null}
+    ]
+  },
+  "repository": {
+    "full_name": "team/app",
+    "links": {"html": {"href": "https://bitbucket.org/team/app"}}
+  }
+}`
+
+func TestProviderFor(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"X-GitHub-Event", ProviderGitHub},
+		{"X-Gitlab-Event", ProviderGitLab},
+		{"X-Event-Key", ProviderBitbucket},
+		{"X-Unknown-Event", ProviderGitHub},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		header.Set(tt.header, "push")
+		if got := providerFor(header).Name(); got != tt.want {
+			t.Errorf("provider of a request with %s = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestBitbucketParsePush(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Event-Key", "repo:push")
+
+	pushes, err := bitbucketProvider{}.Parse(header, []byte(bitbucketPush))
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	// The deleted branch is skipped
+	if len(pushes) != 2 {
+		t.Fatalf("Parse returned %d pushes, want 2", len(pushes))
+	}
+
+	branch, tag := pushes[0], pushes[1]
+	if branch.Trigger != config.TriggerBranch || branch.Event.Branch() != "main" || branch.Event.After != "1e5c8a7d" {
+		t.Errorf("branch push = %s %+v", branch.Trigger, branch.Event)
+	}
+	if tag.Trigger != config.TriggerTag || tag.Event.Tag() != "v1.0.0" || tag.Event.After != "9f0e2b3c" {
+		t.Errorf("tag push = %s %+v", tag.Trigger, tag.Event)
+	}
+	repo := branch.Event.Repository
+	if repo.FullName != "team/app" || repo.CloneURL != "https://bitbucket.org/team/app" {
+		t.Errorf("repository = %+v", repo)
+	}
+
+	header.Set("X-Event-Key", "pullrequest:created")
+	if pushes, err := (bitbucketProvider{}).Parse(header, []byte(bitbucketPush)); err != nil || len(pushes) != 0 {
+		t.Errorf("Parse of a pull request event = %v, %v, want no pushes", pushes, err)
+	}
+}
+
+func TestBitbucketWebhookDeploys(t *testing.T) {
+	// The pre-command shows that the push was deployed
+	marker := filepath.Join(t.TempDir(), "deployed")
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", PreCommand: "touch " + marker, Command: "true", RepoURL: "git@bitbucket.org:team/app.git"}
+	h := newWebhookHandler(folder)
+
+	tests := []struct {
+		name      string
+		signature string
+		want      int
+	}{
+		{"wrong secret", "sha256=" + sign(sha256.New, "wrong-secret", bitbucketPush), http.StatusUnauthorized},
+		{"unsigned", "", http.StatusUnauthorized},
+		{"unprefixed", sign(sha256.New, testSecret, bitbucketPush), http.StatusUnauthorized},
+		{"valid", "sha256=" + sign(sha256.New, testSecret, bitbucketPush), http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(bitbucketPush))
+		req.Header.Set("X-Event-Key", "repo:push")
+		if tt.signature != "" {
+			req.Header.Set("X-Hub-Signature", tt.signature)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	if err := h.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("signed push was not deployed: %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package webhook
+
+import "time"
+
+// seenDelivery reports whether a delivery was already received within the
+// delivery TTL, e.g. because GitHub redelivered it after a timeout.
+// Otherwise the delivery is remembered. The oldest deliveries are
+// forgotten once more than the configured number are remembered.
+func (h *Handler) seenDelivery(provider, id string) bool {
+	server := h.currentConfig().Server
+	ttl := server.GetDeliveryTTL()
+	if ttl <= 0 || id == "" {
+		return false
+	}
+	key := provider + ":" + id
+	now := time.Now()
+
+	h.deliveriesMu.Lock()
+	defer h.deliveriesMu.Unlock()
+
+	if at, ok := h.deliveries[key]; ok && now.Sub(at) < ttl {
+		return true
+	}
+	h.deliveries[key] = now
+
+	// Forget expired deliveries, then the oldest ones if still too many
+	if len(h.deliveries) > server.GetDeliveryCacheSize() {
+		for k, at := range h.deliveries {
+			if now.Sub(at) >= ttl {
+				delete(h.deliveries, k)
+			}
+		}
+	}
+	for len(h.deliveries) > server.GetDeliveryCacheSize() {
+		oldestKey, oldest := "", now
+		for k, at := range h.deliveries {
+			if at.Before(oldest) || oldestKey == "" {
+				oldestKey, oldest = k, at
+			}
+		}
+		delete(h.deliveries, oldestKey)
+	}
+
+	return false
+}
@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+func TestSeenDelivery(t *testing.T) {
+	h := NewHandler(&config.Config{})
+
+	if h.seenDelivery(ProviderGitHub, "1") {
+		t.Error("first delivery reported as seen")
+	}
+	if !h.seenDelivery(ProviderGitHub, "1") {
+		t.Error("redelivery not reported as seen")
+	}
+	if h.seenDelivery(ProviderGitLab, "1") {
+		t.Error("delivery of another provider with the same ID reported as seen")
+	}
+	if h.seenDelivery(ProviderGitHub, "") || h.seenDelivery(ProviderGitHub, "") {
+		t.Error("delivery without an ID reported as seen")
+	}
+
+	// Expired deliveries are forgotten
+	h.deliveries[ProviderGitHub+":1"] = time.Now().Add(-2 * config.DefaultDeliveryTTL)
+	if h.seenDelivery(ProviderGitHub, "1") {
+		t.Error("delivery older than the TTL reported as seen")
+	}
+}
+
+func TestSeenDeliveryDisabled(t *testing.T) {
+	h := NewHandler(&config.Config{})
+	h.currentConfig().Server.DeliveryTTL = -1
+
+	h.seenDelivery(ProviderGitHub, "1")
+	if h.seenDelivery(ProviderGitHub, "1") {
+		t.Error("redelivery reported as seen with deduplication disabled")
+	}
+}
+
+func TestSeenDeliveryCacheSize(t *testing.T) {
+	h := NewHandler(&config.Config{})
+	h.currentConfig().Server.DeliveryCacheSize = 3
+
+	for i := 0; i < 5; i++ {
+		h.seenDelivery(ProviderGitHub, fmt.Sprint(i))
+		// Keep the deliveries in order despite the clock resolution
+		h.deliveries[ProviderGitHub+":"+fmt.Sprint(i)] = time.Now().Add(time.Duration(i-5) * time.Second)
+	}
+	if len(h.deliveries) > 3 {
+		t.Errorf("%d deliveries remembered, want at most 3", len(h.deliveries))
+	}
+	if !h.seenDelivery(ProviderGitHub, "4") {
+		t.Error("newest delivery forgotten")
+	}
+	if h.seenDelivery(ProviderGitHub, "0") {
+		t.Error("oldest delivery still remembered")
+	}
+}
+
+func TestRedeliveryDeploysOnce(t *testing.T) {
+	// The pre-command counts the deployments
+	counter := filepath.Join(t.TempDir(), "deployments")
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", PreCommand: "echo deployed >> " + counter, Command: "true", RepoURL: "https://github.com/owner/repo.git"}
+	h := newWebhookHandler(folder)
+
+	body := `{"ref": "refs/heads/main", "after": "abc123", "repository": {"full_name": "owner/repo", "clone_url": "https://github.com/owner/repo.git"}}`
+	want := []int{http.StatusAccepted, http.StatusOK}
+	for i, status := range want {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, newWebhookRequest("push", body))
+		if rec.Code != status {
+			t.Errorf("delivery %d: status = %d, want %d", i+1, rec.Code, status)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	deployments, err := os.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(deployments), "deployed"); n != 1 {
+		t.Errorf("deployed %d times, want once", n)
+	}
+}
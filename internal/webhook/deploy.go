@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/git"
+)
+
+// ProviderGeneric is the provider of deployments requested through the
+// /deploy endpoint
+const ProviderGeneric = "generic"
+
+// deployRequest is the body of a request to the /deploy endpoint
+type deployRequest struct {
+	RepoURL string `json:"repo_url"`
+	Branch  string `json:"branch"`
+	SHA     string `json:"sha"` // Optional, exported as DEPLOY_SHA until the deployed commit is known
+}
+
+// DeployHandler returns an HTTP handler deploying a branch of a repository
+// on request, for Gitea, Forgejo, CI jobs and other systems that cannot
+// send GitHub webhooks. The JSON body {"repo_url", "branch", "sha"} must be
+// signed with an HMAC-SHA256 of the webhook secret, sent hex-encoded as
+// "X-Deploy-Signature: sha256=<hex>". Matching folders are deployed like
+// for a push and the request is answered with 202 Accepted, or 404 if no
+// folder watches the branch.
+func (h *Handler) DeployHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		deliveryID := r.Header.Get("X-Request-ID")
+		if deliveryID == "" {
+			deliveryID = newDeliveryID()
+		}
+		logger := h.logger.With("delivery", deliveryID)
+
+		secret := []byte(h.currentConfig().GitHub.WebhookSecret)
+		if len(secret) == 0 {
+			logger.Error("No webhook secret configured, rejecting deploy request", "event", "webhook_rejected")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		body, ok := h.readBody(logger, w, r)
+		if !ok {
+			return
+		}
+
+		signature := r.Header.Get("X-Deploy-Signature")
+		if !strings.HasPrefix(signature, "sha256=") || !checkMAC(sha256.New, secret, body, strings.TrimPrefix(signature, "sha256=")) {
+			logger.Warn("Invalid deploy request signature", "event", "webhook_rejected", "provider", ProviderGeneric)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		webhookRequestsTotal.WithLabelValues("deploy").Inc()
+
+		if h.seenDelivery(ProviderGeneric, r.Header.Get("X-Request-ID")) {
+			logger.Info("Ignoring repeated deploy request", "event", "webhook_duplicate", "provider", ProviderGeneric)
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintf(w, "Duplicate delivery")
+			return
+		}
+
+		var req deployRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			logger.Error("Error parsing deploy request", "error", err)
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		if req.RepoURL == "" || req.Branch == "" {
+			http.Error(w, "repo_url and branch are required", http.StatusBadRequest)
+			return
+		}
+
+		event := &PushEvent{
+			Ref:        "refs/heads/" + req.Branch,
+			After:      req.SHA,
+			Repository: Repository{CloneURL: req.RepoURL},
+			DeliveryID: deliveryID,
+			Provider:   ProviderGeneric,
+		}
+		if owner, name, err := git.ParseRepoURL(req.RepoURL); err == nil {
+			event.Repository.FullName = owner + "/" + name
+		}
+
+		// Tell the caller right away if nothing would be deployed
+		folders, _ := h.currentConfig().GetWatchersByRepo(event.Repository.FullName, req.RepoURL)
+		watched := false
+		for _, folder := range folders {
+			if folder.GetTrigger() == config.TriggerBranch && folder.MatchesBranch(req.Branch) {
+				watched = true
+				break
+			}
+		}
+		if !watched {
+			logger.Info("Ignoring deploy request, no watched folder", "repo", req.RepoURL, "branch", req.Branch)
+			http.Error(w, "No watched folder for this repository and branch", http.StatusNotFound)
+			return
+		}
+
+		h.accept(w, []Push{{Event: event, Trigger: config.TriggerBranch}})
+	})
+}
@@ -0,0 +1,78 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+// postDeploy sends a request to the deploy endpoint, signed with the secret
+// unless it is empty
+func postDeploy(h *Handler, secret, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/deploy", strings.NewReader(body))
+	if secret != "" {
+		req.Header.Set("X-Deploy-Signature", "sha256="+sign(sha256.New, secret, body))
+	}
+	rec := httptest.NewRecorder()
+	h.DeployHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDeployHandler(t *testing.T) {
+	// The pre-command shows that the branch was deployed
+	marker := filepath.Join(t.TempDir(), "deployed")
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", PreCommand: "touch " + marker, Command: "true", RepoURL: "git@git.example.com:team/app.git"}
+	h := newWebhookHandler(folder)
+
+	deploy := `{"repo_url": "https://git.example.com/team/app", "branch": "main", "sha": "abc123"}`
+	tests := []struct {
+		name   string
+		secret string
+		body   string
+		want   int
+	}{
+		{"unsigned", "", deploy, http.StatusUnauthorized},
+		{"wrong secret", "wrong-secret", deploy, http.StatusUnauthorized},
+		{"invalid JSON", testSecret, `{"repo_url": `, http.StatusBadRequest},
+		{"no branch", testSecret, `{"repo_url": "https://git.example.com/team/app"}`, http.StatusBadRequest},
+		{"unwatched branch", testSecret, `{"repo_url": "https://git.example.com/team/app", "branch": "dev"}`, http.StatusNotFound},
+		{"same repository on another host", testSecret, `{"repo_url": "https://github.com/team/app", "branch": "main"}`, http.StatusNotFound},
+		{"valid", testSecret, deploy, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := postDeploy(h, tt.secret, tt.body); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	if err := h.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("valid deploy request was not deployed: %v", err)
+	}
+}
+
+func TestDeployHandlerRequiresSecret(t *testing.T) {
+	h := NewHandler(&config.Config{})
+
+	// A signature made with an empty key must not be accepted
+	if rec := postDeploy(h, "", `{"repo_url": "https://git.example.com/team/app", "branch": "main"}`); rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/deploy", nil)
+	rec := httptest.NewRecorder()
+	h.DeployHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/git"
+)
+
+// fakeRepo is a git.Repo recording the operations of a deployment instead
+// of running git
+type fakeRepo struct {
+	mu     sync.Mutex
+	head   string
+	branch string
+	remote string // Commit fetched by FetchCommit
+	dirty  bool
+	calls  []string
+
+	pullErr   error
+	verifyErr error
+}
+
+// newFakeRepo returns a clean repository on main at the given commit
+func newFakeRepo(head string) *fakeRepo {
+	return &fakeRepo{head: head, branch: "main", remote: "fetched"}
+}
+
+func (r *fakeRepo) record(call string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+// Calls returns the operations run so far, e.g. "pull main"
+func (r *fakeRepo) Calls() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.calls)
+}
+
+func (r *fakeRepo) GetCurrentBranch() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.branch, nil
+}
+
+func (r *fakeRepo) GetHeadSHA() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.head, nil
+}
+
+func (r *fakeRepo) GetHeadCommit() (*git.CommitInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &git.CommitInfo{SHA: r.head, Subject: "Commit " + r.head}, nil
+}
+
+func (r *fakeRepo) IsDirty() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dirty, nil
+}
+
+func (r *fakeRepo) StashChanges() error {
+	r.record("stash")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dirty = false
+	return nil
+}
+
+func (r *fakeRepo) HardReset(branch string) error {
+	r.record("reset " + branch)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dirty = false
+	return nil
+}
+
+func (r *fakeRepo) ResetToCommit(sha string) error {
+	r.record("reset-to " + sha)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.head = sha
+	return nil
+}
+
+func (r *fakeRepo) VerifyCommitSignature(sha string) error {
+	r.record("verify " + sha)
+	return r.verifyErr
+}
+
+func (r *fakeRepo) FetchAndPull(branch string) error {
+	r.record("pull " + branch)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pullErr != nil {
+		return r.pullErr
+	}
+	r.head = "pulled-" + branch
+	return nil
+}
+
+func (r *fakeRepo) FetchAndPullWithToken(branch, token string) error {
+	return r.FetchAndPull(branch)
+}
+
+func (r *fakeRepo) CheckoutBranch(branch string) error {
+	r.record("checkout-branch " + branch)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.branch = branch
+	return nil
+}
+
+func (r *fakeRepo) CheckoutBranchWithToken(branch, token string) error {
+	return r.CheckoutBranch(branch)
+}
+
+func (r *fakeRepo) CheckoutTag(tag string) error {
+	r.record("checkout-tag " + tag)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.head = "tag-" + tag
+	return nil
+}
+
+func (r *fakeRepo) CheckoutTagWithToken(tag, token string) error {
+	return r.CheckoutTag(tag)
+}
+
+func (r *fakeRepo) CheckoutSHA(sha string) error {
+	r.record("checkout-sha " + sha)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.head = sha
+	return nil
+}
+
+func (r *fakeRepo) CheckoutSHAWithToken(sha, token string) error {
+	return r.CheckoutSHA(sha)
+}
+
+func (r *fakeRepo) FetchCommit(ref string) (string, error) {
+	r.record("fetch " + ref)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.remote, nil
+}
+
+func (r *fakeRepo) FetchCommitWithToken(ref, token string) (string, error) {
+	return r.FetchCommit(ref)
+}
+
+func (r *fakeRepo) FastForward(sha string) error {
+	r.record("fast-forward " + sha)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.head = sha
+	return nil
+}
+
+func (r *fakeRepo) UpdateSubmodules() error {
+	r.record("submodules")
+	return nil
+}
+
+func (r *fakeRepo) UpdateSubmodulesWithToken(token string) error {
+	return r.UpdateSubmodules()
+}
+
+// newTestHandler returns a handler for the folders that records its history
+// in a temporary directory and opens repo for every folder
+func newTestHandler(t *testing.T, repo git.Repo, folders ...config.WatchedFolder) *Handler {
+	t.Helper()
+	cfg := &config.Config{Folders: folders}
+	cfg.Server.HistoryFile = filepath.Join(t.TempDir(), "history.jsonl")
+
+	h := NewHandler(cfg)
+	h.SetLogger(slog.New(slog.NewTextHandler(testWriter{t}, nil)))
+	h.SetRepoFactory(func(ctx context.Context, logger *slog.Logger, folder *config.WatchedFolder) git.Repo {
+		return repo
+	})
+	return h
+}
+
+// testWriter writes log output to the test log
+type testWriter struct{ t *testing.T }
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// pushEvent returns a push of a commit to a branch of owner/repo
+func pushEvent(branch, sha string) *PushEvent {
+	return &PushEvent{
+		Ref:        "refs/heads/" + branch,
+		After:      sha,
+		Repository: Repository{FullName: "owner/repo"},
+		DeliveryID: "test-delivery",
+	}
+}
@@ -0,0 +1,44 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"net/http"
+)
+
+// giteaProvider handles Gitea and Forgejo webhooks. Their push and release
+// payloads are GitHub-compatible; they are signed with a hex-encoded
+// HMAC-SHA256 of the body in X-Gitea-Signature (X-Forgejo-Signature for
+// Forgejo, which sends both).
+type giteaProvider struct{}
+
+func (giteaProvider) Name() string { return ProviderGitea }
+
+func (giteaProvider) Detect(header http.Header) bool {
+	return header.Get("X-Gitea-Event") != "" || header.Get("X-Forgejo-Event") != ""
+}
+
+func (giteaProvider) Event(header http.Header) string {
+	return giteaHeader(header, "Event")
+}
+
+func (giteaProvider) DeliveryID(header http.Header) string {
+	return giteaHeader(header, "Delivery")
+}
+
+func (giteaProvider) Verify(secret []byte, header http.Header, body []byte) bool {
+	signature := giteaHeader(header, "Signature")
+	return signature != "" && checkMAC(sha256.New, secret, body, signature)
+}
+
+func (giteaProvider) Parse(header http.Header, body []byte) ([]Push, error) {
+	return parseGitHubEvent(giteaHeader(header, "Event"), body)
+}
+
+// giteaHeader returns the X-Forgejo- header with the given suffix, falling
+// back to the X-Gitea- header
+func giteaHeader(header http.Header, suffix string) string {
+	if value := header.Get("X-Forgejo-" + suffix); value != "" {
+		return value
+	}
+	return header.Get("X-Gitea-" + suffix)
+}
@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+const giteaPush = `{
+  "ref": "refs/heads/main",
+  "after": "4f5c1e2d",
+  "repository": {
+    "full_name": "team/app",
+    "clone_url": "https://gitea.example.com/team/app.git"
+  }
+}`
+
+func TestGiteaDetected(t *testing.T) {
+	// Gitea and Forgejo also send GitHub's event header
+	tests := []struct {
+		name    string
+		headers []string
+	}{
+		{"gitea", []string{"X-Gitea-Event", "X-GitHub-Event"}},
+		{"forgejo", []string{"X-Forgejo-Event", "X-Gitea-Event", "X-GitHub-Event"}},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		for _, name := range tt.headers {
+			header.Set(name, "push")
+		}
+		if got := providerFor(header).Name(); got != ProviderGitea {
+			t.Errorf("provider of a %s request = %s, want %s", tt.name, got, ProviderGitea)
+		}
+	}
+}
+
+func TestGiteaVerify(t *testing.T) {
+	valid := sign(sha256.New, testSecret, giteaPush)
+	wrong := sign(sha256.New, "wrong-secret", giteaPush)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{"gitea", map[string]string{"X-Gitea-Signature": valid}, true},
+		{"forgejo", map[string]string{"X-Forgejo-Signature": valid, "X-Gitea-Signature": valid}, true},
+		{"wrong secret", map[string]string{"X-Gitea-Signature": wrong}, false},
+		{"forgejo signature preferred", map[string]string{"X-Forgejo-Signature": wrong, "X-Gitea-Signature": valid}, false},
+		{"unsigned", nil, false},
+	}
+
+	for _, tt := range tests {
+		header := http.Header{}
+		for name, value := range tt.headers {
+			header.Set(name, value)
+		}
+		if got := (giteaProvider{}).Verify([]byte(testSecret), header, []byte(giteaPush)); got != tt.want {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGiteaWebhookDeploys(t *testing.T) {
+	// The pre-command shows that the push was deployed
+	marker := filepath.Join(t.TempDir(), "deployed")
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", PreCommand: "touch " + marker, Command: "true", RepoURL: "git@gitea.example.com:team/app.git"}
+	h := newWebhookHandler(folder)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(giteaPush))
+	req.Header.Set("X-Gitea-Event", "push")
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("X-Gitea-Delivery", "gitea-delivery-1")
+	req.Header.Set("X-Gitea-Signature", sign(sha256.New, testSecret, giteaPush))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+
+	if err := h.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("signed push was not deployed: %v", err)
+	}
+}
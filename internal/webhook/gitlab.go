@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+// gitlabProvider handles GitLab push and tag push webhooks, authenticated
+// by the secret token sent as is in X-Gitlab-Token
+type gitlabProvider struct{}
+
+// gitlabPushEvent is the part of GitLab's push and tag push payloads the
+// deployer uses
+type gitlabPushEvent struct {
+	Ref         string `json:"ref"`
+	After       string `json:"after"`
+	CheckoutSHA string `json:"checkout_sha"` // Commit a pushed tag points to
+	Project     struct {
+		PathWithNamespace string `json:"path_with_namespace"` // e.g. group/subgroup/project
+		GitHTTPURL        string `json:"git_http_url"`
+	} `json:"project"`
+	Commits      []Commit `json:"commits"`
+	UserUsername string   `json:"user_username"` // User who pushed
+}
+
+func (gitlabProvider) Name() string { return ProviderGitLab }
+
+func (gitlabProvider) Detect(header http.Header) bool {
+	return header.Get("X-Gitlab-Event") != ""
+}
+
+func (gitlabProvider) Event(header http.Header) string {
+	return header.Get("X-Gitlab-Event")
+}
+
+func (gitlabProvider) DeliveryID(header http.Header) string {
+	return header.Get("X-Gitlab-Event-UUID")
+}
+
+func (gitlabProvider) Verify(secret []byte, header http.Header, body []byte) bool {
+	token := header.Get("X-Gitlab-Token")
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), secret) == 1
+}
+
+func (gitlabProvider) Parse(header http.Header, body []byte) ([]Push, error) {
+	var trigger string
+	switch header.Get("X-Gitlab-Event") {
+	case "Push Hook":
+		trigger = config.TriggerBranch
+	case "Tag Push Hook":
+		trigger = config.TriggerTag
+	default:
+		return nil, nil
+	}
+
+	var push gitlabPushEvent
+	if err := json.Unmarshal(body, &push); err != nil {
+		return nil, fmt.Errorf("error parsing GitLab push event: %w", err)
+	}
+
+	event := &PushEvent{
+		Ref:   push.Ref,
+		After: push.After,
+		Repository: Repository{
+			FullName: push.Project.PathWithNamespace,
+			CloneURL: push.Project.GitHTTPURL,
+		},
+		Commits: push.Commits,
+		Pusher:  Pusher{Name: push.UserUsername},
+	}
+	if trigger == config.TriggerTag && push.CheckoutSHA != "" {
+		event.After = push.CheckoutSHA
+	}
+
+	return []Push{{Event: event, Trigger: trigger}}, nil
+}
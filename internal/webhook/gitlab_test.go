@@ -0,0 +1,85 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+func TestGitLabParsePush(t *testing.T) {
+	body, err := os.ReadFile("testdata/gitlab_push.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set("X-Gitlab-Event", "Push Hook")
+
+	pushes, err := gitlabProvider{}.Parse(header, body)
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+	if len(pushes) != 1 {
+		t.Fatalf("Parse returned %d pushes, want 1", len(pushes))
+	}
+	push := pushes[0]
+	if push.Trigger != config.TriggerBranch {
+		t.Errorf("trigger = %q, want %q", push.Trigger, config.TriggerBranch)
+	}
+	event := push.Event
+	if event.Branch() != "master" || event.After != "da1560886d4f094c3e6c9ef40349f7d38b5d27d7" {
+		t.Errorf("branch and commit = %q, %q", event.Branch(), event.After)
+	}
+	if event.Repository.FullName != "mike/diaspora" || event.Repository.CloneURL != "http://example.com/mike/diaspora.git" {
+		t.Errorf("repository = %+v", event.Repository)
+	}
+	if files := event.ChangedFiles(); !slices.Contains(files, "CHANGELOG") || !slices.Contains(files, "app/controller/application.rb") {
+		t.Errorf("changed files = %q", files)
+	}
+}
+
+func TestGitLabWebhookDeploys(t *testing.T) {
+	body, err := os.ReadFile("testdata/gitlab_push.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The pre-command shows that the push was deployed
+	marker := filepath.Join(t.TempDir(), "deployed")
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "master", PreCommand: "touch " + marker, Command: "true", RepoURL: "git@example.com:mike/diaspora.git"}
+	h := newWebhookHandler(folder)
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"wrong token", "wrong", http.StatusUnauthorized},
+		{"no token", "", http.StatusUnauthorized},
+		{"valid token", testSecret, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(string(body)))
+		req.Header.Set("X-Gitlab-Event", "Push Hook")
+		if tt.token != "" {
+			req.Header.Set("X-Gitlab-Token", tt.token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	if err := h.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("push with a valid token was not deployed: %v", err)
+	}
+}
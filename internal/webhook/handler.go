@@ -1,45 +1,270 @@
 package webhook
 
 import (
+	"context"
 	"crypto/hmac"
-	"crypto/sha256"
+	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
 	"github.com/eliasfloreteng/github-auto-deployer/internal/executor"
 	"github.com/eliasfloreteng/github-auto-deployer/internal/git"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/github"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/history"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/logging"
 	"github.com/eliasfloreteng/github-auto-deployer/internal/notifier"
 )
 
 // Handler handles GitHub webhook requests
 type Handler struct {
-	config   *config.Config
-	notifier *notifier.EmailNotifier
+	// Configuration and what is derived from it, replaced by Reload
+	configMu  sync.RWMutex
+	config    *config.Config
+	notifiers []notifier.Notifier
+	allowlist *ipAllowlist  // Restricts requests to GitHub's hook IP ranges, nil if disabled
+	history   history.Store // Records every deployment for auditing
+
+	logger *slog.Logger
+
+	// Tracks deployments in progress so shutdown can wait for them
+	deployments sync.WaitGroup
+
+	// Receives the result of each finished deployment, nil if unused
+	results chan<- DeployResult
+
+	// Pending deployments by folder path, used to coalesce rapid pushes
+	queuesMu sync.Mutex
+	queues   map[string]*folderQueue
+
+	// Bounds the number of deployments running at once; the others wait
+	// for a free slot
+	deploySlots chan struct{}
+	waiting     atomic.Int64 // Deployments waiting for a slot
+
+	// Last deployment of each folder by path, served by the status endpoint
+	statusMu sync.Mutex
+	status   map[string]*DeployStatus
+
+	// When each recent delivery was received by provider and ID, to
+	// ignore redeliveries
+	deliveriesMu sync.Mutex
+	deliveries   map[string]time.Time
+
+	// Last failure emailed for each folder by path, to throttle repeated
+	// emails about the same error
+	notifiedMu sync.Mutex
+	notified   map[string]lastNotification
+
+	// Per-folder locks (keyed by folder path) so deployments of the same
+	// folder never overlap while different folders run in parallel
+	folderLocksMu sync.Mutex
+	folderLocks   map[string]*sync.Mutex
+
+	// GitHub App clients by installation ID, so tokens are reused
+	appClientsMu sync.Mutex
+	appClients   map[int64]*github.AppClient
+
+	// Opens the repository of a folder, nil to use a git.Manager
+	repoFactory RepoFactory
+
+	// Runs the commands of folders, nil to start them as processes
+	commandRunner executor.Runner
 }
 
-// NewHandler creates a new webhook handler
+// RepoFactory opens the repository of a watched folder for a deployment.
+// The repository runs git under ctx and logs to logger.
+type RepoFactory func(ctx context.Context, logger *slog.Logger, folder *config.WatchedFolder) git.Repo
+
+// NewHandler creates a new webhook handler logging to the default logger
 func NewHandler(cfg *config.Config) *Handler {
-	emailNotifier := notifier.NewEmailNotifier(
-		cfg.SMTP.Host,
-		cfg.SMTP.Port,
-		cfg.SMTP.Username,
-		cfg.SMTP.Password,
-		cfg.SMTP.From,
-		cfg.SMTP.To,
-	)
+	var allowlist *ipAllowlist
+	if cfg.Server.RestrictToGitHubIPs {
+		allowlist = newIPAllowlist()
+	}
 
 	return &Handler{
-		config:   cfg,
-		notifier: emailNotifier,
+		config:      cfg,
+		logger:      slog.Default(),
+		allowlist:   allowlist,
+		notifiers:   newNotifiers(cfg),
+		history:     cfg.Server.NewHistoryStore(),
+		queues:      make(map[string]*folderQueue),
+		deploySlots: make(chan struct{}, cfg.Server.GetMaxConcurrentDeploys()),
+		status:      make(map[string]*DeployStatus),
+		notified:    make(map[string]lastNotification),
+		deliveries:  make(map[string]time.Time),
+		folderLocks: make(map[string]*sync.Mutex),
+		appClients:  make(map[int64]*github.AppClient),
 	}
 }
 
+// SetLogger sets the logger used for webhooks and deployments
+func (h *Handler) SetLogger(logger *slog.Logger) {
+	h.logger = logger
+}
+
+// SetRepoFactory replaces how the repositories of folders are opened, e.g.
+// with a fake in tests. It must be called before the handler serves
+// requests.
+func (h *Handler) SetRepoFactory(factory RepoFactory) {
+	h.repoFactory = factory
+}
+
+// SetCommandRunner replaces how the commands of folders are run, e.g. with
+// a fake in tests. It must be called before the handler serves requests.
+func (h *Handler) SetCommandRunner(runner executor.Runner) {
+	h.commandRunner = runner
+}
+
+// openRepo opens the repository of a folder with the configured factory,
+// or as a git.Manager with the git settings and the folder's pull strategy
+func (h *Handler) openRepo(ctx context.Context, logger *slog.Logger, folder *config.WatchedFolder) git.Repo {
+	if h.repoFactory != nil {
+		return h.repoFactory(ctx, logger, folder)
+	}
+
+	gitMgr := h.currentConfig().Git.NewManager(folder.Path)
+	gitMgr.SetLogger(logger)
+	gitMgr.SetContext(ctx)
+	gitMgr.SetPullStrategy(folder.PullStrategy)
+	return gitMgr
+}
+
+// DeployResult is the outcome of a deployment triggered by a webhook
+type DeployResult struct {
+	Folder string
+	Status DeployStatus
+}
+
+// SetResults makes the handler send the result of every finished
+// deployment to results, e.g. to stop the server after the first one.
+// Results are dropped while the channel is full. It must be called before
+// the handler serves requests.
+func (h *Handler) SetResults(results chan<- DeployResult) {
+	h.results = results
+}
+
+// sendResult sends a deployment result to the results channel, if any,
+// without blocking
+func (h *Handler) sendResult(folder string, status DeployStatus) {
+	if h.results == nil {
+		return
+	}
+	select {
+	case h.results <- DeployResult{Folder: folder, Status: status}:
+	default:
+	}
+}
+
+// newNotifiers creates a notifier for every configured notification channel
+func newNotifiers(cfg *config.Config) []notifier.Notifier {
+	var notifiers []notifier.Notifier
+
+	if cfg.SMTP.Enabled() {
+		emailNotifier := notifier.NewEmailNotifier(
+			cfg.SMTP.Host,
+			cfg.SMTP.Port,
+			cfg.SMTP.Username,
+			cfg.SMTP.Password,
+			cfg.SMTP.From,
+			cfg.SMTP.To,
+		)
+		emailNotifier.SetTLS(cfg.SMTP.TLSMode, cfg.SMTP.InsecureSkipVerify)
+		emailNotifier.SetNotifyOnSuccess(cfg.SMTP.NotifyOnSuccess)
+		if err := emailNotifier.SetTemplate(cfg.SMTP.TemplatePath); err != nil {
+			slog.Warn("Error loading email template, using the built-in one", "error", err)
+		}
+		notifiers = append(notifiers, emailNotifier)
+	}
+
+	if cfg.Slack.WebhookURL != "" {
+		notifiers = append(notifiers, notifier.NewSlackNotifier(cfg.Slack.WebhookURL))
+	}
+
+	if cfg.WebhookNotify.URL != "" {
+		notifiers = append(notifiers, notifier.NewWebhookNotifier(cfg.WebhookNotify.URL, cfg.WebhookNotify.Headers))
+	}
+
+	if cfg.Telegram.BotToken != "" {
+		notifiers = append(notifiers, notifier.NewTelegramNotifier(cfg.Telegram.BotToken, cfg.Telegram.ChatID))
+	}
+
+	return notifiers
+}
+
+// Reload replaces the configuration used for new webhooks and deployments.
+// Deployments already running finish with the configuration they started
+// with. The listen address, TLS settings and deployment concurrency only
+// change on restart.
+func (h *Handler) Reload(cfg *config.Config) {
+	notifiers := newNotifiers(cfg)
+
+	h.configMu.Lock()
+	defer h.configMu.Unlock()
+
+	// Keep the cached IP ranges if the allowlist stays enabled
+	switch {
+	case !cfg.Server.RestrictToGitHubIPs:
+		h.allowlist = nil
+	case h.allowlist == nil:
+		h.allowlist = newIPAllowlist()
+	}
+	// Keep the history store, which serializes writes to its file, unless
+	// its settings changed
+	if cfg.Server.GetHistoryFile() != h.config.Server.GetHistoryFile() || cfg.Server.HistoryRetention != h.config.Server.HistoryRetention {
+		h.history = cfg.Server.NewHistoryStore()
+	}
+	h.config = cfg
+	h.notifiers = notifiers
+
+	// The app credentials may have changed
+	h.appClientsMu.Lock()
+	h.appClients = make(map[int64]*github.AppClient)
+	h.appClientsMu.Unlock()
+}
+
+// currentConfig returns the configuration in effect
+func (h *Handler) currentConfig() *config.Config {
+	h.configMu.RLock()
+	defer h.configMu.RUnlock()
+	return h.config
+}
+
+// currentNotifiers returns the notifiers of the configuration in effect
+func (h *Handler) currentNotifiers() []notifier.Notifier {
+	h.configMu.RLock()
+	defer h.configMu.RUnlock()
+	return h.notifiers
+}
+
+// currentHistory returns the store deployments are recorded in
+func (h *Handler) currentHistory() history.Store {
+	h.configMu.RLock()
+	defer h.configMu.RUnlock()
+	return h.history
+}
+
+// currentAllowlist returns the IP allowlist in effect, nil if disabled
+func (h *Handler) currentAllowlist() *ipAllowlist {
+	h.configMu.RLock()
+	defer h.configMu.RUnlock()
+	return h.allowlist
+}
+
 // ServeHTTP handles incoming webhook requests
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -47,128 +272,1209 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Read body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Error reading request body: %v", err)
-		http.Error(w, "Bad request", http.StatusBadRequest)
+	// Tag every log line and notification caused by the delivery with its
+	// ID, so a webhook can be traced through the logs
+	provider := providerFor(r.Header)
+	deliveryID := provider.DeliveryID(r.Header)
+	if deliveryID == "" {
+		deliveryID = newDeliveryID()
+	}
+	logger := h.logger.With("delivery", deliveryID)
+
+	// Reject requests from outside GitHub's webhook IP ranges
+	if allowlist := h.currentAllowlist(); allowlist != nil {
+		ip := clientIP(r, h.currentConfig().Server.TrustProxy)
+		if ip == nil || !allowlist.Allowed(ip) {
+			logger.Warn("Rejected webhook from non-GitHub address", "event", "webhook_rejected", "ip", ip.String())
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	// Never accept webhooks without a secret: any signature computed with
+	// an empty key would be valid. Validation should have caught this.
+	if h.currentConfig().GitHub.WebhookSecret == "" {
+		logger.Error("No webhook secret configured, rejecting webhook", "event", "webhook_rejected")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	body, ok := h.readBody(logger, w, r)
+	if !ok {
 		return
 	}
-	defer r.Body.Close()
 
 	// Verify signature
-	signature := r.Header.Get("X-Hub-Signature-256")
-	if !h.verifySignature(body, signature) {
-		log.Printf("Invalid webhook signature")
+	if !h.verifySignature(provider, body, r.Header) {
+		logger.Warn("Invalid webhook signature", "event", "webhook_rejected", "provider", provider.Name())
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	// Parse event type
-	eventType := r.Header.Get("X-GitHub-Event")
-	if eventType != "push" {
-		// We only care about push events
+	eventType := provider.Event(r.Header)
+	webhookRequestsTotal.WithLabelValues(eventType).Inc()
+	if eventType == "ping" {
+		h.handlePing(logger, w, body)
+		return
+	}
+
+	// A redelivery (e.g. after GitHub timed out waiting for the response)
+	// would deploy the same push twice
+	if h.seenDelivery(provider.Name(), provider.DeliveryID(r.Header)) {
+		logger.Info("Ignoring redelivered webhook", "event", "webhook_duplicate", "provider", provider.Name())
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "Duplicate delivery")
+		return
+	}
+
+	pushes, err := provider.Parse(r.Header, body)
+	if err != nil {
+		logger.Error("Error parsing webhook", "provider", provider.Name(), "error", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	if len(pushes) == 0 {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	// Parse push event
-	var pushEvent PushEvent
-	if err := json.Unmarshal(body, &pushEvent); err != nil {
-		log.Printf("Error parsing push event: %v", err)
+	for _, push := range pushes {
+		push.Event.DeliveryID = deliveryID
+		push.Event.Provider = provider.Name()
+	}
+	h.accept(w, pushes)
+}
+
+// readBody reads the body of a request, refusing oversized ones instead of
+// buffering them. On failure the error response is written and ok is false.
+func (h *Handler) readBody(logger *slog.Logger, w http.ResponseWriter, r *http.Request) (body []byte, ok bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.currentConfig().Server.GetMaxBodyBytes())
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			logger.Warn("Webhook body too large", "event", "webhook_rejected", "limit", maxBytesErr.Limit)
+			http.Error(w, "Request entity too large", http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		logger.Error("Error reading request body", "error", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return nil, false
+	}
+
+	return body, true
+}
+
+// accept processes pushes in the background and answers 202 Accepted.
+// Their deployments wait for a free slot, so the response tells how many
+// deployments are queued before them.
+func (h *Handler) accept(w http.ResponseWriter, pushes []Push) {
+	position := h.waiting.Load() + 1
+	for _, push := range pushes {
+		h.deployments.Add(1)
+		go func(push Push) {
+			defer h.deployments.Done()
+			h.processPushEvent(push.Event, push.Trigger)
+		}(push)
+	}
+
+	w.Header().Set("X-Queue-Position", strconv.FormatInt(position, 10))
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Accepted")
+}
+
+// newDeliveryID returns a short random ID for deliveries without a
+// X-GitHub-Delivery header
+func newDeliveryID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// eventLogger returns the logger for everything done on behalf of an event
+func (h *Handler) eventLogger(event *PushEvent) *slog.Logger {
+	return h.logger.With("delivery", event.DeliveryID)
+}
+
+// handlePing answers GitHub's ping event, sent when a webhook is created,
+// with the number of watched folders for the repository (or all watched
+// folders for app-wide webhooks) so the setup can be verified
+func (h *Handler) handlePing(logger *slog.Logger, w http.ResponseWriter, body []byte) {
+	var ping struct {
+		Repository *Repository `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &ping); err != nil {
+		logger.Error("Error parsing ping event", "error", err)
 		http.Error(w, "Bad request", http.StatusBadRequest)
 		return
 	}
 
-	// Process the push event
-	go h.processPushEvent(&pushEvent)
+	cfg := h.currentConfig()
+	watched := len(cfg.Folders)
+	if ping.Repository != nil {
+		watched = 0
+		for _, folder := range cfg.Folders {
+			if folder.MatchesRepo(ping.Repository.FullName, ping.Repository.CloneURL) {
+				watched++
+			}
+		}
+	}
+
+	logger.Info("Received ping", "event", "ping", "watched_folders", watched)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":          "pong",
+		"watched_folders": watched,
+	})
+}
+
+// Wait blocks until all running deployments have finished or the context
+// is done
+func (h *Handler) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.deployments.Wait()
+		close(done)
+	}()
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "OK")
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-// verifySignature verifies the GitHub webhook signature
-func (h *Handler) verifySignature(payload []byte, signature string) bool {
-	if signature == "" {
+// verifySignature verifies the signature of a request the way its provider
+// signs webhooks, using the configured webhook secret
+func (h *Handler) verifySignature(provider Provider, payload []byte, header http.Header) bool {
+	secret := []byte(h.currentConfig().GitHub.WebhookSecret)
+	if len(secret) == 0 {
 		return false
 	}
 
-	// Remove "sha256=" prefix
-	signature = strings.TrimPrefix(signature, "sha256=")
+	return provider.Verify(secret, header, payload)
+}
 
-	// Compute HMAC
-	mac := hmac.New(sha256.New, []byte(h.config.GitHub.WebhookSecret))
+// checkMAC compares a hex-encoded signature with the HMAC of the payload in
+// constant time
+func checkMAC(newHash func() hash.Hash, secret, payload []byte, signature string) bool {
+	mac := hmac.New(newHash, secret)
 	mac.Write(payload)
 	expectedMAC := hex.EncodeToString(mac.Sum(nil))
 
 	return hmac.Equal([]byte(signature), []byte(expectedMAC))
 }
 
-// processPushEvent processes a push event
-func (h *Handler) processPushEvent(event *PushEvent) {
-	log.Printf("Processing push event for %s, branch: %s", event.Repository.FullName, event.Ref)
+// processPushEvent deploys every watched folder matching a push event in
+// parallel, bounded by the deployment slots. The trigger tells whether the
+// event is a branch push, a tag push or a release.
+func (h *Handler) processPushEvent(event *PushEvent, trigger string) {
+	logger := h.eventLogger(event)
+	logger.Info("Processing event", "event", trigger, "repo", event.Repository.FullName, "ref", event.Ref)
 
-	// Extract branch name from ref (refs/heads/main -> main)
-	branch := strings.TrimPrefix(event.Ref, "refs/heads/")
+	// There is nothing to deploy once the branch or tag is gone
+	if event.IsDeletion() {
+		logger.Info("Ignoring deletion", "repo", event.Repository.FullName, "ref", event.Ref)
+		return
+	}
 
-	// Find matching watched folders
-	for _, folder := range h.config.Folders {
-		// Check if repository URL matches
-		if !git.CompareURLs(folder.RepoURL, event.Repository.CloneURL) {
+	// Find the watched folders of the repository
+	folders, err := h.currentConfig().GetWatchersByRepo(event.Repository.FullName, event.Repository.CloneURL)
+	if err != nil {
+		logger.Info("Ignoring event", "repo", event.Repository.FullName, "reason", err)
+		return
+	}
+
+	var matched []config.WatchedFolder
+	for _, folder := range folders {
+		if !folder.IsEnabled() {
+			logger.Info("Skipping disabled folder", "folder", folder.Path)
+			continue
+		}
+
+		// Check if the folder deploys on this kind of event
+		if folder.GetTrigger() != trigger {
 			continue
 		}
 
 		// Check if branch matches
-		if folder.Branch != branch {
-			log.Printf("Branch mismatch for %s: expected %s, got %s", folder.Path, folder.Branch, branch)
+		if trigger == config.TriggerBranch && !folder.MatchesBranch(event.Branch()) {
+			logger.Info("Branch mismatch", "folder", folder.Path, "expected", folder.Branch, "branch", event.Branch())
 			continue
 		}
 
-		log.Printf("Matched folder: %s", folder.Path)
+		// Skip pushes that only touch files outside the folder's path filters.
+		// Events without a commit list (e.g. releases) always deploy.
+		if files := event.ChangedFiles(); len(files) > 0 && !folder.MatchesPaths(files) {
+			logger.Info("No changes matching path filters, skipping", "folder", folder.Path)
+			continue
+		}
 
-		// Process the update
-		if err := h.processUpdate(&folder); err != nil {
-			log.Printf("Error processing update for %s: %v", folder.Path, err)
-			// Send failure notification
-			if err := h.notifier.SendFailureNotification(folder.Path, branch, err.Error()); err != nil {
-				log.Printf("Error sending failure notification: %v", err)
+		if !folder.AllowsPusher(event.PusherNames()) {
+			logger.Warn("Pusher not allowed to deploy, skipping", "folder", folder.Path, "pusher", strings.Join(event.PusherNames(), ","))
+			if folder.NotifyRejectedPushers {
+				h.notifyRejectedPush(logger, folder, event)
 			}
+			continue
+		}
+
+		logger.Info("Matched folder", "folder", folder.Path, "repo", event.Repository.FullName, "branch", event.RefName())
+		matched = append(matched, *folder)
+	}
+
+	// Failures of several folders are notified together
+	if len(matched) > 1 {
+		event.batch = &deployBatch{pending: len(matched)}
+	}
+
+	var wg sync.WaitGroup
+	for _, folder := range matched {
+		wg.Add(1)
+		go func(folder config.WatchedFolder) {
+			defer wg.Done()
+			h.enqueueDeploy(folder, event)
+		}(folder)
+	}
+	wg.Wait()
+}
+
+// notifyRejectedPush sends a failure notification about a push that would
+// have deployed a folder but was made by a user not in its allowed pushers
+func (h *Handler) notifyRejectedPush(logger *slog.Logger, folder *config.WatchedFolder, event *PushEvent) {
+	pusher := "an unknown user"
+	if names := event.PusherNames(); len(names) > 0 {
+		pusher = strings.Join(names, "/")
+	}
+
+	d := notifier.Deployment{
+		RepoPath:   folder.Path,
+		Branch:     event.RefName(),
+		DeliveryID: event.DeliveryID,
+	}
+	err := fmt.Errorf("push of %s by %s was not deployed: only %s may deploy this folder", event.After, pusher, strings.Join(folder.AllowedPushers, ", "))
+	h.notifyFailure(logger, folder, d, err)
+}
+
+// deployFolder runs a deployment of a folder for a push event and reports
+// the result through notifications and commit statuses
+func (h *Handler) deployFolder(folder *config.WatchedFolder, event *PushEvent) {
+	logger := h.eventLogger(event)
+	branch := event.RefName()
+	if event.batch != nil {
+		defer h.finishBatch(logger, event.batch)
+	}
+
+	// In dry-run mode only log what would happen: no commit status,
+	// notification or recorded result
+	if h.currentConfig().Server.DryRun {
+		plan, _, _ := h.processUpdate(context.Background(), folder, event, true)
+		for i, step := range strings.Split(strings.TrimSpace(plan), "\n") {
+			logger.Info("Dry run", "folder", folder.Path, "step", i+1, "action", step)
+		}
+		logger.Info("Dry run, nothing was deployed", "folder", folder.Path, "branch", branch)
+		h.sendResult(folder.Path, DeployStatus{LastDeploy: time.Now(), LastResult: ResultSuccess, LastCommit: event.After})
+		return
+	}
+
+	h.reportStatus(folder, event, github.StatusPending, "Deployment in progress")
+
+	// Process the update
+	start := time.Now()
+	output, commit, err := h.processUpdate(context.Background(), folder, event, false)
+	status := newDeployStatus(event, start, commit, err)
+	deployment := notifier.Deployment{
+		RepoPath:   folder.Path,
+		Branch:     branch,
+		Commit:     commit,
+		DeliveryID: event.DeliveryID,
+	}
+	deployment.LogPath = h.writeDeployLog(logger, folder, event, start, status, output, err)
+
+	if err != nil {
+		deployment.Output = notifier.LastLines(failureOutput(output, err), notifier.OutputExcerptLines)
+		if event.batch != nil {
+			event.batch.fail(folder, deployment, err)
 		} else {
-			log.Printf("Successfully processed update for %s", folder.Path)
+			h.notifyFailure(logger, folder, deployment, err)
 		}
+		h.reportStatus(folder, event, github.StatusFailure, "Deployment failed")
+	} else {
+		h.resetThrottle(folder.Path)
+		h.notifySuccess(logger, folder, deployment, output)
+		h.reportStatus(folder, event, github.StatusSuccess, "Deployment succeeded")
 	}
+
+	attrs := []any{
+		"event", "deploy",
+		"repo", event.Repository.FullName,
+		"branch", branch,
+		"folder", folder.Path,
+		"result", status.LastResult,
+		"duration_ms", status.Duration.Milliseconds(),
+		"commit", status.LastCommit,
+	}
+	if err != nil {
+		logger.Error("Deployment failed", append(attrs, "error", err)...)
+	} else {
+		logger.Info("Deployment succeeded", attrs...)
+	}
+
+	deploysTotal.WithLabelValues(status.LastResult).Inc()
+	deployDuration.Observe(status.Duration.Seconds())
+	h.recordStatus(folder.Path, status)
+	h.recordHistory(logger, folder, event, status, deployment.LogPath)
+
+	h.sendResult(folder.Path, status)
 }
 
-// processUpdate handles the git pull and command execution
-func (h *Handler) processUpdate(folder *config.WatchedFolder) error {
-	// Create git manager
-	gitMgr := git.NewManager(folder.Path)
+// newDeployStatus returns the status of a deployment started at start that
+// deployed commit or failed with err
+func newDeployStatus(event *PushEvent, start time.Time, commit *git.CommitInfo, err error) DeployStatus {
+	status := DeployStatus{
+		LastDeploy: start,
+		LastCommit: event.After,
+		Duration:   time.Since(start),
+		Commit:     commit,
+		LastResult: ResultSuccess,
+	}
+	if commit != nil {
+		status.LastCommit = commit.SHA
+	}
+	if err != nil {
+		status.LastResult = ResultFailure
+		if git.IsConflictError(err) {
+			status.LastResult = ResultConflict
+		}
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// recordHistory records a finished deployment in the deployment history
+func (h *Handler) recordHistory(logger *slog.Logger, folder *config.WatchedFolder, event *PushEvent, status DeployStatus, logPath string) {
+	// Manual deployments have no webhook payload naming the repository
+	repo := event.Repository.FullName
+	if repo == "" {
+		repo = folder.RepoSlug()
+	}
+	entry := history.Entry{
+		Time:       status.LastDeploy,
+		Folder:     folder.Path,
+		Repo:       repo,
+		Branch:     event.RefName(),
+		SHA:        status.LastCommit,
+		Result:     status.LastResult,
+		DurationMS: status.Duration.Milliseconds(),
+		Error:      status.Error,
+		LogPath:    logPath,
+		DeliveryID: event.DeliveryID,
+	}
+	if err := h.currentHistory().Record(entry); err != nil {
+		logger.Warn("Error recording deployment history", "folder", folder.Path, "error", err)
+	}
+}
+
+// writeDeployLog writes the full output of a deployment to a file in the
+// configured log directory and returns its path, or "" if deployment logs
+// are disabled or the file could not be written
+func (h *Handler) writeDeployLog(logger *slog.Logger, folder *config.WatchedFolder, event *PushEvent, start time.Time, status DeployStatus, output string, err error) string {
+	server := h.currentConfig().Server
+	if server.LogDir == "" {
+		return ""
+	}
+
+	var content strings.Builder
+	fmt.Fprintf(&content, "Folder: %s\n", folder.Path)
+	fmt.Fprintf(&content, "Branch: %s\n", event.RefName())
+	fmt.Fprintf(&content, "Commit: %s\n", status.LastCommit)
+	if event.DeliveryID != "" {
+		fmt.Fprintf(&content, "Delivery: %s\n", event.DeliveryID)
+	}
+	fmt.Fprintf(&content, "Started: %s\n", start.Format(time.RFC3339))
+	fmt.Fprintf(&content, "Duration: %s\n", status.Duration.Round(time.Millisecond))
+	fmt.Fprintf(&content, "Result: %s\n", status.LastResult)
+	if err != nil {
+		output = failureOutput(output, err)
+		fmt.Fprintf(&content, "Error: %s\n", err)
+	}
+	fmt.Fprintf(&content, "\n%s", output)
+
+	path, writeErr := logging.WriteDeployLog(server.LogDir, folder.Path, start, []byte(content.String()), server.GetLogRetention())
+	if writeErr != nil {
+		logger.Warn("Error writing deployment log", "folder", folder.Path, "error", writeErr)
+	}
+	return path
+}
+
+// failureOutput returns the output of a failed deployment: git's output if
+// a git command failed, the output of the commands otherwise
+func failureOutput(output string, err error) string {
+	var gitErr *git.GitError
+	if errors.As(err, &gitErr) {
+		return gitErr.Output
+	}
+	return output
+}
+
+// folderNotifiers returns the notifiers of the channels selected by the
+// folder, with the folder's email recipients when it has its own
+func (h *Handler) folderNotifiers(folder *config.WatchedFolder) []notifier.Notifier {
+	var notifiers []notifier.Notifier
+	for _, n := range h.currentNotifiers() {
+		if !folder.Notifies(notifierChannel(n)) {
+			continue
+		}
+		if email, ok := n.(*notifier.EmailNotifier); ok && folder.NotifyTo != "" {
+			n = email.WithRecipients(folder.NotifyTo)
+		}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers
+}
+
+// notifierChannel returns the channel name of a notifier as used by the
+// folder notification settings
+func notifierChannel(n notifier.Notifier) string {
+	switch n.(type) {
+	case *notifier.EmailNotifier:
+		return config.NotifyEmail
+	case *notifier.SlackNotifier:
+		return config.NotifySlack
+	case *notifier.WebhookNotifier:
+		return config.NotifyWebhook
+	case *notifier.TelegramNotifier:
+		return config.NotifyTelegram
+	}
+	return ""
+}
+
+// notifyFailure sends a failure notification to every notifier of the folder,
+// using the conflict variant when the update hit conflicts and the command
+// failure variant when a pre- or post-update command failed.
+// A failing notifier does not prevent the remaining ones from being tried.
+// Emails repeating the previous failure of the folder within the cooldown
+// are suppressed.
+func (h *Handler) notifyFailure(logger *slog.Logger, folder *config.WatchedFolder, d notifier.Deployment, err error) {
+	var cmdErr *executor.CommandError
+	isCommandFailure := errors.As(err, &cmdErr)
+	isConflict := git.IsConflictError(err)
+
+	for _, n := range h.folderNotifiers(folder) {
+		if _, isEmail := n.(*notifier.EmailNotifier); isEmail && h.throttleEmail(d.RepoPath, err.Error()) {
+			logger.Info("Suppressed repeated failure email", "folder", d.RepoPath)
+			continue
+		}
+
+		var notifyErr error
+		if isConflict {
+			notifyErr = n.SendConflictNotification(d, err.Error())
+		} else if isCommandFailure {
+			notifyErr = n.SendCommandFailureNotification(d, cmdErr.Command, err.Error())
+		} else {
+			notifyErr = n.SendFailureNotification(d, err.Error())
+		}
+		if notifyErr != nil {
+			logger.Error("Error sending failure notification", "notifier", fmt.Sprintf("%T", n), "error", notifyErr)
+		}
+	}
+}
 
-	// Pull latest changes
-	log.Printf("Pulling latest changes for %s", folder.Path)
-	if err := gitMgr.Pull(); err != nil {
-		return fmt.Errorf("git pull failed: %w", err)
+// notifySuccess sends a success notification to every notifier of the folder
+func (h *Handler) notifySuccess(logger *slog.Logger, folder *config.WatchedFolder, d notifier.Deployment, output string) {
+	for _, n := range h.folderNotifiers(folder) {
+		if err := n.SendSuccessNotification(d, strings.Join(folder.GetCommands(), "\n"), output); err != nil {
+			logger.Error("Error sending success notification", "notifier", fmt.Sprintf("%T", n), "error", err)
+		}
 	}
+}
 
-	// Execute post-update command
-	if folder.Command != "" {
-		log.Printf("Executing command for %s: %s", folder.Path, folder.Command)
-		exec := executor.NewExecutor(folder.Path)
-		output, err := exec.Execute(folder.Command)
+// Deploy runs the pull and post-update command for a folder synchronously
+// and returns the command output. Folders triggered by tags or releases
+// need the tag to deploy; folders deploying several branches deploy the
+// checked out branch. No notifications are sent, but the deployment is
+// recorded in the history and deploy log like webhook deployments. With
+// dryRun nothing is run or recorded and the output lists the steps that
+// would be taken.
+func (h *Handler) Deploy(folder *config.WatchedFolder, tag string, dryRun bool) (string, error) {
+	branch := folder.Branch
+	if folder.HasBranchPattern() && dryRun {
+		// Looking up the checked out branch runs git, which a dry run must
+		// not do; the steps refer to the checked out branch instead
+		branch = ""
+	} else if folder.HasBranchPattern() {
+		current, err := h.openRepo(context.Background(), h.logger, folder).GetCurrentBranch()
 		if err != nil {
-			return fmt.Errorf("command execution failed: %w", err)
+			return "", err
+		}
+		if !folder.MatchesBranch(current) {
+			return "", fmt.Errorf("checked out branch %s of %s does not match %s", current, folder.Path, folder.Branch)
 		}
-		log.Printf("Command output: %s", output)
+		branch = current
+	}
+
+	event := &PushEvent{Ref: "refs/heads/" + branch, DeliveryID: newDeliveryID()}
+	if folder.GetTrigger() != config.TriggerBranch {
+		if tag == "" {
+			return "", fmt.Errorf("folder %s is deployed on %ss, a tag is required", folder.Path, folder.GetTrigger())
+		}
+		event.Ref = "refs/tags/" + tag
+	}
+	if dryRun {
+		output, _, err := h.processUpdate(context.Background(), folder, event, true)
+		return output, err
+	}
+
+	logger := h.eventLogger(event)
+	start := time.Now()
+	output, commit, err := h.processUpdate(context.Background(), folder, event, false)
+	status := newDeployStatus(event, start, commit, err)
+	logPath := h.writeDeployLog(logger, folder, event, start, status, output, err)
+	h.recordHistory(logger, folder, event, status, logPath)
+	return output, err
+}
+
+// lockFolder acquires the deployment lock for a folder path and returns the
+// function releasing it
+func (h *Handler) lockFolder(path string) func() {
+	h.folderLocksMu.Lock()
+	lock, ok := h.folderLocks[path]
+	if !ok {
+		lock = &sync.Mutex{}
+		h.folderLocks[path] = lock
+	}
+	h.folderLocksMu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// processUpdate handles the git pull and command execution and returns the
+// command output and the deployed commit (nil if the update failed before
+// the repository was updated). Deployments of the same folder are
+// serialized; a deployment that arrives while another is running waits for
+// it to finish. Once the lock is held, the whole update must finish within
+// the configured deploy timeout, otherwise git and the commands are killed.
+func (h *Handler) processUpdate(ctx context.Context, folder *config.WatchedFolder, event *PushEvent, dryRun bool) (string, *git.CommitInfo, error) {
+	logger := h.eventLogger(event)
+	if dryRun {
+		return describeUpdate(folder, event), nil, nil
+	}
+
+	unlock := h.lockFolder(folder.Path)
+	defer unlock()
+
+	timeout := h.currentConfig().Server.GetDeployTimeout()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	output, commit, err := h.update(ctx, logger, folder, event)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("deployment timed out after %v: %w", timeout, err)
+	}
+	return output, commit, err
+}
+
+// update runs the steps of processUpdate under a context
+func (h *Handler) update(ctx context.Context, logger *slog.Logger, folder *config.WatchedFolder, event *PushEvent) (string, *git.CommitInfo, error) {
+	// Run the pre-pull command, aborting the deploy if it fails
+	if folder.PreCommand != "" {
+		logger.Info("Executing pre-command", "folder", folder.Path, "command", folder.PreCommand)
+		output, err := h.runCommand(ctx, logger, folder, folder.PreCommand, deployEnv(folder, event, event.After))
+		if err != nil {
+			return output, nil, fmt.Errorf("pre-command execution failed: %w", err)
+		}
+	}
+
+	repo := h.openRepo(ctx, logger, folder)
+
+	// Remember the current commit so a rollback can return to it
+	previousSHA, err := repo.GetHeadSHA()
+	if err != nil {
+		logger.Warn("Error getting current commit", "folder", folder.Path, "error", err)
+	}
+
+	if err := h.handleLocalChanges(logger, repo, folder, event.Branch()); err != nil {
+		return "", nil, err
+	}
+
+	var verifiedSHA string
+	if folder.RequireSignedCommits {
+		// Only check out the pushed commit once its signature is verified
+		verifiedSHA, err = h.updateVerified(logger, repo, folder, event)
+		if err != nil {
+			return "", nil, err
+		}
+	} else if tag := event.Tag(); tag != "" {
+		// Check out the pushed or released tag
+		logger.Info("Checking out tag", "folder", folder.Path, "tag", tag)
+		if err := h.checkoutTag(repo, folder, tag); err != nil {
+			return "", nil, fmt.Errorf("git checkout failed: %w", err)
+		}
+	} else if folder.PinToPushedSHA && event.After != "" {
+		// Deploy exactly the pushed commit, even if the branch has moved on
+		logger.Info("Checking out pushed commit", "folder", folder.Path, "branch", event.Branch(), "commit", event.After)
+		if err := h.checkoutSHA(repo, folder, event.After); err != nil {
+			return "", nil, fmt.Errorf("git checkout failed: %w", err)
+		}
+	} else {
+		// Folders deploying several branches switch to the pushed one first
+		if folder.HasBranchPattern() {
+			logger.Info("Checking out branch", "folder", folder.Path, "branch", event.Branch())
+			if err := h.checkoutBranch(repo, folder, event.Branch()); err != nil {
+				return "", nil, fmt.Errorf("git checkout failed: %w", err)
+			}
+		}
+
+		// Pull latest changes
+		logger.Info("Pulling latest changes", "folder", folder.Path, "branch", event.Branch())
+		if err := h.pull(repo, folder, event.Branch()); err != nil {
+			return "", nil, fmt.Errorf("git pull failed: %w", err)
+		}
+	}
+
+	if folder.UpdateSubmodules {
+		logger.Info("Updating submodules", "folder", folder.Path)
+		if err := h.updateSubmodules(repo, folder); err != nil {
+			return "", nil, fmt.Errorf("submodule update failed: %w", err)
+		}
+	}
+
+	commit, err := repo.GetHeadCommit()
+	if err != nil {
+		logger.Warn("Error getting deployed commit", "folder", folder.Path, "error", err)
+	}
+
+	// Only the verified commit may be deployed, not e.g. local commits the
+	// branch already had and the fast-forward kept
+	if folder.RequireSignedCommits && (commit == nil || !strings.HasPrefix(commit.SHA, strings.ToLower(verifiedSHA))) {
+		return "", commit, fmt.Errorf("checked out commit is not the verified commit %s", verifiedSHA)
+	}
+	if commit != nil {
+		logger.Info("Deploying commit", "folder", folder.Path, "commit", commit.SHA, "subject", commit.Subject)
+	}
+
+	// The work directory may only exist in the pulled commit
+	if info, err := os.Stat(folder.GetWorkDir()); err != nil || !info.IsDir() {
+		return "", commit, fmt.Errorf("work directory %s does not exist after updating", folder.GetWorkDir())
+	}
+
+	// Execute post-update commands in order, stopping at the first failure
+	sha := event.After
+	if commit != nil {
+		sha = commit.SHA
+	}
+	env := deployEnv(folder, event, sha)
+	commands := folder.GetCommands()
+	var output strings.Builder
+	for i, command := range commands {
+		logger.Info("Executing command", "folder", folder.Path, "step", i+1, "steps", len(commands), "command", command)
+		commandOutput, err := h.runCommand(ctx, logger, folder, command, env)
+		output.WriteString(commandOutput)
+		if err != nil {
+			err = fmt.Errorf("command %d of %d (%s) failed: %w", i+1, len(commands), command, err)
+			return output.String(), commit, h.rollback(ctx, logger, folder, env, previousSHA, err)
+		}
+	}
+
+	return output.String(), commit, nil
+}
+
+// describeUpdate returns the steps processUpdate would take for an event,
+// one per line
+func describeUpdate(folder *config.WatchedFolder, event *PushEvent) string {
+	var steps []string
+	if folder.PreCommand != "" {
+		steps = append(steps, "Run pre-command: "+folder.PreCommand)
+	}
+	switch folder.GetDirtyStrategy() {
+	case config.DirtyStash:
+		steps = append(steps, "Stash local changes, if any")
+	case config.DirtyReset:
+		steps = append(steps, "Discard local changes, if any")
+	}
+	if folder.RequireSignedCommits {
+		steps = append(steps, describeVerifiedUpdate(folder, event)...)
+	} else if tag := event.Tag(); tag != "" {
+		steps = append(steps, "Check out tag "+tag)
+	} else if folder.PinToPushedSHA && event.After != "" {
+		steps = append(steps, "Check out commit "+event.After+" of branch "+event.Branch())
+	} else {
+		step := "Pull branch " + event.Branch() + " from origin"
+		if event.Branch() == "" {
+			// Manual dry run of a folder deploying several branches
+			step = "Pull the checked out branch from origin if it matches " + folder.Branch
+		} else if folder.HasBranchPattern() {
+			steps = append(steps, "Check out branch "+event.Branch())
+		}
+		if folder.PullStrategy != "" {
+			step += " (" + folder.PullStrategy + ")"
+		}
+		steps = append(steps, step)
+	}
+	if folder.UpdateSubmodules {
+		steps = append(steps, "Update submodules")
+	}
+	if folder.WorkDir != "" {
+		steps = append(steps, "Change to directory "+folder.GetWorkDir())
+	}
+	commands := folder.GetCommands()
+	for i, command := range commands {
+		steps = append(steps, fmt.Sprintf("Run command %d of %d: %s", i+1, len(commands), command))
+	}
+	if folder.RollbackCommand != "" {
+		steps = append(steps, "On command failure, run rollback command: "+folder.RollbackCommand)
+	}
+
+	return strings.Join(steps, "\n") + "\n"
+}
+
+// describeVerifiedUpdate returns the steps updateVerified would take for an
+// event
+func describeVerifiedUpdate(folder *config.WatchedFolder, event *PushEvent) []string {
+	if tag := event.Tag(); tag != "" {
+		return []string{
+			"Fetch tag " + tag + " from origin",
+			"Verify the commit signature, stopping if it is not trusted",
+			"Check out the verified commit of tag " + tag,
+		}
+	}
+	if folder.PinToPushedSHA && event.After != "" {
+		return []string{
+			"Fetch branch " + event.Branch() + " from origin",
+			"Verify the signature of commit " + event.After + ", stopping if it is not trusted",
+			"Check out commit " + event.After,
+		}
+	}
+
+	steps := []string{
+		"Fetch branch " + event.Branch() + " from origin",
+		"Verify the commit signature, stopping if it is not trusted",
+	}
+	if folder.HasBranchPattern() {
+		steps = append(steps, "Check out branch "+event.Branch())
+	}
+	return append(steps, "Fast-forward to the verified commit")
+}
+
+// appClient returns a GitHub App client for the folder's installation
+// (falling back to the app-wide installation), or nil if none is configured
+// or the folder's repository is not on github.com, e.g. on GitLab
+func (h *Handler) appClient(folder *config.WatchedFolder) (*github.AppClient, error) {
+	if !git.IsGitHubURL(folder.RepoURL) {
+		return nil, nil
+	}
+
+	cfg := h.currentConfig()
+	installationID := folder.InstallationID
+	if installationID == 0 {
+		installationID = cfg.GitHub.InstallationID
+	}
+	if installationID == 0 {
+		return nil, nil
+	}
+
+	h.appClientsMu.Lock()
+	defer h.appClientsMu.Unlock()
+
+	if appClient, ok := h.appClients[installationID]; ok {
+		return appClient, nil
+	}
+
+	appClient, err := github.NewAppClient(cfg.GitHub.AppID, cfg.GitHub.PrivateKeyPath, installationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GitHub App client: %w", err)
+	}
+	h.appClients[installationID] = appClient
+
+	return appClient, nil
+}
+
+// handleLocalChanges applies the folder's dirty strategy when the working
+// tree has local modifications that could make the update fail. A reset
+// discards them by resetting to the pushed branch on origin.
+func (h *Handler) handleLocalChanges(logger *slog.Logger, repo git.Repo, folder *config.WatchedFolder, branch string) error {
+	strategy := folder.GetDirtyStrategy()
+	if strategy == config.DirtyFail {
+		return nil
+	}
+
+	dirty, err := repo.IsDirty()
+	if err != nil {
+		return err
+	}
+	if !dirty {
+		return nil
+	}
+
+	switch strategy {
+	case config.DirtyStash:
+		logger.Info("Stashing local changes", "folder", folder.Path)
+		return repo.StashChanges()
+	case config.DirtyReset:
+		logger.Info("Discarding local changes", "folder", folder.Path)
+		// Reset to the pushed branch, or to HEAD when deploying a tag or
+		// when the pushed branch is not checked out yet
+		if current, err := repo.GetCurrentBranch(); err != nil || current != branch {
+			branch = ""
+		}
+		return repo.HardReset(branch)
 	}
 
 	return nil
 }
 
-// PushEvent represents a GitHub push event
+// updateVerified fetches the commit to deploy for an event, verifies its
+// signature and only then checks it out: a tag or pinned commit is checked
+// out detached, a branch is fast-forwarded to the fetched commit. A commit
+// not signed by a trusted key is never checked out. It returns the SHA of
+// the verified commit.
+func (h *Handler) updateVerified(logger *slog.Logger, repo git.Repo, folder *config.WatchedFolder, event *PushEvent) (string, error) {
+	tag := event.Tag()
+	ref := "refs/heads/" + event.Branch()
+	if tag != "" {
+		ref = "refs/tags/" + tag
+	}
+
+	logger.Info("Fetching commit to verify", "folder", folder.Path, "ref", ref)
+	sha, err := h.fetchCommit(repo, folder, ref)
+	if err != nil {
+		return "", fmt.Errorf("git fetch failed: %w", err)
+	}
+	pinned := tag == "" && folder.PinToPushedSHA && event.After != ""
+	if pinned {
+		// Fetching the branch fetched the pushed commit too, even if the
+		// branch has moved on since
+		sha = event.After
+	}
+
+	logger.Info("Verifying commit signature", "folder", folder.Path, "commit", sha)
+	if err := repo.VerifyCommitSignature(sha); err != nil {
+		return "", fmt.Errorf("commit %s is not signed by a trusted key: %w", sha, err)
+	}
+
+	if tag != "" || pinned {
+		logger.Info("Checking out verified commit", "folder", folder.Path, "ref", event.RefName(), "commit", sha)
+		if err := h.checkoutSHA(repo, folder, sha); err != nil {
+			return "", fmt.Errorf("git checkout failed: %w", err)
+		}
+		return sha, nil
+	}
+
+	if folder.HasBranchPattern() {
+		logger.Info("Checking out branch", "folder", folder.Path, "branch", event.Branch())
+		if err := h.checkoutBranch(repo, folder, event.Branch()); err != nil {
+			return "", fmt.Errorf("git checkout failed: %w", err)
+		}
+	}
+	logger.Info("Fast-forwarding to verified commit", "folder", folder.Path, "branch", event.Branch(), "commit", sha)
+	if err := repo.FastForward(sha); err != nil {
+		return "", fmt.Errorf("git fast-forward failed: %w", err)
+	}
+	return sha, nil
+}
+
+// rollback runs the folder's rollback command after a failed command and
+// returns the original error extended with the rollback result. It still
+// runs when the deployment timed out, limited only by the command timeout.
+func (h *Handler) rollback(ctx context.Context, logger *slog.Logger, folder *config.WatchedFolder, env []string, previousSHA string, cmdErr error) error {
+	if folder.RollbackCommand == "" {
+		return cmdErr
+	}
+
+	logger.Info("Executing rollback command", "folder", folder.Path, "command", folder.RollbackCommand)
+	env = append(append([]string{}, env...), "DEPLOY_PREVIOUS_SHA="+previousSHA)
+	output, err := h.runCommand(context.WithoutCancel(ctx), logger, folder, folder.RollbackCommand, env)
+	if err != nil {
+		logger.Error("Rollback command failed", "folder", folder.Path, "error", err)
+		return fmt.Errorf("%w\n\nRollback command failed: %v", cmdErr, err)
+	}
+
+	return fmt.Errorf("%w\n\nRollback command succeeded. Output:\n%s", cmdErr, output)
+}
+
+// runCommand executes a shell command in the folder with its timeout and
+// extra environment variables, killing it once ctx is done. The output is
+// logged line by line as the command runs.
+func (h *Handler) runCommand(ctx context.Context, logger *slog.Logger, folder *config.WatchedFolder, command string, env []string) (string, error) {
+	exec := executor.NewExecutor(folder.GetWorkDir())
+	exec.SetShell(folder.GetShell())
+	exec.SetTimeout(time.Duration(folder.Timeout) * time.Second)
+	exec.SetKillGracePeriod(folder.GetKillGracePeriod())
+	exec.SetEnv(env)
+	exec.SetUser(folder.RunAsUser)
+	exec.SetMaxOutput(h.currentConfig().Server.GetMaxOutputBytes())
+	exec.SetLogger(logger.With("folder", folder.Path))
+	exec.SetRunner(h.commandRunner)
+	return exec.ExecuteContext(ctx, command)
+}
+
+// deployEnv returns the environment of the folder's commands: the folder's
+// own variables plus DEPLOY_BRANCH, DEPLOY_REPO and DEPLOY_SHA describing
+// the deployment, which take precedence. Values from the webhook are only
+// ever passed this way, never interpolated into the commands, so a branch
+// named e.g. "main;reboot" cannot inject shell commands.
+func deployEnv(folder *config.WatchedFolder, event *PushEvent, sha string) []string {
+	env := folder.EnvList()
+
+	repo := event.Repository.FullName
+	if repo == "" {
+		// Manual deployments have no event repository
+		repo = folder.RepoURL
+	}
+
+	return append(env,
+		"DEPLOY_BRANCH="+event.RefName(),
+		"DEPLOY_REPO="+repo,
+		"DEPLOY_SHA="+sha,
+	)
+}
+
+// gitToken returns a GitHub App installation token for the folder, or an
+// empty string when no installation is configured or the folder's
+// repository is not on github.com
+func (h *Handler) gitToken(folder *config.WatchedFolder) (string, error) {
+	appClient, err := h.appClient(folder)
+	if err != nil || appClient == nil {
+		return "", err
+	}
+
+	return appClient.GetInstallationToken()
+}
+
+// pull updates the repository from a branch on origin, authenticating with
+// a fresh GitHub App installation token when an installation is configured
+func (h *Handler) pull(repo git.Repo, folder *config.WatchedFolder, branch string) error {
+	token, err := h.gitToken(folder)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return repo.FetchAndPull(branch)
+	}
+
+	return repo.FetchAndPullWithToken(branch, token)
+}
+
+// checkoutBranch switches to a branch, authenticating like pull
+func (h *Handler) checkoutBranch(repo git.Repo, folder *config.WatchedFolder, branch string) error {
+	token, err := h.gitToken(folder)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return repo.CheckoutBranch(branch)
+	}
+
+	return repo.CheckoutBranchWithToken(branch, token)
+}
+
+// checkoutTag checks out a tag, authenticating like pull
+func (h *Handler) checkoutTag(repo git.Repo, folder *config.WatchedFolder, tag string) error {
+	token, err := h.gitToken(folder)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return repo.CheckoutTag(tag)
+	}
+
+	return repo.CheckoutTagWithToken(tag, token)
+}
+
+// checkoutSHA checks out a commit, authenticating like pull
+func (h *Handler) checkoutSHA(repo git.Repo, folder *config.WatchedFolder, sha string) error {
+	token, err := h.gitToken(folder)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return repo.CheckoutSHA(sha)
+	}
+
+	return repo.CheckoutSHAWithToken(sha, token)
+}
+
+// fetchCommit fetches a ref and returns its commit, authenticating like pull
+func (h *Handler) fetchCommit(repo git.Repo, folder *config.WatchedFolder, ref string) (string, error) {
+	token, err := h.gitToken(folder)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return repo.FetchCommit(ref)
+	}
+
+	return repo.FetchCommitWithToken(ref, token)
+}
+
+// updateSubmodules updates the submodules, authenticating like pull
+func (h *Handler) updateSubmodules(repo git.Repo, folder *config.WatchedFolder) error {
+	token, err := h.gitToken(folder)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return repo.UpdateSubmodules()
+	}
+
+	return repo.UpdateSubmodulesWithToken(token)
+}
+
+// reportStatus sets the commit status of the pushed commit on GitHub when
+// status reporting is enabled and GitHub sent the push. Errors are logged
+// and never fail the deploy.
+func (h *Handler) reportStatus(folder *config.WatchedFolder, event *PushEvent, state, description string) {
+	if !h.currentConfig().GitHub.ReportStatus || (event.Provider != "" && event.Provider != ProviderGitHub) {
+		return
+	}
+	logger := h.eventLogger(event)
+
+	appClient, err := h.appClient(folder)
+	if err != nil {
+		logger.Error("Error reporting commit status", "folder", folder.Path, "error", err)
+		return
+	}
+	if appClient == nil {
+		logger.Warn("Cannot report commit status: no GitHub App installation configured", "folder", folder.Path)
+		return
+	}
+
+	reporter := github.NewStatusReporter(appClient)
+	if err := reporter.SetStatus(event.Repository.FullName, event.After, state, description); err != nil {
+		logger.Error("Error reporting commit status", "folder", folder.Path, "error", err)
+	}
+}
+
+// PushEvent represents a GitHub push event. Webhooks of other providers are
+// converted to it.
 type PushEvent struct {
-	Ref        string `json:"ref"`
-	Repository struct {
-		FullName string `json:"full_name"`
-		CloneURL string `json:"clone_url"`
-	} `json:"repository"`
+	Ref        string     `json:"ref"`
+	After      string     `json:"after"`   // SHA of the head commit after the push
+	Deleted    bool       `json:"deleted"` // The push deleted the branch or tag
+	Repository Repository `json:"repository"`
+	Commits    []Commit   `json:"commits"`
+	Pusher     Pusher     `json:"pusher"` // User who pushed
+	Sender     Sender     `json:"sender"` // Account that triggered the webhook
+
+	// DeliveryID identifies the webhook delivery in logs and notifications
+	DeliveryID string `json:"-"`
+
+	// Provider is the name of the provider that sent the webhook, empty
+	// for manual deployments
+	Provider string `json:"-"`
+
+	// batch collects the failures of the folders deployed for the push
+	// when it matched several, nil otherwise
+	batch *deployBatch
+}
+
+// Pusher is the user who pushed. GitHub sends the login as name, Gitea and
+// Forgejo as login.
+type Pusher struct {
+	Name  string `json:"name"`
+	Login string `json:"login"`
+}
+
+// Sender is the account that triggered a webhook
+type Sender struct {
+	Login string `json:"login"`
+}
+
+// PusherNames returns the distinct user names the provider gave for who
+// pushed, empty if unknown (e.g. for the /deploy endpoint)
+func (e *PushEvent) PusherNames() []string {
+	var names []string
+	for _, name := range []string{e.Pusher.Name, e.Pusher.Login, e.Sender.Login} {
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// IsDeletion reports whether the push deleted its branch or tag. Providers
+// without a deleted flag set the SHA after the push to all zeros.
+func (e *PushEvent) IsDeletion() bool {
+	return e.Deleted || (e.After != "" && strings.Trim(e.After, "0") == "")
+}
+
+// Commit is a commit included in a push event
+type Commit struct {
+	ID       string   `json:"id"`
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Removed  []string `json:"removed"`
+}
+
+// Repository identifies the repository of a webhook event
+type Repository struct {
+	FullName string `json:"full_name"`
+	CloneURL string `json:"clone_url"`
+}
+
+// Branch returns the pushed branch name (refs/heads/main -> main), or an
+// empty string if a tag was pushed
+func (e *PushEvent) Branch() string {
+	if !strings.HasPrefix(e.Ref, "refs/heads/") {
+		return ""
+	}
+	return strings.TrimPrefix(e.Ref, "refs/heads/")
+}
+
+// Tag returns the pushed tag name (refs/tags/v1.0 -> v1.0), or an empty
+// string if a branch was pushed
+func (e *PushEvent) Tag() string {
+	if !strings.HasPrefix(e.Ref, "refs/tags/") {
+		return ""
+	}
+	return strings.TrimPrefix(e.Ref, "refs/tags/")
+}
+
+// ChangedFiles returns the files added, modified or removed by the pushed
+// commits
+func (e *PushEvent) ChangedFiles() []string {
+	var files []string
+	for _, commit := range e.Commits {
+		files = append(files, commit.Added...)
+		files = append(files, commit.Modified...)
+		files = append(files, commit.Removed...)
+	}
+	return files
+}
+
+// RefName returns the short name of the pushed branch or tag
+func (e *PushEvent) RefName() string {
+	if tag := e.Tag(); tag != "" {
+		return tag
+	}
+	return e.Branch()
+}
+
+// ReleaseEvent represents a GitHub release event
+type ReleaseEvent struct {
+	Action  string `json:"action"`
+	Release struct {
+		TagName string `json:"tag_name"`
+	} `json:"release"`
+	Repository Repository `json:"repository"`
+	Sender     Sender     `json:"sender"`
+}
+
+// toPushEvent converts a release into the equivalent tag push
+func (e *ReleaseEvent) toPushEvent() PushEvent {
+	return PushEvent{
+		Ref:        "refs/tags/" + e.Release.TagName,
+		Repository: e.Repository,
+		Sender:     e.Sender,
+	}
 }
@@ -0,0 +1,744 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/git"
+	"github.com/eliasfloreteng/github-auto-deployer/internal/notifier"
+)
+
+const testSecret = "test-secret"
+
+// sign returns the hex HMAC of body with the secret
+func sign(newHash func() hash.Hash, secret, body string) string {
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newWebhookRequest returns a GitHub webhook request of an event, signed
+// with HMAC-SHA256 of the test secret
+func newWebhookRequest(event, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-GitHub-Delivery", "delivery-1")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+sign(sha256.New, testSecret, body))
+	return req
+}
+
+// newWebhookHandler returns a handler for folders using the test secret
+func newWebhookHandler(folders ...config.WatchedFolder) *Handler {
+	cfg := &config.Config{Folders: folders}
+	cfg.GitHub.WebhookSecret = testSecret
+	return NewHandler(cfg)
+}
+
+func TestNotifyFailureFansOut(t *testing.T) {
+	var slackRequests, webhookRequests atomic.Int32
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slackRequests.Add(1)
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer slack.Close()
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webhookRequests.Add(1)
+	}))
+	defer hook.Close()
+
+	cfg := &config.Config{}
+	cfg.Slack.WebhookURL = slack.URL
+	cfg.WebhookNotify.URL = hook.URL
+	h := NewHandler(cfg)
+
+	h.notifyFailure(h.logger, &config.WatchedFolder{Path: "/srv/app", Branch: "main"}, notifier.Deployment{RepoPath: "/srv/app", Branch: "main"}, errors.New("pull failed"))
+
+	if slackRequests.Load() != 1 {
+		t.Errorf("Slack received %d notifications, want 1", slackRequests.Load())
+	}
+	if webhookRequests.Load() != 1 {
+		t.Errorf("webhook received %d notifications after Slack failed, want 1", webhookRequests.Load())
+	}
+}
+
+// newClone creates a repository with one commit on main and a clone of it
+// tracking it, and returns the path of the clone
+func newClone(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	git := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com", "-c", "init.defaultBranch=main"}, args...)...)
+		cmd.Dir = dir
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+		}
+	}
+	git(dir, "init", "--quiet", "origin")
+	git(filepath.Join(dir, "origin"), "commit", "--quiet", "--allow-empty", "-m", "Initial commit")
+	git(dir, "clone", "--quiet", "origin", "clone")
+	return filepath.Join(dir, "clone")
+}
+
+func TestDeploymentsOfAFolderNeverOverlap(t *testing.T) {
+	// The command fails if another deployment of the folder is running
+	state := t.TempDir()
+	command := fmt.Sprintf("mkdir %[1]s/running || exit 1; sleep 0.05; rmdir %[1]s/running", state)
+	folder := config.WatchedFolder{Path: newClone(t), Branch: "main", Command: command}
+	h := NewHandler(&config.Config{Folders: []config.WatchedFolder{folder}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := h.processUpdate(context.Background(), &folder, &PushEvent{Ref: "refs/heads/main"}, false); err != nil {
+				t.Errorf("processUpdate returned error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPing(t *testing.T) {
+	h := newWebhookHandler(
+		config.WatchedFolder{Path: "/srv/app", Branch: "main", RepoURL: "git@github.com:acme/app.git"},
+		config.WatchedFolder{Path: "/srv/app-staging", Branch: "staging", RepoURL: "https://github.com/acme/app"},
+		config.WatchedFolder{Path: "/srv/api", Branch: "main", RepoURL: "https://github.com/acme/api"},
+	)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"repository webhook", `{"zen": "Design for failure.", "repository": {"clone_url": "https://github.com/acme/app.git"}}`, 2},
+		{"unwatched repository", `{"repository": {"clone_url": "https://github.com/acme/other.git"}}`, 0},
+		{"app webhook", `{"zen": "Design for failure.", "hook_id": 1}`, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, newWebhookRequest("ping", tt.body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			var pong struct {
+				Status         string `json:"status"`
+				WatchedFolders int    `json:"watched_folders"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&pong); err != nil {
+				t.Fatal(err)
+			}
+			if pong.Status != "pong" || pong.WatchedFolders != tt.want {
+				t.Errorf("response = %+v, want pong with %d folders", pong, tt.want)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newWebhookRequest("ping", `not json`))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid ping: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	body := `{"zen": "Keep it logically awesome."}`
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   int
+	}{
+		{"sha256", map[string]string{"X-Hub-Signature-256": "sha256=" + sign(sha256.New, testSecret, body)}, http.StatusOK},
+		{"sha1 only", map[string]string{"X-Hub-Signature": "sha1=" + sign(sha1.New, testSecret, body)}, http.StatusOK},
+		{"sha256 mismatch", map[string]string{"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "wrong-secret", body)}, http.StatusUnauthorized},
+		{"sha1 mismatch", map[string]string{"X-Hub-Signature": "sha1=" + sign(sha1.New, "wrong-secret", body)}, http.StatusUnauthorized},
+		{"sha256 preferred over sha1", map[string]string{
+			"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "wrong-secret", body),
+			"X-Hub-Signature":     "sha1=" + sign(sha1.New, testSecret, body),
+		}, http.StatusUnauthorized},
+		{"sha1 signature in the sha256 header", map[string]string{"X-Hub-Signature-256": "sha256=" + sign(sha1.New, testSecret, body)}, http.StatusUnauthorized},
+		{"unsigned", nil, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+			req.Header.Set("X-GitHub-Event", "ping")
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+
+			rec := httptest.NewRecorder()
+			newWebhookHandler().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptySecretRejected(t *testing.T) {
+	h := NewHandler(&config.Config{})
+
+	// A signature made with an empty key must not be accepted
+	body := `{"zen": "Keep it logically awesome."}`
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "ping")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+sign(sha256.New, "", body))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if h.verifySignature(githubProvider{}, []byte(body), req.Header) {
+		t.Error("verifySignature accepted a signature made with an empty secret")
+	}
+}
+
+func TestWebhookBodyLimit(t *testing.T) {
+	h := newWebhookHandler()
+	h.currentConfig().Server.MaxBodyBytes = 1024
+
+	small := `{"zen": "Keep it logically awesome."}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newWebhookRequest("ping", small))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status of a small body = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	large := `{"zen": "` + strings.Repeat("a", 2048) + `"}`
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, newWebhookRequest("ping", large))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status of an oversized body = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/deploy", strings.NewReader(large))
+	h.DeployHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status of an oversized deploy request = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestNotifiersSkipDisabledEmail(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Slack.WebhookURL = "https://hooks.slack.com/services/T/B/X"
+
+	notifiers := newNotifiers(cfg)
+	if len(notifiers) != 1 {
+		t.Fatalf("%d notifiers, want only Slack", len(notifiers))
+	}
+	if _, ok := notifiers[0].(*notifier.SlackNotifier); !ok {
+		t.Errorf("notifier is %T, want *notifier.SlackNotifier", notifiers[0])
+	}
+
+	cfg.SMTP = config.SMTPConfig{Host: "smtp.example.com", Port: 587, From: "a@example.com", To: "b@example.com"}
+	if n := len(newNotifiers(cfg)); n != 2 {
+		t.Errorf("%d notifiers with email enabled, want 2", n)
+	}
+}
+
+func TestDeployDryRun(t *testing.T) {
+	// Not a git repository: any git call would fail the deployment
+	dir := t.TempDir()
+	marker := filepath.Join(t.TempDir(), "deployed")
+	folder := config.WatchedFolder{
+		Path:            dir,
+		Branch:          "main",
+		PreCommand:      "make stop",
+		Command:         "touch " + marker,
+		Commands:        []string{"make start"},
+		RollbackCommand: "make rollback",
+		PullStrategy:    "ff-only",
+		DirtyStrategy:   config.DirtyStash,
+	}
+	h := NewHandler(&config.Config{Folders: []config.WatchedFolder{folder}})
+
+	output, err := h.Deploy(&folder, "", true)
+	if err != nil {
+		t.Fatalf("Deploy returned error: %v", err)
+	}
+	want := "Run pre-command: make stop\n" +
+		"Stash local changes, if any\n" +
+		"Pull branch main from origin (ff-only)\n" +
+		"Run command 1 of 2: touch " + marker + "\n" +
+		"Run command 2 of 2: make start\n" +
+		"On command failure, run rollback command: make rollback\n"
+	if output != want {
+		t.Errorf("output = %q, want %q", output, want)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("dry run ran the command")
+	}
+}
+
+func TestDescribeUpdateTag(t *testing.T) {
+	folder := &config.WatchedFolder{Path: "/srv/app", Trigger: config.TriggerTag, Command: "make", UpdateSubmodules: true}
+	got := describeUpdate(folder, &PushEvent{Ref: "refs/tags/v1.2.0"})
+	want := "Check out tag v1.2.0\nUpdate submodules\nRun command 1 of 1: make\n"
+	if got != want {
+		t.Errorf("describeUpdate = %q, want %q", got, want)
+	}
+}
+
+func TestDeployFolderDryRunRecordsNothing(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "deployed")
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", Command: "touch " + marker}
+	cfg := &config.Config{Folders: []config.WatchedFolder{folder}}
+	cfg.Server.DryRun = true
+	h := NewHandler(cfg)
+
+	h.deployFolder(&folder, &PushEvent{Ref: "refs/heads/main", After: "abc123"})
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("dry run ran the command")
+	}
+	h.statusMu.Lock()
+	defer h.statusMu.Unlock()
+	if len(h.status) != 0 {
+		t.Errorf("dry run recorded a deployment status: %v", h.status)
+	}
+}
+
+func TestManualDeployWritesLog(t *testing.T) {
+	// Not a git repository: the pull fails, which is logged as well
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", Command: "echo deployed"}
+	cfg := &config.Config{Folders: []config.WatchedFolder{folder}}
+	cfg.Server.LogDir = t.TempDir()
+	h := NewHandler(cfg)
+
+	if _, err := h.Deploy(&folder, "", true); err != nil {
+		t.Fatalf("dry run returned error: %v", err)
+	}
+	if logs, _ := os.ReadDir(cfg.Server.LogDir); len(logs) != 0 {
+		t.Fatalf("dry run wrote %d deploy logs, want none", len(logs))
+	}
+
+	if _, err := h.Deploy(&folder, "", false); err == nil {
+		t.Fatal("Deploy of a folder that is not a git repository succeeded")
+	}
+	logs, err := os.ReadDir(cfg.Server.LogDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("%d deploy logs written, want 1", len(logs))
+	}
+	content, err := os.ReadFile(filepath.Join(cfg.Server.LogDir, logs[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Folder: " + folder.Path, "Branch: main", "Result: " + ResultFailure, "Error: "} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("deploy log does not contain %q:\n%s", want, content)
+		}
+	}
+}
+
+func TestDeployDryRunBranchPattern(t *testing.T) {
+	// Not a git repository: looking up the checked out branch would fail
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main,release/*", Command: "make", PullStrategy: "rebase"}
+	h := NewHandler(&config.Config{Folders: []config.WatchedFolder{folder}})
+
+	output, err := h.Deploy(&folder, "", true)
+	if err != nil {
+		t.Fatalf("Deploy returned error: %v", err)
+	}
+	want := "Pull the checked out branch from origin if it matches main,release/* (rebase)\n" +
+		"Run command 1 of 1: make\n"
+	if output != want {
+		t.Errorf("output = %q, want %q", output, want)
+	}
+
+	if _, err := h.Deploy(&folder, "", false); err == nil {
+		t.Error("Deploy of a folder that is not a git repository succeeded")
+	}
+}
+
+func TestGitTokenOnlyForGitHub(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.GitHub.AppID = 1
+	cfg.GitHub.InstallationID = 1
+	// Minting a token fails, which shows whether one was attempted
+	cfg.GitHub.PrivateKeyPath = filepath.Join(t.TempDir(), "missing.pem")
+	h := NewHandler(cfg)
+
+	for _, url := range []string{"https://gitlab.com/owner/repo.git", "git@bitbucket.org:owner/repo.git", "https://gitea.example.com/owner/repo"} {
+		folder := config.WatchedFolder{Path: t.TempDir(), RepoURL: url}
+		token, err := h.gitToken(&folder)
+		if token != "" || err != nil {
+			t.Errorf("gitToken for %s = %q, %v, want no token", url, token, err)
+		}
+	}
+
+	folder := config.WatchedFolder{Path: t.TempDir(), RepoURL: "https://github.com/owner/repo.git"}
+	if _, err := h.gitToken(&folder); err == nil {
+		t.Error("gitToken for a GitHub repository did not try to mint a token")
+	}
+}
+
+func TestManualDeployIsRecorded(t *testing.T) {
+	// Not a git repository: the deployment fails but is still recorded
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", Command: "true", RepoURL: "git@github.com:owner/app.git"}
+	cfg := &config.Config{Folders: []config.WatchedFolder{folder}}
+	cfg.Server.LogDir = t.TempDir()
+	cfg.Server.HistoryFile = filepath.Join(t.TempDir(), "history.jsonl")
+	h := NewHandler(cfg)
+
+	if _, err := h.Deploy(&folder, "", true); err != nil {
+		t.Fatalf("dry run returned error: %v", err)
+	}
+	if entries, _ := h.currentHistory().List("", 0); len(entries) != 0 {
+		t.Fatalf("dry run recorded %d deployments, want none", len(entries))
+	}
+
+	_, deployErr := h.Deploy(&folder, "", false)
+	if deployErr == nil {
+		t.Fatal("Deploy of a folder that is not a git repository succeeded")
+	}
+	entries, err := h.currentHistory().List("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("%d deployments recorded, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.Folder != folder.Path || entry.Repo != "owner/app" || entry.Branch != "main" || entry.Result != ResultFailure || entry.Error != deployErr.Error() {
+		t.Errorf("entry = %+v", entry)
+	}
+	if entry.LogPath == "" {
+		t.Fatal("entry has no deploy log")
+	}
+	if _, err := os.Stat(entry.LogPath); err != nil {
+		t.Errorf("deploy log of the entry: %v", err)
+	}
+}
+
+func TestDeployEnvMaliciousBranch(t *testing.T) {
+	branches := []string{
+		"main; touch pwned",
+		"main && touch pwned",
+		"$(touch pwned)",
+		"`touch pwned`",
+		"main'; touch pwned; echo '",
+	}
+
+	for _, branch := range branches {
+		t.Run(branch, func(t *testing.T) {
+			folder := config.WatchedFolder{Path: t.TempDir()}
+			h := newWebhookHandler(folder)
+
+			event := &PushEvent{Ref: "refs/heads/" + branch}
+			command := `printf %s "$DEPLOY_BRANCH" > branch`
+			if _, err := h.runCommand(context.Background(), slog.Default(), &folder, command, deployEnv(&folder, event, "new")); err != nil {
+				t.Fatalf("runCommand returned error: %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(folder.Path, "branch"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != branch {
+				t.Errorf("DEPLOY_BRANCH = %q, want %q", got, branch)
+			}
+			if _, err := os.Stat(filepath.Join(folder.Path, "pwned")); err == nil {
+				t.Error("branch name ran a command")
+			}
+		})
+	}
+}
+
+func TestUpdateDirtyStrategies(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     []string
+	}{
+		{config.DirtyFail, []string{"pull main"}},
+		{config.DirtyStash, []string{"stash", "pull main"}},
+		{config.DirtyReset, []string{"reset main", "pull main"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			repo := newFakeRepo("old")
+			repo.dirty = true
+			folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", Command: "true", DirtyStrategy: tt.strategy}
+			h := newTestHandler(t, repo, folder)
+
+			if _, _, err := h.processUpdate(context.Background(), &folder, pushEvent("main", "new"), false); err != nil {
+				t.Fatalf("processUpdate returned error: %v", err)
+			}
+			if got := repo.Calls(); !slices.Equal(got, tt.want) {
+				t.Errorf("calls = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateChecksOutTag(t *testing.T) {
+	repo := newFakeRepo("old")
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", Command: "true", Trigger: config.TriggerTag}
+	h := newTestHandler(t, repo, folder)
+
+	event := pushEvent("main", "new")
+	event.Ref = "refs/tags/v1.2.0"
+	_, commit, err := h.processUpdate(context.Background(), &folder, event, false)
+	if err != nil {
+		t.Fatalf("processUpdate returned error: %v", err)
+	}
+	if got, want := repo.Calls(), []string{"checkout-tag v1.2.0"}; !slices.Equal(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+	if commit == nil || commit.SHA != "tag-v1.2.0" {
+		t.Errorf("deployed commit = %v, want the tag", commit)
+	}
+}
+
+func TestUpdatePinsPushedSHA(t *testing.T) {
+	repo := newFakeRepo("old")
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", Command: "true", PinToPushedSHA: true}
+	h := newTestHandler(t, repo, folder)
+
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	if _, _, err := h.processUpdate(context.Background(), &folder, pushEvent("main", sha), false); err != nil {
+		t.Fatalf("processUpdate returned error: %v", err)
+	}
+	if got, want := repo.Calls(), []string{"checkout-sha " + sha}; !slices.Equal(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+}
+
+func TestUpdatePullFailure(t *testing.T) {
+	repo := newFakeRepo("old")
+	repo.pullErr = errors.New("network down")
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", Command: "touch deployed"}
+	h := newTestHandler(t, repo, folder)
+
+	_, _, err := h.processUpdate(context.Background(), &folder, pushEvent("main", "new"), false)
+	if err == nil || !strings.Contains(err.Error(), "network down") {
+		t.Fatalf("error = %v, want the pull error", err)
+	}
+	if _, err := os.Stat(filepath.Join(folder.Path, "deployed")); err == nil {
+		t.Error("command ran after the pull failed")
+	}
+}
+
+func TestUpdateRollback(t *testing.T) {
+	repo := newFakeRepo("old")
+	folder := config.WatchedFolder{
+		Path:            t.TempDir(),
+		Branch:          "main",
+		Commands:        []string{"echo building", "exit 1", "touch unreachable"},
+		RollbackCommand: `echo "rolling back to $DEPLOY_PREVIOUS_SHA"`,
+	}
+	h := newTestHandler(t, repo, folder)
+
+	output, _, err := h.processUpdate(context.Background(), &folder, pushEvent("main", "new"), false)
+	if err == nil {
+		t.Fatal("processUpdate of a failing command returned no error")
+	}
+	if !strings.Contains(err.Error(), "command 2 of 3 (exit 1) failed") {
+		t.Errorf("error = %v, want the failed command", err)
+	}
+	if !strings.Contains(err.Error(), "Rollback command succeeded") || !strings.Contains(err.Error(), "rolling back to old") {
+		t.Errorf("error = %v, want the rollback output with the previous commit", err)
+	}
+	if !strings.Contains(output, "building") {
+		t.Errorf("output = %q, want the output of the commands run", output)
+	}
+	if _, err := os.Stat(filepath.Join(folder.Path, "unreachable")); err == nil {
+		t.Error("command after the failed one ran")
+	}
+}
+
+func TestDryRunRunsNothing(t *testing.T) {
+	folders := []config.WatchedFolder{
+		{Path: t.TempDir(), Branch: "main", PreCommand: "make stop", Command: "make", RollbackCommand: "make rollback", DirtyStrategy: config.DirtyReset},
+		{Path: t.TempDir(), Branch: "main,release/*", Command: "make"},
+		{Path: t.TempDir(), Branch: "main", Command: "make", Trigger: config.TriggerTag},
+	}
+
+	for _, folder := range folders {
+		t.Run(folder.Branch+" "+folder.GetTrigger(), func(t *testing.T) {
+			repo := newFakeRepo("old")
+			h := newTestHandler(t, repo, folder)
+			opened := 0
+			h.SetRepoFactory(func(ctx context.Context, logger *slog.Logger, folder *config.WatchedFolder) git.Repo {
+				opened++
+				return repo
+			})
+			ran := 0
+			h.SetCommandRunner(func(ctx context.Context, cmd *exec.Cmd) error {
+				ran++
+				return nil
+			})
+
+			if _, err := h.Deploy(&folder, "v1.0.0", true); err != nil {
+				t.Fatalf("Deploy returned error: %v", err)
+			}
+			if _, _, err := h.processUpdate(context.Background(), &folder, pushEvent("main", "new"), true); err != nil {
+				t.Fatalf("processUpdate returned error: %v", err)
+			}
+			if opened != 0 || len(repo.Calls()) != 0 {
+				t.Errorf("dry run opened the repository %d times and ran %q", opened, repo.Calls())
+			}
+			if ran != 0 {
+				t.Errorf("dry run ran %d commands", ran)
+			}
+		})
+	}
+}
+
+func TestCommandRunner(t *testing.T) {
+	repo := newFakeRepo("old")
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", PreCommand: "make stop", Commands: []string{"make", "make start"}}
+	h := newTestHandler(t, repo, folder)
+	var commands []string
+	h.SetCommandRunner(func(ctx context.Context, cmd *exec.Cmd) error {
+		commands = append(commands, cmd.Args[len(cmd.Args)-1])
+		return nil
+	})
+
+	if _, _, err := h.processUpdate(context.Background(), &folder, pushEvent("main", "new"), false); err != nil {
+		t.Fatalf("processUpdate returned error: %v", err)
+	}
+	if want := []string{"make stop", "make", "make start"}; !slices.Equal(commands, want) {
+		t.Errorf("commands = %q, want %q", commands, want)
+	}
+}
+
+func TestDeletionNotDeployed(t *testing.T) {
+	zero := strings.Repeat("0", 40)
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"deleted flag", `{"ref": "refs/heads/main", "after": "` + zero + `", "deleted": true, "repository": {"full_name": "owner/repo", "clone_url": "https://github.com/owner/repo.git"}}`},
+		{"zero SHA", `{"ref": "refs/heads/main", "after": "` + zero + `", "repository": {"full_name": "owner/repo", "clone_url": "https://github.com/owner/repo.git"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeRepo("old")
+			folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", Command: "touch deployed", RepoURL: "https://github.com/owner/repo.git", PinToPushedSHA: true}
+			h := newTestHandler(t, repo, folder)
+			h.currentConfig().GitHub.WebhookSecret = testSecret
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, newWebhookRequest("push", tt.body))
+			if rec.Code != http.StatusAccepted {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusAccepted)
+			}
+			if err := h.Wait(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if calls := repo.Calls(); len(calls) > 0 {
+				t.Errorf("calls = %q, want no deployment", calls)
+			}
+			if _, err := os.Stat(filepath.Join(folder.Path, "deployed")); err == nil {
+				t.Error("command ran for a deleted branch")
+			}
+		})
+	}
+}
+
+func TestUpdateVerifiesBeforeCheckout(t *testing.T) {
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	tests := []struct {
+		name   string
+		folder config.WatchedFolder
+		event  *PushEvent
+		want   []string
+	}{
+		{
+			"branch",
+			config.WatchedFolder{Branch: "main"},
+			pushEvent("main", "new"),
+			[]string{"fetch refs/heads/main", "verify fetched", "fast-forward fetched"},
+		},
+		{
+			"branch pattern",
+			config.WatchedFolder{Branch: "release/*"},
+			pushEvent("release/1", "new"),
+			[]string{"fetch refs/heads/release/1", "verify fetched", "checkout-branch release/1", "fast-forward fetched"},
+		},
+		{
+			"tag",
+			config.WatchedFolder{Branch: "main", Trigger: config.TriggerTag},
+			&PushEvent{Ref: "refs/tags/v1.0", After: "new"},
+			[]string{"fetch refs/tags/v1.0", "verify fetched", "checkout-sha fetched"},
+		},
+		{
+			"pinned",
+			config.WatchedFolder{Branch: "main", PinToPushedSHA: true},
+			pushEvent("main", sha),
+			[]string{"fetch refs/heads/main", "verify " + sha, "checkout-sha " + sha},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newFakeRepo("old")
+			folder := tt.folder
+			folder.Path = t.TempDir()
+			folder.Command = "true"
+			folder.RequireSignedCommits = true
+			h := newTestHandler(t, repo, folder)
+
+			if _, _, err := h.processUpdate(context.Background(), &folder, tt.event, false); err != nil {
+				t.Fatalf("processUpdate returned error: %v", err)
+			}
+			if got := repo.Calls(); !slices.Equal(got, tt.want) {
+				t.Errorf("calls = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateUnverifiedCommitNotCheckedOut(t *testing.T) {
+	repo := newFakeRepo("old")
+	repo.verifyErr = errors.New("no signature")
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", Command: "touch deployed", RequireSignedCommits: true}
+	h := newTestHandler(t, repo, folder)
+
+	_, _, err := h.processUpdate(context.Background(), &folder, pushEvent("main", "new"), false)
+	if err == nil || !strings.Contains(err.Error(), "not signed by a trusted key") {
+		t.Fatalf("error = %v, want a signature error", err)
+	}
+	if got, want := repo.Calls(), []string{"fetch refs/heads/main", "verify fetched"}; !slices.Equal(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+	if head, _ := repo.GetHeadSHA(); head != "old" {
+		t.Errorf("HEAD = %s, want it unchanged", head)
+	}
+	if _, err := os.Stat(filepath.Join(folder.Path, "deployed")); err == nil {
+		t.Error("command ran for an unverified commit")
+	}
+}
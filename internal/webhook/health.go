@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+)
+
+// healthResponse is returned by the health endpoint
+type healthResponse struct {
+	Status   string   `json:"status"`             // ok or degraded
+	Problems []string `json:"problems,omitempty"` // Only shown to requests passing the status token
+}
+
+// HealthHandler returns an HTTP handler reporting whether deployments can
+// run: the configuration is loaded, git is installed and every watched
+// folder exists. It answers 200 when healthy and 503 otherwise. The
+// problems are only listed for requests authorized like the status
+// endpoint, so an open health check does not reveal folder paths.
+func (h *Handler) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		resp := healthResponse{Status: "ok"}
+		code := http.StatusOK
+		if problems := h.healthProblems(); len(problems) > 0 {
+			resp.Status = "degraded"
+			code = http.StatusServiceUnavailable
+			if h.authorizeStatus(r) {
+				resp.Problems = problems
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			h.logger.Error("Error writing health response", "error", err)
+		}
+	})
+}
+
+// healthProblems returns what currently keeps deployments from running
+func (h *Handler) healthProblems() []string {
+	cfg := h.currentConfig()
+	if cfg == nil {
+		return []string{"configuration not loaded"}
+	}
+
+	var problems []string
+	binary := cfg.Git.BinaryPath
+	if binary == "" {
+		binary = "git"
+	}
+	if _, err := exec.LookPath(binary); err != nil {
+		problems = append(problems, fmt.Sprintf("git not found: %v", err))
+	}
+
+	for _, folder := range cfg.Folders {
+		info, err := os.Stat(folder.Path)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("folder %s: %v", folder.Path, err))
+		case !info.IsDir():
+			problems = append(problems, fmt.Sprintf("folder %s: not a directory", folder.Path))
+		}
+	}
+
+	return problems
+}
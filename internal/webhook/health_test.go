@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+// getHealth requests the health endpoint with an optional bearer token
+func getHealth(t *testing.T, h *Handler, method, token string) (int, healthResponse) {
+	t.Helper()
+	req := httptest.NewRequest(method, "/health", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.HealthHandler().ServeHTTP(rec, req)
+
+	var resp healthResponse
+	if rec.Code != http.StatusMethodNotAllowed && method != http.MethodHead {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestHealthHandler(t *testing.T) {
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main"}
+	h := NewHandler(&config.Config{Folders: []config.WatchedFolder{folder}})
+
+	code, resp := getHealth(t, h, http.MethodGet, "")
+	if code != http.StatusOK || resp.Status != "ok" || len(resp.Problems) != 0 {
+		t.Errorf("healthy: %d %+v, want 200 ok", code, resp)
+	}
+	if code, _ := getHealth(t, h, http.MethodHead, ""); code != http.StatusOK {
+		t.Errorf("HEAD status = %d, want 200", code)
+	}
+	if code, _ := getHealth(t, h, http.MethodPost, ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", code)
+	}
+}
+
+func TestHealthHandlerProblems(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	cfg := &config.Config{Folders: []config.WatchedFolder{{Path: missing, Branch: "main"}}}
+	cfg.Git.BinaryPath = "no-such-git"
+	cfg.Server.StatusToken = "status-secret"
+	h := NewHandler(cfg)
+
+	// Unauthorized callers learn that deployments cannot run, but not why
+	code, resp := getHealth(t, h, http.MethodGet, "")
+	if code != http.StatusServiceUnavailable || resp.Status != "degraded" {
+		t.Errorf("unauthorized: %d %+v, want 503 degraded", code, resp)
+	}
+	if len(resp.Problems) != 0 {
+		t.Errorf("unauthorized caller sees problems %v", resp.Problems)
+	}
+	if _, resp := getHealth(t, h, http.MethodGet, "wrong"); len(resp.Problems) != 0 {
+		t.Errorf("caller with a wrong token sees problems %v", resp.Problems)
+	}
+
+	code, resp = getHealth(t, h, http.MethodGet, "status-secret")
+	if code != http.StatusServiceUnavailable || resp.Status != "degraded" {
+		t.Errorf("authorized: %d %+v, want 503 degraded", code, resp)
+	}
+	if len(resp.Problems) != 2 {
+		t.Fatalf("problems = %v, want git and the missing folder", resp.Problems)
+	}
+}
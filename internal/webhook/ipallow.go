@@ -0,0 +1,168 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// githubMetaURL is the GitHub API endpoint listing GitHub's IP ranges
+const githubMetaURL = "https://api.github.com/meta"
+
+// githubHookRangesTTL is how long fetched ranges are cached
+const githubHookRangesTTL = time.Hour
+
+// fallbackGitHubHookRanges is used when the meta API cannot be reached
+var fallbackGitHubHookRanges = []string{
+	"192.30.252.0/22",
+	"185.199.108.0/22",
+	"140.82.112.0/20",
+	"143.55.64.0/20",
+	"2a0a:a440::/29",
+	"2606:50c0::/32",
+}
+
+// ipAllowlist checks request addresses against GitHub's webhook IP ranges
+type ipAllowlist struct {
+	metaURL string
+	client  *http.Client
+
+	mu         sync.Mutex
+	ranges     []*net.IPNet
+	fetchedAt  time.Time
+	refreshing chan struct{} // Closed when the running refresh is done
+}
+
+// newIPAllowlist creates an allowlist backed by the GitHub meta API
+func newIPAllowlist() *ipAllowlist {
+	return &ipAllowlist{
+		metaURL: githubMetaURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Allowed reports whether the IP is within one of GitHub's hook ranges
+func (a *ipAllowlist) Allowed(ip net.IP) bool {
+	for _, ipNet := range a.getRanges() {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// getRanges returns the cached ranges, refreshing them when they expire.
+// The meta API is queried without holding the lock: expired ranges keep being
+// served while the refresh runs, and only the first lookup waits for it. If
+// refreshing fails, the previous ranges (or the static fallback) are used.
+func (a *ipAllowlist) getRanges() []*net.IPNet {
+	a.mu.Lock()
+	ranges := a.ranges
+	if ranges != nil && time.Since(a.fetchedAt) < githubHookRangesTTL {
+		a.mu.Unlock()
+		return ranges
+	}
+	if a.refreshing == nil {
+		a.refreshing = make(chan struct{})
+		go a.refresh(a.refreshing)
+	}
+	done := a.refreshing
+	a.mu.Unlock()
+
+	if ranges != nil {
+		return ranges
+	}
+	<-done
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.ranges
+}
+
+// refresh fetches the ranges from the meta API and closes done once they
+// are stored
+func (a *ipAllowlist) refresh(done chan struct{}) {
+	cidrs, err := a.fetchRanges()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	defer close(done)
+
+	if err != nil {
+		slog.Warn("Error fetching GitHub IP ranges, using fallback", "error", err)
+		if a.ranges == nil {
+			cidrs = fallbackGitHubHookRanges
+		} else {
+			cidrs = nil
+		}
+	}
+
+	if cidrs != nil {
+		a.ranges = parseCIDRs(cidrs)
+	}
+	// Retry fetching only after the TTL, even on failure
+	a.fetchedAt = time.Now()
+	a.refreshing = nil
+}
+
+// fetchRanges reads the webhook CIDR ranges from the GitHub meta API
+func (a *ipAllowlist) fetchRanges() ([]string, error) {
+	resp, err := a.client.Get(a.metaURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub meta API returned status %d", resp.StatusCode)
+	}
+
+	var meta struct {
+		Hooks []string `json:"hooks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("failed to parse GitHub meta response: %w", err)
+	}
+	if len(meta.Hooks) == 0 {
+		return nil, fmt.Errorf("GitHub meta response contains no hook ranges")
+	}
+
+	return meta.Hooks, nil
+}
+
+// parseCIDRs parses CIDR strings, skipping invalid entries
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	var ranges []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			slog.Warn("Ignoring invalid CIDR", "cidr", cidr, "error", err)
+			continue
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges
+}
+
+// clientIP returns the address of the client making the request. When
+// trustProxy is set, the last address in X-Forwarded-For (the one added by
+// the trusted proxy) is used instead of the connection's remote address.
+func clientIP(r *http.Request, trustProxy bool) net.IP {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			parts := strings.Split(forwarded, ",")
+			return net.ParseIP(strings.TrimSpace(parts[len(parts)-1]))
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestAllowlist returns an allowlist reading the meta API from handler
+func newTestAllowlist(t *testing.T, handler http.HandlerFunc) *ipAllowlist {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	a := newIPAllowlist()
+	a.metaURL = server.URL
+	return a
+}
+
+func TestIPAllowlistFetchesRanges(t *testing.T) {
+	a := newTestAllowlist(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"hooks": ["10.0.0.0/8"]}`))
+	})
+
+	if !a.Allowed(net.ParseIP("10.1.2.3")) {
+		t.Error("address in the fetched range is not allowed")
+	}
+	if a.Allowed(net.ParseIP("192.30.252.1")) {
+		t.Error("address only in the fallback ranges is allowed")
+	}
+}
+
+func TestIPAllowlistFallback(t *testing.T) {
+	a := newTestAllowlist(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	if !a.Allowed(net.ParseIP("192.30.252.1")) {
+		t.Error("address in the fallback ranges is not allowed")
+	}
+	if a.Allowed(net.ParseIP("10.1.2.3")) {
+		t.Error("address outside the fallback ranges is allowed")
+	}
+}
+
+func TestIPAllowlistServesStaleRangesWhileRefreshing(t *testing.T) {
+	release := make(chan struct{})
+	requests := make(chan struct{}, 10)
+	a := newTestAllowlist(t, func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		<-release
+		w.Write([]byte(`{"hooks": ["172.16.0.0/12"]}`))
+	})
+	defer close(release)
+
+	a.ranges = parseCIDRs([]string{"10.0.0.0/8"})
+	a.fetchedAt = time.Now().Add(-2 * githubHookRangesTTL)
+
+	allowed := make(chan bool)
+	go func() {
+		allowed <- a.Allowed(net.ParseIP("10.1.2.3"))
+	}()
+	select {
+	case ok := <-allowed:
+		if !ok {
+			t.Error("address in the stale ranges is not allowed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lookup waited for the refresh instead of using the stale ranges")
+	}
+
+	<-requests
+	// Lookups during the refresh don't start another one
+	a.Allowed(net.ParseIP("10.1.2.3"))
+	select {
+	case <-requests:
+		t.Error("meta API was queried twice for one refresh")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		trustProxy bool
+		want       string
+	}{
+		{"remote address", "192.30.252.1:1234", "", false, "192.30.252.1"},
+		{"forwarded ignored", "10.0.0.1:1234", "192.30.252.1", false, "10.0.0.1"},
+		{"forwarded trusted", "10.0.0.1:1234", "192.30.252.1", true, "192.30.252.1"},
+		{"last forwarded address", "10.0.0.1:1234", "192.30.252.1, 203.0.113.9", true, "203.0.113.9"},
+		{"no forwarded header", "10.0.0.1:1234", "", true, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/webhook", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := clientIP(r, tt.trustProxy); !got.Equal(net.ParseIP(tt.want)) {
+				t.Errorf("clientIP = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookRejectsAddressesOutsideGitHub(t *testing.T) {
+	h := newWebhookHandler()
+	h.allowlist = newTestAllowlist(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"hooks": ["192.30.252.0/22"]}`))
+	})
+
+	tests := []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"192.30.252.1:1234", http.StatusOK},
+		{"203.0.113.9:1234", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := newWebhookRequest("ping", `{}`)
+		req.RemoteAddr = tt.remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("request from %s: status = %d, want %d", tt.remoteAddr, rec.Code, tt.want)
+		}
+	}
+}
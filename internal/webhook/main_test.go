@@ -0,0 +1,26 @@
+package webhook
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+// TestMain points the configuration to a temporary directory, so that
+// handlers record their deployment history there instead of next to the
+// real configuration
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "webhook-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	config.SetConfigPath(filepath.Join(dir, "config.json"))
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
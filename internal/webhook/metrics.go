@@ -0,0 +1,25 @@
+package webhook
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics registered with the default Prometheus registry
+var (
+	deploysTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "deployer_deploys_total",
+		Help: "Number of deployments by result.",
+	}, []string{"result"})
+
+	deployDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "deployer_deploy_duration_seconds",
+		Help:    "Duration of deployments (pull and command) in seconds.",
+		Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+	})
+
+	webhookRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "deployer_webhook_requests_total",
+		Help: "Number of webhook requests by event type.",
+	}, []string{"event"})
+)
@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWebhookRequestsMetric(t *testing.T) {
+	h := newWebhookHandler()
+	counter := webhookRequestsTotal.WithLabelValues("ping")
+	before := testutil.ToFloat64(counter)
+
+	h.ServeHTTP(httptest.NewRecorder(), newWebhookRequest("ping", `{}`))
+
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Errorf("ping requests counted %v times, want 1", got)
+	}
+}
+
+func TestDeployMetrics(t *testing.T) {
+	counter := deploysTotal.WithLabelValues(ResultFailure)
+	before := testutil.ToFloat64(counter)
+
+	// Pulling fails as the folder is not a repository
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main"}
+	h := NewHandler(&config.Config{Folders: []config.WatchedFolder{folder}})
+	h.deployFolder(&folder, &PushEvent{Ref: "refs/heads/main", After: "abc123"})
+
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Errorf("failed deployments counted %v times, want 1", got)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	deploysTotal.WithLabelValues(ResultSuccess)
+	webhookRequestsTotal.WithLabelValues("push")
+	deployDuration.Observe(1)
+
+	rec := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	for _, name := range []string{
+		`deployer_deploys_total{result="success"}`,
+		`deployer_webhook_requests_total{event="push"}`,
+		"deployer_deploy_duration_seconds_bucket",
+	} {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("/metrics does not expose %s", name)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package webhook
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+// Provider adapts the webhooks of a git hosting service to the shared
+// push handling. Every provider is verified with the configured webhook
+// secret.
+type Provider interface {
+	// Name identifies the provider in logs
+	Name() string
+
+	// Detect reports whether a request was sent by the provider
+	Detect(header http.Header) bool
+
+	// Event returns the event type of a request, e.g. "push"
+	Event(header http.Header) string
+
+	// DeliveryID returns the provider's ID of the delivery, "" if it has none
+	DeliveryID(header http.Header) string
+
+	// Verify checks the signature or token of a request against the secret
+	Verify(secret []byte, header http.Header, body []byte) bool
+
+	// Parse returns the pushes of a request, none for events that do not
+	// deploy anything
+	Parse(header http.Header, body []byte) ([]Push, error)
+}
+
+// Push is a push event parsed from a webhook and the trigger it deploys:
+// a branch push, a tag push or a release
+type Push struct {
+	Event   *PushEvent
+	Trigger string
+}
+
+// Provider names
+const (
+	ProviderGitHub    = "github"
+	ProviderGitLab    = "gitlab"
+	ProviderBitbucket = "bitbucket"
+	ProviderGitea     = "gitea"
+)
+
+// providers are tried in order; GitHub is used for requests no provider
+// detects so that its error handling applies. Gitea comes before GitHub as
+// it also sends X-GitHub-Event.
+var providers = []Provider{giteaProvider{}, gitlabProvider{}, bitbucketProvider{}, githubProvider{}}
+
+// providerFor returns the provider that sent a request
+func providerFor(header http.Header) Provider {
+	for _, p := range providers {
+		if p.Detect(header) {
+			return p
+		}
+	}
+	return githubProvider{}
+}
+
+// githubProvider handles GitHub webhooks, signed with HMAC-SHA256 (or
+// HMAC-SHA1 for old webhooks) of the body
+type githubProvider struct{}
+
+func (githubProvider) Name() string { return ProviderGitHub }
+
+func (githubProvider) Detect(header http.Header) bool {
+	return header.Get("X-GitHub-Event") != ""
+}
+
+func (githubProvider) Event(header http.Header) string {
+	return header.Get("X-GitHub-Event")
+}
+
+func (githubProvider) DeliveryID(header http.Header) string {
+	return header.Get("X-GitHub-Delivery")
+}
+
+func (githubProvider) Verify(secret []byte, header http.Header, body []byte) bool {
+	if signature := header.Get("X-Hub-Signature-256"); signature != "" {
+		return checkMAC(sha256.New, secret, body, strings.TrimPrefix(signature, "sha256="))
+	}
+	if signature := header.Get("X-Hub-Signature"); signature != "" {
+		return checkMAC(sha1.New, secret, body, strings.TrimPrefix(signature, "sha1="))
+	}
+
+	return false
+}
+
+func (githubProvider) Parse(header http.Header, body []byte) ([]Push, error) {
+	return parseGitHubEvent(header.Get("X-GitHub-Event"), body)
+}
+
+// parseGitHubEvent parses the push and release payloads of GitHub and of
+// providers sending GitHub-compatible payloads
+func parseGitHubEvent(eventType string, body []byte) ([]Push, error) {
+	switch eventType {
+	case "push":
+		var event PushEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, fmt.Errorf("error parsing push event: %w", err)
+		}
+		if event.Tag() != "" {
+			return []Push{{Event: &event, Trigger: config.TriggerTag}}, nil
+		}
+		return []Push{{Event: &event, Trigger: config.TriggerBranch}}, nil
+	case "release":
+		var release ReleaseEvent
+		if err := json.Unmarshal(body, &release); err != nil {
+			return nil, fmt.Errorf("error parsing release event: %w", err)
+		}
+		if release.Action != "published" {
+			return nil, nil
+		}
+		event := release.toPushEvent()
+		return []Push{{Event: &event, Trigger: config.TriggerRelease}}, nil
+	}
+
+	// We only care about push and release events
+	return nil, nil
+}
@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"time"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+// folderQueue tracks the pending deployment of a single folder
+type folderQueue struct {
+	running bool       // a goroutine is deploying (or about to deploy) the folder
+	event   *PushEvent // latest push not yet deployed, nil if none
+}
+
+// enqueueDeploy deploys a folder for a push event, coalescing pushes that
+// arrive while a deployment of the same folder is waiting or running.
+// The first push waits for the debounce window so that a burst of pushes
+// results in a single deployment; pushes arriving during a deployment only
+// mark the folder for one more run with the latest push. Each run uses the
+// folder's configuration at the time it starts.
+func (h *Handler) enqueueDeploy(folder config.WatchedFolder, event *PushEvent) {
+	h.queuesMu.Lock()
+	q, ok := h.queues[folder.Path]
+	if !ok {
+		q = &folderQueue{}
+		h.queues[folder.Path] = q
+	}
+	replaced := q.event
+	q.event = event
+	if q.running {
+		h.queuesMu.Unlock()
+		h.eventLogger(event).Info("Deployment already queued, coalescing push", "folder", folder.Path)
+		// The replaced push will not deploy this folder anymore
+		if replaced != nil && replaced.batch != nil {
+			h.finishBatch(h.eventLogger(replaced), replaced.batch)
+		}
+		return
+	}
+	q.running = true
+	h.queuesMu.Unlock()
+
+	window := time.Duration(h.currentConfig().Server.DebounceSeconds) * time.Second
+
+	for {
+		if window > 0 {
+			time.Sleep(window)
+		}
+
+		h.queuesMu.Lock()
+		next := q.event
+		q.event = nil
+		h.queuesMu.Unlock()
+
+		release := h.acquireDeploySlot(next, folder.Path)
+		// Deploy with the folder's current configuration, which may have been
+		// reloaded since the push arrived
+		if current := h.currentConfig().FindFolderByPath(folder.Path); current == nil || !current.IsEnabled() {
+			h.eventLogger(next).Info("Folder removed or disabled since the push, skipping", "folder", folder.Path)
+			if next.batch != nil {
+				h.finishBatch(h.eventLogger(next), next.batch)
+			}
+		} else {
+			folder = *current
+			h.deployFolder(&folder, next)
+		}
+		release()
+
+		h.queuesMu.Lock()
+		if q.event == nil {
+			q.running = false
+			h.queuesMu.Unlock()
+			return
+		}
+		h.queuesMu.Unlock()
+
+		h.logger.Info("Redeploying for pushes received during the last deployment", "folder", folder.Path)
+	}
+}
+
+// acquireDeploySlot waits until fewer than the maximum number of concurrent
+// deployments are running and returns the function freeing the slot
+func (h *Handler) acquireDeploySlot(event *PushEvent, path string) func() {
+	select {
+	case h.deploySlots <- struct{}{}:
+	default:
+		h.waiting.Add(1)
+		h.eventLogger(event).Info("Waiting for a free deployment slot", "folder", path, "limit", cap(h.deploySlots))
+		h.deploySlots <- struct{}{}
+		h.waiting.Add(-1)
+	}
+
+	return func() { <-h.deploySlots }
+}
@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+// deployments returns the number of deployments recorded in a count file
+// by the folder command
+func deployments(t *testing.T, countFile string) int {
+	t.Helper()
+	data, err := os.ReadFile(countFile)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+func TestEnqueueDeployCoalescesPushes(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "count")
+	folder := config.WatchedFolder{Path: newClone(t), Branch: "main", Command: "echo deployed >> " + count}
+	cfg := &config.Config{Folders: []config.WatchedFolder{folder}}
+	cfg.Server.DebounceSeconds = 1
+	h := NewHandler(cfg)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			h.enqueueDeploy(folder, &PushEvent{Ref: "refs/heads/main", After: fmt.Sprintf("commit%d", i)})
+		}(i)
+	}
+	wg.Wait()
+
+	if got := deployments(t, count); got < 1 || got > 2 {
+		t.Errorf("%d deployments ran for five pushes, want one or two", got)
+	}
+}
+
+func TestEnqueueDeployRedeploysPushesDuringDeployment(t *testing.T) {
+	state := t.TempDir()
+	count := filepath.Join(state, "count")
+	release := filepath.Join(state, "release")
+	// The command blocks until the test creates the release file
+	command := fmt.Sprintf("echo deployed >> %s; while [ ! -e %s ]; do sleep 0.01; done", count, release)
+	folder := config.WatchedFolder{Path: newClone(t), Branch: "main", Command: command}
+	h := NewHandler(&config.Config{Folders: []config.WatchedFolder{folder}})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.enqueueDeploy(folder, &PushEvent{Ref: "refs/heads/main", After: "first"})
+	}()
+	for deployments(t, count) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Pushes during the deployment return right away and deploy once more
+	for i := 0; i < 4; i++ {
+		h.enqueueDeploy(folder, &PushEvent{Ref: "refs/heads/main", After: fmt.Sprintf("commit%d", i)})
+	}
+	if err := os.WriteFile(release, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if got := deployments(t, count); got != 2 {
+		t.Errorf("%d deployments ran, want 2", got)
+	}
+}
+
+// reloadFolders reloads the handler's configuration with other folders
+func reloadFolders(h *Handler, folders ...config.WatchedFolder) {
+	cfg := *h.currentConfig()
+	cfg.Folders = folders
+	h.Reload(&cfg)
+}
+
+func TestEnqueueDeployUsesReloadedFolder(t *testing.T) {
+	state := t.TempDir()
+	folder := config.WatchedFolder{Path: newClone(t), Branch: "main", Command: "touch " + filepath.Join(state, "before-reload")}
+	cfg := &config.Config{Folders: []config.WatchedFolder{folder}}
+	cfg.Server.DebounceSeconds = 1
+	h := NewHandler(cfg)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.enqueueDeploy(folder, &PushEvent{Ref: "refs/heads/main", After: "new"})
+	}()
+
+	// Reload within the debounce window
+	reloaded := folder
+	reloaded.Command = "touch " + filepath.Join(state, "after-reload")
+	reloadFolders(h, reloaded)
+	<-done
+
+	if _, err := os.Stat(filepath.Join(state, "after-reload")); err != nil {
+		t.Error("deployment did not run the reloaded command")
+	}
+	if _, err := os.Stat(filepath.Join(state, "before-reload")); err == nil {
+		t.Error("deployment ran the command configured at push time")
+	}
+}
+
+func TestEnqueueDeploySkipsRemovedFolder(t *testing.T) {
+	count := filepath.Join(t.TempDir(), "count")
+	folder := config.WatchedFolder{Path: newClone(t), Branch: "main", Command: "echo deployed >> " + count}
+	cfg := &config.Config{Folders: []config.WatchedFolder{folder}}
+	cfg.Server.DebounceSeconds = 1
+	h := NewHandler(cfg)
+
+	disabled := false
+	tests := []struct {
+		name    string
+		folders []config.WatchedFolder
+	}{
+		{"removed", nil},
+		{"disabled", []config.WatchedFolder{{Path: folder.Path, Branch: "main", Command: folder.Command, Enabled: &disabled}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reloadFolders(h, folder)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				h.enqueueDeploy(folder, &PushEvent{Ref: "refs/heads/main", After: "new"})
+			}()
+			reloadFolders(h, tt.folders...)
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("enqueueDeploy did not return")
+			}
+			if got := deployments(t, count); got != 0 {
+				t.Errorf("%d deployments ran, want 0", got)
+			}
+		})
+	}
+}
+
+func TestEnqueueDeployRemovedFolderFinishesBatch(t *testing.T) {
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main", Command: "true"}
+	h := NewHandler(&config.Config{})
+
+	batch := &deployBatch{pending: 1}
+	h.enqueueDeploy(folder, &PushEvent{Ref: "refs/heads/main", After: "new", batch: batch})
+
+	if batch.pending != 0 {
+		t.Errorf("%d folders of the batch pending, want the removed folder finished", batch.pending)
+	}
+}
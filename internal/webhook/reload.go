@@ -0,0 +1,40 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ReloadHandler returns an HTTP handler reloading the configuration on
+// POST, for deployments where sending SIGHUP is awkward (e.g. containers).
+// reload loads the new configuration, hands it to the handler and returns
+// the number of watched folders. Requests must present the status token as
+// a bearer token; without a status token the endpoint is disabled.
+func (h *Handler) ReloadHandler(reload func() (int, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if h.currentConfig().Server.StatusToken == "" {
+			http.Error(w, "Reloading over HTTP requires server.status_token", http.StatusForbidden)
+			return
+		}
+		if !h.authorizeStatus(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		folders, err := reload()
+		if err != nil {
+			http.Error(w, "Reload failed: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"status": "reloaded", "folders": folders}); err != nil {
+			h.logger.Error("Error writing reload response", "error", err)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+// postReload requests the reload endpoint with an optional bearer token and
+// reports whether reload was called
+func postReload(h *Handler, method, token string, reloadErr error) (*httptest.ResponseRecorder, bool) {
+	called := false
+	reload := func() (int, error) {
+		called = true
+		return 3, reloadErr
+	}
+
+	req := httptest.NewRequest(method, "/reload", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ReloadHandler(reload).ServeHTTP(rec, req)
+	return rec, called
+}
+
+func TestReloadHandler(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.StatusToken = "status-secret"
+	h := NewHandler(cfg)
+
+	tests := []struct {
+		name      string
+		method    string
+		token     string
+		reloadErr error
+		want      int
+		reloaded  bool
+	}{
+		{"authorized", http.MethodPost, "status-secret", nil, http.StatusOK, true},
+		{"reload fails", http.MethodPost, "status-secret", errors.New("invalid config"), http.StatusUnprocessableEntity, true},
+		{"no token", http.MethodPost, "", nil, http.StatusUnauthorized, false},
+		{"wrong token", http.MethodPost, "wrong", nil, http.StatusUnauthorized, false},
+		{"GET", http.MethodGet, "status-secret", nil, http.StatusMethodNotAllowed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, reloaded := postReload(h, tt.method, tt.token, tt.reloadErr)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if reloaded != tt.reloaded {
+				t.Errorf("reloaded = %v, want %v", reloaded, tt.reloaded)
+			}
+		})
+	}
+
+	rec, _ := postReload(h, http.MethodPost, "status-secret", nil)
+	var resp struct {
+		Status  string `json:"status"`
+		Folders int    `json:"folders"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "reloaded" || resp.Folders != 3 {
+		t.Errorf("response = %+v, want reloaded with 3 folders", resp)
+	}
+}
+
+func TestReloadHandlerRequiresStatusToken(t *testing.T) {
+	h := NewHandler(&config.Config{})
+
+	rec, reloaded := postReload(h, http.MethodPost, "", nil)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+	if reloaded {
+		t.Error("configuration reloaded without a status token")
+	}
+}
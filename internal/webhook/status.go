@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/git"
+)
+
+// Deployment results reported by the status endpoint
+const (
+	ResultSuccess  = "success"
+	ResultFailure  = "failure"
+	ResultConflict = "conflict"
+)
+
+// DeployStatus describes the last deployment of a folder
+type DeployStatus struct {
+	LastDeploy time.Time     `json:"last_deploy"`
+	LastResult string        `json:"last_result"`
+	LastCommit string        `json:"last_commit"`
+	Duration   time.Duration `json:"-"`
+	DurationMS int64         `json:"duration_ms"`
+	Error      string        `json:"error,omitempty"`
+
+	// Commit is the deployed commit, nil if the repository was not updated
+	Commit *git.CommitInfo `json:"commit,omitempty"`
+}
+
+// folderStatus is a watched folder as returned by the status endpoint
+type folderStatus struct {
+	Path    string        `json:"path"`
+	Branch  string        `json:"branch"`
+	RepoURL string        `json:"repo_url"`
+	Status  *DeployStatus `json:"status"` // nil if never deployed since start
+}
+
+// recordStatus stores the result of a deployment of a folder
+func (h *Handler) recordStatus(path string, status DeployStatus) {
+	status.DurationMS = status.Duration.Milliseconds()
+
+	h.statusMu.Lock()
+	defer h.statusMu.Unlock()
+	h.status[path] = &status
+}
+
+// StatusHandler returns an HTTP handler describing the last deployment of
+// every watched folder as JSON. If a status token is configured, requests
+// must present it as a bearer token.
+func (h *Handler) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !h.authorizeStatus(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h.statusMu.Lock()
+		cfg := h.currentConfig()
+		folders := make([]folderStatus, 0, len(cfg.Folders))
+		for _, folder := range cfg.Folders {
+			fs := folderStatus{
+				Path:    folder.Path,
+				Branch:  folder.Branch,
+				RepoURL: folder.RepoURL,
+			}
+			if status, ok := h.status[folder.Path]; ok {
+				copied := *status
+				fs.Status = &copied
+			}
+			folders = append(folders, fs)
+		}
+		h.statusMu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"folders": folders}); err != nil {
+			h.logger.Error("Error writing status response", "error", err)
+		}
+	})
+}
+
+// authorizeStatus checks the bearer token of a request against the
+// configured status token
+func (h *Handler) authorizeStatus(r *http.Request) bool {
+	token := h.currentConfig().Server.StatusToken
+	if token == "" {
+		return true
+	}
+
+	provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
@@ -0,0 +1,111 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eliasfloreteng/github-auto-deployer/internal/config"
+)
+
+// getStatus requests the status endpoint with an optional bearer token
+func getStatus(h *Handler, method, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/status", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.StatusHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeStatus decodes the folders of a status response
+func decodeStatus(t *testing.T, rec *httptest.ResponseRecorder) []folderStatus {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var body struct {
+		Folders []folderStatus `json:"folders"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Folders
+}
+
+func TestStatusHandler(t *testing.T) {
+	folders := []config.WatchedFolder{
+		{Path: "/srv/app", Branch: "main", RepoURL: "https://github.com/acme/app"},
+		{Path: "/srv/api", Branch: "prod"},
+	}
+	h := NewHandler(&config.Config{Folders: folders})
+	h.recordStatus("/srv/app", DeployStatus{
+		LastDeploy: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		LastResult: ResultFailure,
+		LastCommit: "abc123",
+		Duration:   1500 * time.Millisecond,
+		Error:      "git pull failed",
+	})
+
+	rec := getStatus(h, http.MethodGet, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	got := decodeStatus(t, rec)
+	if len(got) != 2 {
+		t.Fatalf("%d folders, want 2", len(got))
+	}
+	if got[0].Path != "/srv/app" || got[0].Branch != "main" || got[0].RepoURL != "https://github.com/acme/app" {
+		t.Errorf("folder = %+v", got[0])
+	}
+	status := got[0].Status
+	if status == nil || status.LastResult != ResultFailure || status.LastCommit != "abc123" || status.DurationMS != 1500 || status.Error != "git pull failed" {
+		t.Errorf("status = %+v", status)
+	}
+	if got[1].Status != nil {
+		t.Errorf("status of a folder never deployed = %+v, want null", got[1].Status)
+	}
+}
+
+func TestStatusHandlerToken(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.StatusToken = "status-secret"
+	h := NewHandler(cfg)
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		want   int
+	}{
+		{"no token", http.MethodGet, "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "wrong", http.StatusUnauthorized},
+		{"valid token", http.MethodGet, "status-secret", http.StatusOK},
+		{"POST", http.MethodPost, "status-secret", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		if rec := getStatus(h, tt.method, tt.token); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestDeployFolderRecordsStatus(t *testing.T) {
+	// Pulling fails as the folder is not a repository
+	folder := config.WatchedFolder{Path: t.TempDir(), Branch: "main"}
+	h := NewHandler(&config.Config{Folders: []config.WatchedFolder{folder}})
+
+	h.deployFolder(&folder, &PushEvent{Ref: "refs/heads/main", After: "abc123"})
+
+	got := decodeStatus(t, getStatus(h, http.MethodGet, ""))
+	status := got[0].Status
+	if status == nil {
+		t.Fatal("deployment was not recorded")
+	}
+	if status.LastResult != ResultFailure || status.LastCommit != "abc123" || status.Error == "" {
+		t.Errorf("status = %+v, want a failure of abc123", status)
+	}
+}
@@ -0,0 +1,37 @@
+package webhook
+
+import "time"
+
+// lastNotification is the last failure emailed for a folder
+type lastNotification struct {
+	err string
+	at  time.Time
+}
+
+// throttleEmail reports whether a failure email for a folder should be
+// suppressed because the same error was emailed within the cooldown.
+// Otherwise the failure is recorded as emailed.
+func (h *Handler) throttleEmail(path, errMsg string) bool {
+	cooldown := h.currentConfig().SMTP.GetNotifyCooldown()
+	if cooldown <= 0 {
+		return false
+	}
+
+	h.notifiedMu.Lock()
+	defer h.notifiedMu.Unlock()
+
+	last, ok := h.notified[path]
+	if ok && last.err == errMsg && time.Since(last.at) < cooldown {
+		return true
+	}
+	h.notified[path] = lastNotification{err: errMsg, at: time.Now()}
+	return false
+}
+
+// resetThrottle forgets the last failure emailed for a folder, so the next
+// failure after a successful deployment is always emailed
+func (h *Handler) resetThrottle(path string) {
+	h.notifiedMu.Lock()
+	defer h.notifiedMu.Unlock()
+	delete(h.notified, path)
+}
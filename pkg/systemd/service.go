@@ -1,12 +1,17 @@
 package systemd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
+// DefaultName is the unit name used unless another one is given
+const DefaultName = "github-deployer"
+
 const userServiceTemplate = `[Unit]
 Description=GitHub Auto Deployer
 After=network.target
@@ -14,7 +19,8 @@ After=network.target
 [Service]
 Type=simple
 WorkingDirectory=%s
-ExecStart=%s start
+ExecStart=%s
+%sExecReload=/bin/kill -HUP $MAINPID
 Restart=always
 RestartSec=10
 StandardOutput=journal
@@ -24,49 +30,97 @@ StandardError=journal
 WantedBy=default.target
 `
 
-// Install installs the systemd user service
-func Install(execPath string) error {
+// UnitName returns the systemd unit for a service name, e.g.
+// "github-deployer-staging.service". An empty name uses DefaultName.
+func UnitName(name string) string {
+	if name == "" {
+		name = DefaultName
+	}
+	return strings.TrimSuffix(name, ".service") + ".service"
+}
+
+// ValidateName checks that a service name can be used as a unit name
+func ValidateName(name string) error {
+	base := strings.TrimSuffix(name, ".service")
+	if base == "" {
+		return fmt.Errorf("empty service name")
+	}
+	for _, r := range base {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' || r == '@') {
+			return fmt.Errorf("invalid service name %q: only letters, digits, '-', '_', '.' and '@' are allowed", name)
+		}
+	}
+	return nil
+}
+
+// unitPath returns the path of the user unit file for a service name
+func unitPath(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	return filepath.Join(home, ".config", "systemd", "user", UnitName(name)), nil
+}
+
+// runCommand runs a command and returns its combined output. Tests replace
+// it to check the commands run without calling systemctl.
+var runCommand = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// Install installs the systemd user service under the given name. The
+// service runs with the given configuration file, and env (KEY=value) is
+// set in its environment, e.g. for secrets overriding the configuration.
+func Install(name, execPath, configPath string, env []string) error {
+	if err := ValidateName(UnitName(name)); err != nil {
+		return err
+	}
+
 	// Get absolute path of executable
 	absExecPath, err := filepath.Abs(execPath)
 	if err != nil {
 		return fmt.Errorf("failed to get absolute path: %w", err)
 	}
 
-	// Get working directory (directory containing the executable)
-	workDir := filepath.Dir(absExecPath)
+	absConfigPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute config path: %w", err)
+	}
 
-	// Generate service file content
-	serviceContent := fmt.Sprintf(userServiceTemplate, workDir, absExecPath)
+	// Secrets in the environment must not be readable by other users
+	perm := os.FileMode(0644)
+	if len(env) > 0 {
+		perm = 0600
+	}
 
 	// Get user systemd directory
-	home, err := os.UserHomeDir()
+	servicePath, err := unitPath(name)
 	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+		return err
 	}
 
-	systemdUserDir := filepath.Join(home, ".config", "systemd", "user")
-	if err := os.MkdirAll(systemdUserDir, 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(servicePath), 0755); err != nil {
 		return fmt.Errorf("failed to create systemd user directory: %w", err)
 	}
 
 	// Write service file
-	servicePath := filepath.Join(systemdUserDir, "github-deployer.service")
-	if err := os.WriteFile(servicePath, []byte(serviceContent), 0644); err != nil {
+	if err := writeUnit(servicePath, renderUnit(absExecPath, absConfigPath, env), perm); err != nil {
 		return fmt.Errorf("failed to write service file: %w", err)
 	}
 
 	// Reload systemd user daemon
-	if err := exec.Command("systemctl", "--user", "daemon-reload").Run(); err != nil {
+	if _, err := runCommand("systemctl", "--user", "daemon-reload"); err != nil {
 		return fmt.Errorf("failed to reload systemd: %w", err)
 	}
 
 	// Enable service
-	if err := exec.Command("systemctl", "--user", "enable", "github-deployer.service").Run(); err != nil {
+	if _, err := runCommand("systemctl", "--user", "enable", UnitName(name)); err != nil {
 		return fmt.Errorf("failed to enable service: %w", err)
 	}
 
 	// Enable lingering so service runs even when user is not logged in
-	if err := exec.Command("loginctl", "enable-linger").Run(); err != nil {
+	if _, err := runCommand("loginctl", "enable-linger"); err != nil {
 		fmt.Println("Warning: Failed to enable lingering. Service may not start on boot.")
 		fmt.Println("You can manually enable it with: loginctl enable-linger $USER")
 	}
@@ -74,45 +128,140 @@ func Install(execPath string) error {
 	return nil
 }
 
-// Uninstall removes the systemd user service
-func Uninstall() error {
+// renderUnit returns the unit file running the executable with the given
+// configuration file and environment, working in the executable's directory
+func renderUnit(execPath, configPath string, env []string) string {
+	execStart := strings.Join([]string{quoteExecArg(execPath), "start", "--config", quoteExecArg(configPath)}, " ")
+
+	var environment strings.Builder
+	for _, variable := range env {
+		fmt.Fprintf(&environment, "Environment=%s\n", quote(variable))
+	}
+
+	return fmt.Sprintf(userServiceTemplate, filepath.Dir(execPath), execStart, environment.String())
+}
+
+// writeUnit writes a unit file through a temporary file renamed over path,
+// so the file has the given mode even when it replaces one that was more
+// permissive, and secrets are never readable in between
+func writeUnit(path, content string, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// quote quotes a value for a unit file, escaping specifiers
+func quote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "%", "%%")
+	return `"` + value + `"`
+}
+
+// quoteExecArg quotes a command line argument for ExecStart, which also
+// expands $VARIABLES
+func quoteExecArg(value string) string {
+	return quote(strings.ReplaceAll(value, "$", "$$"))
+}
+
+// Uninstall removes the systemd user service with the given name
+func Uninstall(name string) error {
 	// Stop service if running
-	exec.Command("systemctl", "--user", "stop", "github-deployer.service").Run()
+	runCommand("systemctl", "--user", "stop", UnitName(name))
 
 	// Disable service
-	exec.Command("systemctl", "--user", "disable", "github-deployer.service").Run()
+	runCommand("systemctl", "--user", "disable", UnitName(name))
 
 	// Get service file path
-	home, err := os.UserHomeDir()
+	servicePath, err := unitPath(name)
 	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+		return err
 	}
 
-	servicePath := filepath.Join(home, ".config", "systemd", "user", "github-deployer.service")
 	if err := os.Remove(servicePath); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("failed to remove service file: %w", err)
 	}
 
 	// Reload systemd user daemon
-	if err := exec.Command("systemctl", "--user", "daemon-reload").Run(); err != nil {
+	if _, err := runCommand("systemctl", "--user", "daemon-reload"); err != nil {
 		return fmt.Errorf("failed to reload systemd: %w", err)
 	}
 
 	return nil
 }
 
+// ErrNotInstalled is returned when controlling a service that is not installed
+var ErrNotInstalled = errors.New("service is not installed, run 'deployer install' first")
+
+// IsInstalled reports whether the service file exists
+func IsInstalled(name string) bool {
+	servicePath, err := unitPath(name)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(servicePath)
+	return err == nil
+}
+
+// Available reports whether systemctl can manage user services, i.e.
+// systemd is installed and a user service manager is running
+func Available() bool {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return false
+	}
+
+	return exec.Command("systemctl", "--user", "show-environment").Run() == nil
+}
+
 // Start starts the service
-func Start() error {
-	return exec.Command("systemctl", "--user", "start", "github-deployer.service").Run()
+func Start(name string) error {
+	return control(name, "start")
 }
 
 // Stop stops the service
-func Stop() error {
-	return exec.Command("systemctl", "--user", "stop", "github-deployer.service").Run()
+func Stop(name string) error {
+	return control(name, "stop")
+}
+
+// Restart restarts the service, starting it if it is not running
+func Restart(name string) error {
+	return control(name, "restart")
+}
+
+// control runs a systemctl action on the installed service
+func control(name, action string) error {
+	if !IsInstalled(name) {
+		return ErrNotInstalled
+	}
+
+	output, err := runCommand("systemctl", "--user", action, UnitName(name))
+	if err != nil {
+		return fmt.Errorf("systemctl %s failed: %w\nOutput: %s", action, err, string(output))
+	}
+
+	return nil
 }
 
 // Status returns the service status
-func Status() (string, error) {
-	output, err := exec.Command("systemctl", "--user", "status", "github-deployer.service").CombinedOutput()
+func Status(name string) (string, error) {
+	output, err := runCommand("systemctl", "--user", "status", UnitName(name))
 	return string(output), err
 }
@@ -0,0 +1,261 @@
+package systemd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeSystemctl replaces runCommand for the duration of the test, records
+// the command lines run and makes commands matching fail return an error.
+// It also points the home directory at a temporary directory and returns
+// the recorded commands.
+func fakeSystemctl(t *testing.T, fail string) *[]string {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+
+	var calls []string
+	original := runCommand
+	runCommand = func(name string, args ...string) ([]byte, error) {
+		line := strings.Join(append([]string{name}, args...), " ")
+		calls = append(calls, line)
+		if fail != "" && strings.Contains(line, fail) {
+			return []byte("boom"), errors.New("exit status 1")
+		}
+		return nil, nil
+	}
+	t.Cleanup(func() { runCommand = original })
+	return &calls
+}
+
+func TestInstall(t *testing.T) {
+	calls := fakeSystemctl(t, "")
+	execPath := filepath.Join(t.TempDir(), "deployer")
+
+	if err := Install("", execPath, "/etc/github-deployer/config.json", nil); err != nil {
+		t.Fatalf("Install returned error: %v", err)
+	}
+
+	home, _ := os.UserHomeDir()
+	unit, err := os.ReadFile(filepath.Join(home, ".config", "systemd", "user", "github-deployer.service"))
+	if err != nil {
+		t.Fatalf("unit file not written: %v", err)
+	}
+	if !strings.Contains(string(unit), `ExecStart="`+execPath+`" start --config "/etc/github-deployer/config.json"`) {
+		t.Errorf("unit file does not start %s:\n%s", execPath, unit)
+	}
+
+	want := []string{
+		"systemctl --user daemon-reload",
+		"systemctl --user enable github-deployer.service",
+		"loginctl enable-linger",
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("commands = %q, want %q", *calls, want)
+	}
+}
+
+func TestInstallReportsSystemctlFailure(t *testing.T) {
+	fakeSystemctl(t, "enable github-deployer")
+
+	err := Install("", filepath.Join(t.TempDir(), "deployer"), "/etc/github-deployer/config.json", nil)
+	if err == nil || !strings.Contains(err.Error(), "failed to enable service") {
+		t.Errorf("Install() = %v, want an enable error", err)
+	}
+}
+
+func TestControl(t *testing.T) {
+	tests := []struct {
+		name   string
+		action func(name string) error
+		want   string
+	}{
+		{"start", Start, "systemctl --user start github-deployer-staging.service"},
+		{"stop", Stop, "systemctl --user stop github-deployer-staging.service"},
+		{"restart", Restart, "systemctl --user restart github-deployer-staging.service"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := fakeSystemctl(t, "")
+			if err := tt.action("github-deployer-staging"); !errors.Is(err, ErrNotInstalled) {
+				t.Errorf("%s without a unit file = %v, want ErrNotInstalled", tt.name, err)
+			}
+			if len(*calls) != 0 {
+				t.Errorf("commands run without a unit file: %q", *calls)
+			}
+
+			if err := Install("github-deployer-staging", "/usr/local/bin/deployer", "/etc/github-deployer/config.json", nil); err != nil {
+				t.Fatal(err)
+			}
+			*calls = nil
+
+			if err := tt.action("github-deployer-staging"); err != nil {
+				t.Fatalf("%s returned error: %v", tt.name, err)
+			}
+			if !reflect.DeepEqual(*calls, []string{tt.want}) {
+				t.Errorf("commands = %q, want %q", *calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestControlReportsOutput(t *testing.T) {
+	fakeSystemctl(t, "restart")
+	if err := Install("github-deployer-staging", "/usr/local/bin/deployer", "/etc/github-deployer/config.json", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	err := Restart("github-deployer-staging")
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Restart() = %v, want the systemctl output in the error", err)
+	}
+}
+
+func TestUninstall(t *testing.T) {
+	calls := fakeSystemctl(t, "")
+	if err := Install("github-deployer-staging", "/usr/local/bin/deployer", "/etc/github-deployer/config.json", nil); err != nil {
+		t.Fatal(err)
+	}
+	*calls = nil
+
+	if err := Uninstall("github-deployer-staging"); err != nil {
+		t.Fatalf("Uninstall returned error: %v", err)
+	}
+	if IsInstalled("github-deployer-staging") {
+		t.Error("unit file still exists after Uninstall")
+	}
+
+	want := []string{
+		"systemctl --user stop github-deployer-staging.service",
+		"systemctl --user disable github-deployer-staging.service",
+		"systemctl --user daemon-reload",
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("commands = %q, want %q", *calls, want)
+	}
+}
+
+func TestUnitName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", "github-deployer.service"},
+		{"github-deployer-staging", "github-deployer-staging.service"},
+		{"app.service", "app.service"},
+	}
+
+	for _, tt := range tests {
+		if got := UnitName(tt.name); got != tt.want {
+			t.Errorf("UnitName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"github-deployer", "deployer_2.service", "deployer@staging"} {
+		if err := ValidateName(name); err != nil {
+			t.Errorf("ValidateName(%q) returned error: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", ".service", "../deployer", "my deployer"} {
+		if err := ValidateName(name); err == nil {
+			t.Errorf("ValidateName(%q) returned no error", name)
+		}
+	}
+}
+
+func TestInstallUnderName(t *testing.T) {
+	calls := fakeSystemctl(t, "")
+
+	if err := Install("github-deployer-staging", "/usr/local/bin/deployer", "/etc/github-deployer/config.json", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !IsInstalled("github-deployer-staging") || IsInstalled("") {
+		t.Error("unit file not written under the given name")
+	}
+	if want := "systemctl --user enable github-deployer-staging.service"; (*calls)[1] != want {
+		t.Errorf("enable command = %q, want %q", (*calls)[1], want)
+	}
+
+	*calls = nil
+	if err := Install("../escape", "/usr/local/bin/deployer", "/etc/github-deployer/config.json", nil); err == nil {
+		t.Error("Install with an invalid name returned no error")
+	}
+	if len(*calls) != 0 {
+		t.Errorf("commands run for an invalid name: %q", *calls)
+	}
+}
+
+func TestRenderUnit(t *testing.T) {
+	unit := renderUnit("/opt/deploy $HOME/deployer", "/etc/deployer 100%/config.yaml", []string{
+		"DEPLOYER_SMTP_PASSWORD=p\"a%ss",
+		"DEPLOYER_WEBHOOK_SECRET=secret",
+	})
+
+	for _, want := range []string{
+		"WorkingDirectory=/opt/deploy $HOME\n",
+		`ExecStart="/opt/deploy $$HOME/deployer" start --config "/etc/deployer 100%%/config.yaml"` + "\n",
+		`Environment="DEPLOYER_SMTP_PASSWORD=p\"a%%ss"` + "\n",
+		`Environment="DEPLOYER_WEBHOOK_SECRET=secret"` + "\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("unit does not contain %q:\n%s", want, unit)
+		}
+	}
+
+	if unit := renderUnit("/usr/local/bin/deployer", "/etc/github-deployer/config.json", nil); strings.Contains(unit, "Environment=") {
+		t.Errorf("unit without overrides has Environment lines:\n%s", unit)
+	}
+}
+
+func TestInstallProtectsSecrets(t *testing.T) {
+	fakeSystemctl(t, "")
+	servicePath, err := unitPath("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Install("", "/usr/local/bin/deployer", "config.json", nil); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(servicePath); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("unit without secrets: %v, %v, want mode 644", info.Mode().Perm(), err)
+	}
+
+	// Reinstalling with secrets over the existing unit must tighten its mode
+	if err := Install("", "/usr/local/bin/deployer", "config.json", []string{"DEPLOYER_WEBHOOK_SECRET=secret"}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(servicePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("unit with secrets has mode %o, want 600", perm)
+	}
+
+	unit, err := os.ReadFile(servicePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(unit), `Environment="DEPLOYER_WEBHOOK_SECRET=secret"`) {
+		t.Errorf("unit does not set the override:\n%s", unit)
+	}
+	if cwd, _ := os.Getwd(); !strings.Contains(string(unit), `--config "`+filepath.Join(cwd, "config.json")+`"`) {
+		t.Errorf("unit does not use the absolute config path:\n%s", unit)
+	}
+
+	// No temporary files are left next to the unit
+	entries, err := os.ReadDir(filepath.Dir(servicePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("unit directory holds %d files, want only the unit", len(entries))
+	}
+}